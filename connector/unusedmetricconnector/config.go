@@ -0,0 +1,124 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricconnector // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector"
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pipeline"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+var defaultActionExpiry = time.Hour
+
+type Config struct {
+	// prevents unkeyed literal initialization
+	_ struct{}
+
+	// connection to the server and decisions, ignored when a usage extension is referenced
+	usagestore.Config `mapstructure:",squash"`
+
+	// component ID of an unusedmetricusage extension shared with the processors,
+	// which then owns the connection to the server and the decisions
+	Usage *component.ID `mapstructure:"usage"`
+
+	// requests to the server running in parallel, only bounding the lookups
+	// of a batch when a usage extension is referenced
+	// default is 8
+	MaxConcurrentLookups int `mapstructure:"max_concurrent_lookups"`
+
+	// time a batch spends resolving decisions, unresolved ones use the failure action
+	// default is the server timeout
+	MaxBatchLookupTime time.Duration `mapstructure:"max_batch_lookup_time"`
+
+	// metrics matching an exemption are always kept until the exemption expires
+	Exemptions rules.ExemptionsConfig `mapstructure:"exemptions"`
+
+	// OTTL conditions that keep metrics without consulting the server
+	KeepConditions rules.ConditionsConfig `mapstructure:"keep_conditions"`
+
+	// OTTL conditions that drop metrics without consulting the server
+	DropConditions rules.ConditionsConfig `mapstructure:"drop_conditions"`
+
+	// how errors evaluating conditions are handled: ignore, silent or propagate,
+	// which rejects the whole batch
+	// default is ignore
+	ErrorMode ottl.ErrorMode `mapstructure:"error_mode"`
+
+	// metrics to metrics: pipelines receiving the used metrics
+	UsedPipelines []pipeline.ID `mapstructure:"used_pipelines"`
+
+	// metrics to metrics: pipelines receiving the unused metrics
+	UnusedPipelines []pipeline.ID `mapstructure:"unused_pipelines"`
+
+	// metrics to metrics: route metrics whose decision could not be resolved
+	// with the used (keep) or the unused (drop) metrics
+	// default is keep
+	FailureAction string `mapstructure:"failure_action"`
+
+	// metrics to logs: records of the decision changes
+	Logs LogsConfig `mapstructure:"logs"`
+}
+
+type LogsConfig struct {
+	// emit a record for the first action applied to a metric of a job, as
+	// well as for its changes; after a restart, every metric is seen for
+	// the first time again
+	// default is false
+	EmitFirstSeen bool `mapstructure:"emit_first_seen"`
+
+	// time after which the last action of a metric of a job that is no
+	// longer received is forgotten
+	// default is 1 hour
+	ActionExpiry time.Duration `mapstructure:"action_expiry"`
+}
+
+func (c *Config) Validate() error {
+	if c.Usage == nil && c.Server.Address == "" {
+		return errors.New("server address is required")
+	}
+	if c.Usage != nil && c.Server.Address != "" {
+		return errors.New("server address must not be set when a usage extension is referenced")
+	}
+	switch c.FailureAction {
+	case "":
+		c.FailureAction = actionKeep
+	case actionKeep, actionDrop:
+	default:
+		return fmt.Errorf("failure_action must be %q or %q, got %q", actionKeep, actionDrop, c.FailureAction)
+	}
+	if c.Logs.ActionExpiry <= 0 {
+		c.Logs.ActionExpiry = defaultActionExpiry
+	}
+	c.Config.MaxConcurrentLookups = c.MaxConcurrentLookups
+	if err := c.Config.Validate(); err != nil {
+		return err
+	}
+	c.MaxConcurrentLookups = c.Config.MaxConcurrentLookups
+	if c.MaxBatchLookupTime <= 0 {
+		c.MaxBatchLookupTime = *c.Server.Timeout
+	}
+	rulesConfig := c.rulesConfig()
+	if err := rulesConfig.Validate(); err != nil {
+		return err
+	}
+	c.Exemptions, c.ErrorMode = rulesConfig.Exemptions, rulesConfig.ErrorMode
+	return nil
+}
+
+// rulesConfig returns the rules configured at the top level.
+func (c *Config) rulesConfig() rules.Config {
+	return rules.Config{
+		Exemptions:     c.Exemptions,
+		KeepConditions: c.KeepConditions,
+		DropConditions: c.DropConditions,
+		ErrorMode:      c.ErrorMode,
+	}
+}
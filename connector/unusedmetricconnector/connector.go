@@ -0,0 +1,291 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricconnector // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector"
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/connector"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+const (
+	actionKeep = rules.ActionKeep
+	actionDrop = rules.ActionDrop
+)
+
+// unusedMetricConnector resolves the decisions of the metrics it receives,
+// from its own store or from the store of a usage extension. It is shared by
+// the connectors of every signal created for a component ID.
+type unusedMetricConnector struct {
+	config *Config
+	rules  *rules.Rules
+
+	// decisions of the server, owned by the connector unless it references
+	// a usage extension, in which case it is resolved on start
+	store    *usagestore.Store
+	ownStore bool
+
+	// registry the connector was acquired from, nil if it is not shared
+	shared    *sharedConnectors
+	id        component.ID
+	startOnce sync.Once
+	startErr  error
+
+	logger *zap.Logger
+}
+
+func newUnusedMetricConnector(set connector.Settings, cfg *Config, client usage.Client) (*unusedMetricConnector, error) {
+	logger := set.Logger.With(zap.String("component", "unusedmetricconnector"))
+	rs, err := rules.New(cfg.rulesConfig(), set.TelemetrySettings, logger)
+	if err != nil {
+		return nil, err
+	}
+	c := &unusedMetricConnector{
+		config: cfg,
+		rules:  rs,
+		id:     set.ID,
+		logger: logger,
+	}
+	if cfg.Usage == nil {
+		c.store = usagestore.NewStore(usagestore.Settings{
+			ID:             set.ID,
+			Kind:           component.KindConnector,
+			Logger:         logger,
+			TracerProvider: set.TracerProvider,
+		}, &cfg.Config, client)
+		c.ownStore = true
+	}
+	return c, nil
+}
+
+// Start starts the connector once, however many signals share it.
+func (c *unusedMetricConnector) Start(ctx context.Context, host component.Host) error {
+	c.startOnce.Do(func() {
+		c.startErr = c.start(ctx, host)
+	})
+	return c.startErr
+}
+
+func (c *unusedMetricConnector) start(ctx context.Context, host component.Host) error {
+	c.rules.Start()
+	if c.ownStore {
+		return c.store.Start(ctx, host)
+	}
+	store, err := usagestore.GetStore(host, *c.config.Usage)
+	if err != nil {
+		return err
+	}
+	c.store = store
+	return nil
+}
+
+// Shutdown shuts the connector down once the last signal sharing it does.
+func (c *unusedMetricConnector) Shutdown(ctx context.Context) error {
+	if c.shared != nil && !c.shared.release(c) {
+		return nil
+	}
+	c.rules.Shutdown()
+	if c.ownStore {
+		return c.store.Shutdown(ctx)
+	}
+	return nil
+}
+
+// sharedConnectors holds the connectors shared by the signals of a
+// component ID, which the collector creates, starts and shuts down
+// separately, so they use a single store and set of exemptions.
+type sharedConnectors struct {
+	mu      sync.Mutex
+	entries map[component.ID]*sharedConnector
+}
+
+type sharedConnector struct {
+	connector *unusedMetricConnector
+	// signals using the connector that were not shut down yet
+	refs int
+}
+
+var connectors = &sharedConnectors{entries: map[component.ID]*sharedConnector{}}
+
+// acquire returns the connector of the component ID, created by create if
+// no signal uses it yet.
+func (s *sharedConnectors) acquire(id component.ID, create func() (*unusedMetricConnector, error)) (*unusedMetricConnector, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		c, err := create()
+		if err != nil {
+			return nil, err
+		}
+		c.shared = s
+		entry = &sharedConnector{connector: c}
+		s.entries[id] = entry
+	}
+	entry.refs++
+	return entry.connector, nil
+}
+
+// release reports whether the connector is no longer used by any signal,
+// in which case it is forgotten and has to be shut down.
+func (s *sharedConnectors) release(c *unusedMetricConnector) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[c.id]
+	if !ok || entry.connector != c {
+		return false
+	}
+	entry.refs--
+	if entry.refs > 0 {
+		return false
+	}
+	delete(s.entries, c.id)
+	return true
+}
+
+// decision is the action the usage of a metric of a job calls for.
+type decision struct {
+	action string
+	reason string
+	// the answer of the server, without a summary for the snapshot jobs and
+	// the metrics decided before asking it
+	usage usage.MetricUsage
+}
+
+// decide resolves the decisions of the data points of the batch, in the
+// order metricdata walks them, the way the processor does: a keep override
+// wins, then an exemption, then the keep and drop conditions. The snapshot
+// jobs are answered from the decision index and the other metrics are
+// looked up, concurrently for those missing from the cache and within the
+// lookup time budget of a batch. The decisions of the data points whose
+// lookup failed are nil. Errors evaluating the conditions are returned.
+func (c *unusedMetricConnector) decide(ctx context.Context, md pmetric.Metrics) ([]*decision, error) {
+	now := time.Now()
+	var (
+		points []*decision
+		keys   []usagestore.Key
+		errs   error
+	)
+	// data points waiting for the decision of the server, by key
+	pending := map[usagestore.Key][]int{}
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		rm := md.ResourceMetrics().At(i)
+		resource := rm.Resource().Attributes()
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				m := sm.Metrics().At(k)
+				result, err := c.rules.EvalMetric(ctx, rm, sm, m)
+				if err != nil {
+					return nil, err
+				}
+				metricdata.ForEachDatapoint(m, func(dp any, attrs pcommon.Map) {
+					key := usagestore.Key{Job: metricdata.Job(attrs), Metric: m.Name()}
+					condition, err := c.rules.EvalDatapoint(ctx, rm, sm, m, result, dp)
+					if err != nil {
+						errs = multierr.Append(errs, err)
+						points = append(points, nil)
+						return
+					}
+					if v, ok := c.rules.Preceding(now, c.store, key.Job, key.Metric, resource, condition); ok {
+						points = append(points, newRuleDecision(key, v))
+						return
+					}
+					if _, ok := pending[key]; !ok {
+						keys = append(keys, key)
+					}
+					pending[key] = append(pending[key], len(points))
+					points = append(points, nil)
+				})
+			}
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	for key, d := range c.resolve(ctx, now, keys) {
+		for _, i := range pending[key] {
+			points[i] = d
+		}
+	}
+	return points, nil
+}
+
+// resolve returns the decisions of the server for the keys, leaving out
+// those whose lookup failed.
+func (c *unusedMetricConnector) resolve(ctx context.Context, now time.Time, keys []usagestore.Key) map[usagestore.Key]*decision {
+	snapshotJobs := c.store.SnapshotJobs()
+	decisions := make(map[usagestore.Key]*decision, len(keys))
+	var lookups []usagestore.Key
+	for _, key := range keys {
+		if slices.Contains(snapshotJobs, key.Job) {
+			decisions[key] = newDecision(usage.MetricUsage{Name: key.Metric, Unused: c.store.Unused(key)})
+			continue
+		}
+		if entry, ok := c.store.Cached(key, now); ok {
+			decisions[key] = newDecision(entry.Usage)
+			continue
+		}
+		lookups = append(lookups, key)
+	}
+	if len(lookups) == 0 {
+		return decisions
+	}
+
+	failed, timedOut := 0, 0
+	results := c.store.LookupBatch(ctx, lookups, c.config.MaxConcurrentLookups, c.config.MaxBatchLookupTime)
+	for i, key := range lookups {
+		if results[i].Err != nil {
+			failed++
+			if results[i].TimedOut {
+				timedOut++
+			}
+			continue
+		}
+		decisions[key] = newDecision(results[i].Decision.Usage)
+	}
+	if failed > 0 {
+		c.logger.Debug("decisions could not be resolved",
+			zap.Int("failed", failed),
+			zap.Int("timed_out", timedOut),
+			zap.Int("decisions", len(keys)),
+		)
+	}
+	return decisions
+}
+
+func newDecision(u usage.MetricUsage) *decision {
+	d := &decision{action: actionKeep, reason: usagestore.Reason(u), usage: u}
+	if u.Unused {
+		d.action = actionDrop
+	}
+	return d
+}
+
+// newRuleDecision returns the decision of a verdict taking precedence over
+// the decision of the server.
+func newRuleDecision(key usagestore.Key, v rules.Verdict) *decision {
+	reason := v.Reason
+	switch v.Source {
+	case rules.SourceOverride:
+		reason = "kept by override: " + v.Reason
+	case rules.SourceExemption:
+		reason = "kept by exemption: " + v.Reason
+	}
+	return &decision{action: v.Action, reason: reason, usage: usage.MetricUsage{Name: key.Metric}}
+}
@@ -0,0 +1,202 @@
+package unusedmetricconnector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/connector"
+	"go.opentelemetry.io/collector/connector/connectortest"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pipeline"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+type fakeClient struct {
+	mu sync.Mutex
+	// map[job][metricName] => unused, used metrics have a summary
+	decisions map[string]map[string]bool
+	// optional error injection per metric
+	errFor map[string]error
+	// lookups of the metrics wait until their channel is closed
+	gates map[string]chan struct{}
+}
+
+func (f *fakeClient) set(job string, metric string, unused bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.decisions[job][metric] = unused
+}
+
+func (f *fakeClient) GetMetricUsage(_ context.Context, job string, name string) (usage.MetricUsage, error) {
+	if gate, ok := f.gates[name]; ok {
+		<-gate
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errFor[name]; err != nil {
+		return usage.MetricUsage{}, err
+	}
+	u := usage.MetricUsage{Name: name, Unused: f.decisions[job][name]}
+	if !u.Unused {
+		u.Summary = &usage.MetricUsageSummary{AlertCount: 1, DashboardCount: 2}
+	}
+	return u, nil
+}
+
+func (f *fakeClient) ListMetricUsage(context.Context, string) ([]usage.MetricUsage, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) StreamMetricUsage(context.Context, string, string, func(usage.MetricUsage) error) (usage.StreamResult, error) {
+	return usage.StreamResult{}, nil
+}
+
+func (f *fakeClient) WatchMetricUsage(ctx context.Context, _ string, _ func(usage.WatchEvent) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeClient) Ping(context.Context) error {
+	return nil
+}
+
+func (f *fakeClient) CloseIdleConnections() {}
+
+// newTestConnector starts a connector owning a store that asks f for the
+// decisions, it is shut down at the end of the test.
+func newTestConnector(t *testing.T, f *fakeClient) *unusedMetricConnector {
+	return newConfiguredTestConnector(t, f, nil)
+}
+
+func newConfiguredTestConnector(t *testing.T, f *fakeClient, configure func(*Config)) *unusedMetricConnector {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	if configure != nil {
+		configure(cfg)
+	}
+	require.NoError(t, cfg.Validate())
+	c, err := newUnusedMetricConnector(connectortest.NewNopSettings(metadata.Type), cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, c.Start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, c.Shutdown(context.Background())) })
+	return c
+}
+
+// newBatch returns a gauge per name, with a data point per job.
+func newBatch(names []string, jobs ...string) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.namespace", "shop")
+	metrics := rm.ScopeMetrics().AppendEmpty().Metrics()
+	for _, name := range names {
+		m := metrics.AppendEmpty()
+		m.SetName(name)
+		dps := m.SetEmptyGauge().DataPoints()
+		for _, job := range jobs {
+			dps.AppendEmpty().Attributes().PutStr("job", job)
+		}
+	}
+	return md
+}
+
+func TestDecide(t *testing.T) {
+	gate := make(chan struct{})
+	defer close(gate)
+	f := &fakeClient{
+		decisions: map[string]map[string]bool{"myJob": {"unused": true, "used": false}},
+		errFor:    map[string]error{"failing": errors.New("boom")},
+		gates:     map[string]chan struct{}{"slow": gate},
+	}
+	c := newConfiguredTestConnector(t, f, func(cfg *Config) {
+		cfg.MaxBatchLookupTime = 50 * time.Millisecond
+		cfg.Exemptions.Rules = []rules.ExemptionRule{{
+			MetricName: "exempted",
+			Owner:      "team-a",
+			Reason:     "migration",
+			ExpiresAt:  "2030-01-01",
+		}}
+		cfg.DropConditions.Metric = []string{`name == "dropped"`}
+	})
+	c.store.AddOverride(usagestore.Override{Job: "myJob", Metric: "overridden", Reason: "INC-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	md := newBatch([]string{"unused", "used", "overridden", "failing", "exempted", "dropped", "slow"}, "myJob")
+	points, err := c.decide(context.Background(), md)
+	require.NoError(t, err)
+	require.Len(t, points, 7)
+	require.Equal(t, actionDrop, points[0].action)
+	require.Equal(t, actionKeep, points[1].action)
+	require.Equal(t, 1, points[1].usage.Summary.AlertCount)
+	require.Equal(t, &decision{action: actionKeep, reason: "kept by override: INC-1", usage: usage.MetricUsage{Name: "overridden"}}, points[2])
+	require.Nil(t, points[3])
+	require.Equal(t, &decision{action: actionKeep, reason: "kept by exemption: migration", usage: usage.MetricUsage{Name: "exempted"}}, points[4])
+	require.Equal(t, &decision{action: actionDrop, reason: "matched a drop condition", usage: usage.MetricUsage{Name: "dropped"}}, points[5])
+	// the lookup budget of the batch is exhausted
+	require.Nil(t, points[6])
+}
+
+func TestDecideConditionErrors(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {}}}
+	c := newConfiguredTestConnector(t, f, func(cfg *Config) {
+		cfg.ErrorMode = ottl.PropagateError
+		// fails to evaluate for every data point
+		cfg.DropConditions.Datapoint = []string{`ParseJSON("{") != nil`}
+	})
+	_, err := c.decide(context.Background(), newBatch([]string{"used"}, "myJob"))
+	require.Error(t, err)
+}
+
+func TestConnectorSharedBySignals(t *testing.T) {
+	factory := NewFactory()
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.UsedPipelines = []pipeline.ID{pipeline.NewID(pipeline.SignalMetrics)}
+	set := connectortest.NewNopSettings(metadata.Type)
+	router := connector.NewMetricsRouter(map[pipeline.ID]consumer.Metrics{
+		pipeline.NewID(pipeline.SignalMetrics): consumertest.NewNop(),
+	})
+
+	metrics, err := factory.CreateMetricsToMetrics(context.Background(), set, cfg, router)
+	require.NoError(t, err)
+	logs, err := factory.CreateMetricsToLogs(context.Background(), set, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+	shared := metrics.(*metricsConnector).unusedMetricConnector
+	require.Same(t, shared, logs.(*logsConnector).unusedMetricConnector)
+
+	host := componenttest.NewNopHost()
+	require.NoError(t, metrics.Start(context.Background(), host))
+	require.NoError(t, logs.Start(context.Background(), host))
+	require.NoError(t, metrics.Shutdown(context.Background()))
+	require.Contains(t, connectors.entries, set.ID)
+	require.NoError(t, logs.Shutdown(context.Background()))
+	require.NotContains(t, connectors.entries, set.ID)
+
+	// a new connector is created once every signal shut down
+	logs, err = factory.CreateMetricsToLogs(context.Background(), set, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+	require.NotSame(t, shared, logs.(*logsConnector).unusedMetricConnector)
+	require.NoError(t, logs.Shutdown(context.Background()))
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	require.ErrorContains(t, cfg.Validate(), "server address is required")
+
+	usageID := component.MustNewID("unusedmetricusage")
+	cfg.Usage = &usageID
+	require.NoError(t, cfg.Validate())
+	cfg.Server.Address = "http://localhost:0"
+	require.ErrorContains(t, cfg.Validate(), "server address must not be set")
+}
@@ -0,0 +1,8 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:generate mdatagen metadata.yaml
+
+// Package unusedmetricconnector reports and routes metrics according to the
+// usage decisions of the Prometheus analytics server.
+package unusedmetricconnector // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector"
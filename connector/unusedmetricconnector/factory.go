@@ -0,0 +1,97 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricconnector // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/connector"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pipeline"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// NewFactory returns a new factory for the unusedmetric connector.
+func NewFactory() connector.Factory {
+	return connector.NewFactory(
+		metadata.Type,
+		createDefaultConfig,
+		connector.WithMetricsToMetrics(createMetricsToMetrics, metadata.MetricsToMetricsStability),
+		connector.WithMetricsToLogs(createMetricsToLogs, metadata.MetricsToLogsStability))
+}
+
+func createDefaultConfig() component.Config {
+	return &Config{}
+}
+
+func createMetricsToMetrics(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	next consumer.Metrics,
+) (connector.Metrics, error) {
+	config := cfg.(*Config)
+	router, ok := next.(connector.MetricsRouterAndConsumer)
+	if !ok {
+		return nil, errors.New("expected the consumer to be a connector router")
+	}
+	if len(config.UsedPipelines) == 0 && len(config.UnusedPipelines) == 0 {
+		return nil, errors.New("used_pipelines or unused_pipelines are required in a metrics to metrics pipeline")
+	}
+	used, err := pipelinesConsumer(router, config.UsedPipelines)
+	if err != nil {
+		return nil, fmt.Errorf("used_pipelines: %w", err)
+	}
+	unused, err := pipelinesConsumer(router, config.UnusedPipelines)
+	if err != nil {
+		return nil, fmt.Errorf("unused_pipelines: %w", err)
+	}
+	c, err := newConnector(set, config)
+	if err != nil {
+		return nil, err
+	}
+	return newMetricsConnector(c, used, unused), nil
+}
+
+// pipelinesConsumer returns the consumer of the pipelines, nil if there are none.
+func pipelinesConsumer(router connector.MetricsRouterAndConsumer, ids []pipeline.ID) (consumer.Metrics, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return router.Consumer(ids...)
+}
+
+func createMetricsToLogs(
+	_ context.Context,
+	set connector.Settings,
+	cfg component.Config,
+	next consumer.Logs,
+) (connector.Metrics, error) {
+	c, err := newConnector(set, cfg.(*Config))
+	if err != nil {
+		return nil, err
+	}
+	return newLogsConnector(c, next), nil
+}
+
+// newConnector returns the connector shared by the signals of the component
+// ID, validating the configuration, as the client needs its defaults, when
+// it is created.
+func newConnector(set connector.Settings, cfg *Config) (*unusedMetricConnector, error) {
+	return connectors.acquire(set.ID, func() (*unusedMetricConnector, error) {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		var client usage.Client
+		if cfg.Usage == nil {
+			client = usage.NewClient(cfg.ClientConfig(), usage.WithTracerProvider(set.TracerProvider))
+		}
+		return newUnusedMetricConnector(set, cfg, client)
+	})
+}
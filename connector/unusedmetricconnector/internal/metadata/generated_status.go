@@ -0,0 +1,17 @@
+// Code generated by mdatagen. DO NOT EDIT.
+
+package metadata
+
+import (
+	"go.opentelemetry.io/collector/component"
+)
+
+var (
+	Type      = component.MustNewType("unusedmetric")
+	ScopeName = "github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector"
+)
+
+const (
+	MetricsToMetricsStability = component.StabilityLevelAlpha
+	MetricsToLogsStability    = component.StabilityLevelAlpha
+)
@@ -0,0 +1,215 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricconnector // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector"
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/pdatautil"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+// Event name and attributes of the log records of the decision changes.
+const (
+	eventDecisionChange = "unusedmetric.decision_change"
+
+	attributeMetricType     = "unusedmetric.metric_type"
+	attributeAction         = "unusedmetric.action"
+	attributePreviousAction = "unusedmetric.previous_action"
+	attributeReason         = "unusedmetric.reason"
+	attributeAlerts         = "unusedmetric.alerts"
+	attributeRecordingRules = "unusedmetric.recording_rules"
+	attributeDashboards     = "unusedmetric.dashboards"
+	attributeQueries        = "unusedmetric.queries"
+	attributeSeries         = "unusedmetric.series"
+	attributeBytes          = "unusedmetric.bytes"
+)
+
+// logsConnector emits a log record every time the action applied to a
+// metric of a job changes, and optionally the first time it is seen, so the
+// decisions can be searched in a log backend.
+type logsConnector struct {
+	*unusedMetricConnector
+	next consumer.Logs
+
+	mu sync.Mutex
+	// last action applied per key, forgotten once the key is not seen for
+	// the action expiry
+	actions map[usagestore.Key]loggedAction
+	// last time the expired actions were forgotten
+	expired time.Time
+}
+
+type loggedAction struct {
+	action string
+	seen   time.Time
+}
+
+func newLogsConnector(c *unusedMetricConnector, next consumer.Logs) *logsConnector {
+	return &logsConnector{
+		unusedMetricConnector: c,
+		next:                  next,
+		actions:               map[usagestore.Key]loggedAction{},
+		expired:               time.Now(),
+	}
+}
+
+func (c *logsConnector) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+// observation describes the data points of a metric of a job in a batch.
+type observation struct {
+	// resource the metric was first seen with
+	resource   pcommon.Resource
+	metricType pmetric.MetricType
+	// distinct attribute sets and estimated size of the data points
+	series map[[16]byte]struct{}
+	bytes  int64
+}
+
+func (c *logsConnector) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	points, err := c.decide(ctx, md)
+	if err != nil {
+		return err
+	}
+	observations := map[usagestore.Key]*observation{}
+	// a metric of a job is dropped when all its resolved data points are
+	decisions := map[usagestore.Key]*decision{}
+	// keys in the order they were seen, so the records are too
+	var keys []usagestore.Key
+	point := 0
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		rm := md.ResourceMetrics().At(i)
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				m := sm.Metrics().At(k)
+				metricdata.ForEachDatapoint(m, func(dp any, attrs pcommon.Map) {
+					key := usagestore.Key{Job: metricdata.Job(attrs), Metric: m.Name()}
+					o, ok := observations[key]
+					if !ok {
+						o = &observation{resource: rm.Resource(), metricType: m.Type(), series: map[[16]byte]struct{}{}}
+						observations[key] = o
+						keys = append(keys, key)
+					}
+					o.series[pdatautil.MapHash(attrs)] = struct{}{}
+					o.bytes += metricdata.EstimatedSize(dp, attrs)
+					if d := points[point]; d != nil {
+						if current, ok := decisions[key]; !ok || (current.action == actionDrop && d.action == actionKeep) {
+							decisions[key] = d
+						}
+					}
+					point++
+				})
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	seen := time.Now()
+	c.expireActions(seen)
+	now := pcommon.NewTimestampFromTime(seen)
+	ld := plog.NewLogs()
+	scopes := map[pcommon.Resource]plog.LogRecordSlice{}
+	for _, key := range keys {
+		d, ok := decisions[key]
+		if !ok {
+			continue
+		}
+		previous, changed := c.swapAction(key, d.action, seen)
+		if !changed {
+			continue
+		}
+		o := observations[key]
+		records, ok := scopes[o.resource]
+		if !ok {
+			rl := ld.ResourceLogs().AppendEmpty()
+			o.resource.CopyTo(rl.Resource())
+			sl := rl.ScopeLogs().AppendEmpty()
+			sl.Scope().SetName(metadata.ScopeName)
+			records = sl.LogRecords()
+			scopes[o.resource] = records
+		}
+		newDecisionRecord(records.AppendEmpty(), now, key, o, d, previous)
+	}
+	if ld.LogRecordCount() == 0 {
+		return nil
+	}
+	return c.next.ConsumeLogs(ctx, ld)
+}
+
+// swapAction records the action of the key and returns the previous one,
+// and whether a record is emitted: when the action changed, or when the key
+// is seen for the first time if those records are enabled.
+func (c *logsConnector) swapAction(key usagestore.Key, action string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, ok := c.actions[key]
+	c.actions[key] = loggedAction{action: action, seen: now}
+	if !ok {
+		return "", c.config.Logs.EmitFirstSeen
+	}
+	return previous.action, previous.action != action
+}
+
+// expireActions forgets the actions of the keys not seen for the action
+// expiry, checking at most once per expiry.
+func (c *logsConnector) expireActions(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiry := c.config.Logs.ActionExpiry
+	if now.Sub(c.expired) < expiry {
+		return
+	}
+	c.expired = now
+	for key, a := range c.actions {
+		if now.Sub(a.seen) >= expiry {
+			delete(c.actions, key)
+		}
+	}
+}
+
+func newDecisionRecord(lr plog.LogRecord, now pcommon.Timestamp, key usagestore.Key, o *observation, d *decision, previous string) {
+	lr.SetTimestamp(now)
+	lr.SetObservedTimestamp(now)
+	lr.SetSeverityNumber(plog.SeverityNumberInfo)
+	lr.SetSeverityText("INFO")
+	lr.SetEventName(eventDecisionChange)
+	verb := "kept"
+	if d.action == actionDrop {
+		verb = "dropped"
+	}
+	lr.Body().SetStr(fmt.Sprintf("metric %s of job %s is %s: %s", key.Metric, key.Job, verb, d.reason))
+
+	attrs := lr.Attributes()
+	attrs.PutStr(usagestore.AttributeJob, key.Job)
+	attrs.PutStr(usagestore.AttributeMetric, key.Metric)
+	attrs.PutStr(attributeMetricType, metricdata.TypeName(o.metricType))
+	attrs.PutStr(attributeAction, d.action)
+	if previous != "" {
+		attrs.PutStr(attributePreviousAction, previous)
+	}
+	attrs.PutStr(attributeReason, d.reason)
+	if s := d.usage.Summary; s != nil {
+		attrs.PutInt(attributeAlerts, int64(s.AlertCount))
+		attrs.PutInt(attributeRecordingRules, int64(s.RecordCount))
+		attrs.PutInt(attributeDashboards, int64(s.DashboardCount))
+		attrs.PutInt(attributeQueries, int64(s.QueryCount))
+	}
+	attrs.PutInt(attributeSeries, int64(len(o.series)))
+	attrs.PutInt(attributeBytes, o.bytes)
+}
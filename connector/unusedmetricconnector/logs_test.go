@@ -0,0 +1,146 @@
+package unusedmetricconnector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/plog"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector/internal/metadata"
+)
+
+func records(sink *consumertest.LogsSink) []plog.LogRecord {
+	var lrs []plog.LogRecord
+	for _, ld := range sink.AllLogs() {
+		for i := 0; i < ld.ResourceLogs().Len(); i++ {
+			rl := ld.ResourceLogs().At(i)
+			for j := 0; j < rl.ScopeLogs().Len(); j++ {
+				sl := rl.ScopeLogs().At(j)
+				for k := 0; k < sl.LogRecords().Len(); k++ {
+					lrs = append(lrs, sl.LogRecords().At(k))
+				}
+			}
+		}
+	}
+	return lrs
+}
+
+func TestLogsConnectorEmitsDecisionChanges(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{
+		"a": {"unused_metric": true, "used_metric": false},
+		"b": {"unused_metric": true},
+	}}
+	sink := &consumertest.LogsSink{}
+	c := newLogsConnector(newConfiguredTestConnector(t, f, func(cfg *Config) {
+		cfg.Logs.EmitFirstSeen = true
+	}), sink)
+	ctx := context.Background()
+	batch := func() error {
+		return c.ConsumeMetrics(ctx, newBatch([]string{"unused_metric", "used_metric"}, "a", "b"))
+	}
+
+	// the first decision of every key is reported, once
+	for range 2 {
+		require.NoError(t, batch())
+	}
+	require.Len(t, sink.AllLogs(), 1)
+	lrs := records(sink)
+	require.Len(t, lrs, 4)
+
+	ld := sink.AllLogs()[0]
+	require.Equal(t, 1, ld.ResourceLogs().Len())
+	resource, _ := ld.ResourceLogs().At(0).Resource().Attributes().Get("service.namespace")
+	require.Equal(t, "shop", resource.Str())
+	require.Equal(t, metadata.ScopeName, ld.ResourceLogs().At(0).ScopeLogs().At(0).Scope().Name())
+
+	lr := lrs[0]
+	require.Equal(t, eventDecisionChange, lr.EventName())
+	require.Equal(t, plog.SeverityNumberInfo, lr.SeverityNumber())
+	require.Equal(t, "metric unused_metric of job a is dropped: unused: not referenced by alerts, recording rules, dashboards or queries", lr.Body().Str())
+	require.Equal(t, map[string]any{
+		"unusedmetric.job":         "a",
+		"unusedmetric.metric":      "unused_metric",
+		"unusedmetric.metric_type": "gauge",
+		"unusedmetric.action":      "drop",
+		"unusedmetric.reason":      "unused: not referenced by alerts, recording rules, dashboards or queries",
+		"unusedmetric.series":      int64(1),
+		"unusedmetric.bytes":       int64(16 + 8 + len("job") + len("a")),
+	}, lr.Attributes().AsRaw())
+
+	// the used metric carries its usage summary
+	attrs := lrs[2].Attributes().AsRaw()
+	require.Equal(t, "used_metric", attrs["unusedmetric.metric"])
+	require.Equal(t, "keep", attrs["unusedmetric.action"])
+	require.Equal(t, int64(1), attrs["unusedmetric.alerts"])
+	require.Equal(t, int64(2), attrs["unusedmetric.dashboards"])
+	require.Equal(t, int64(0), attrs["unusedmetric.queries"])
+
+	// a change is reported with the previous action
+	f.set("a", "unused_metric", false)
+	_, err := c.store.Refresh(ctx)
+	require.NoError(t, err)
+	require.NoError(t, batch())
+	lrs = records(sink)
+	require.Len(t, lrs, 5)
+	attrs = lrs[4].Attributes().AsRaw()
+	require.Equal(t, "a", attrs["unusedmetric.job"])
+	require.Equal(t, "keep", attrs["unusedmetric.action"])
+	require.Equal(t, "drop", attrs["unusedmetric.previous_action"])
+	require.Equal(t, "used: 1 alerts, 0 recording rules, 2 dashboards, 0 queries", attrs["unusedmetric.reason"])
+}
+
+func TestLogsConnectorRestart(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{
+		"a": {"unused_metric": true, "used_metric": false},
+	}}
+	ctx := context.Background()
+	batch := newBatch([]string{"unused_metric", "used_metric"}, "a")
+
+	before := &consumertest.LogsSink{}
+	require.NoError(t, newLogsConnector(newTestConnector(t, f), before).ConsumeMetrics(ctx, batch))
+	require.Empty(t, before.AllLogs())
+
+	// after a restart, the metrics seen again are not reported, only the changes
+	after := &consumertest.LogsSink{}
+	c := newLogsConnector(newTestConnector(t, f), after)
+	require.NoError(t, c.ConsumeMetrics(ctx, batch))
+	require.Empty(t, after.AllLogs())
+
+	f.set("a", "unused_metric", false)
+	_, err := c.store.Refresh(ctx)
+	require.NoError(t, err)
+	require.NoError(t, c.ConsumeMetrics(ctx, batch))
+	lrs := records(after)
+	require.Len(t, lrs, 1)
+	attrs := lrs[0].Attributes().AsRaw()
+	require.Equal(t, "unused_metric", attrs["unusedmetric.metric"])
+	require.Equal(t, "drop", attrs["unusedmetric.previous_action"])
+}
+
+func TestLogsConnectorExpiresActions(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"a": {"unused_metric": true}}}
+	c := newLogsConnector(newConfiguredTestConnector(t, f, func(cfg *Config) {
+		cfg.Logs.ActionExpiry = time.Minute
+	}), &consumertest.LogsSink{})
+	require.NoError(t, c.ConsumeMetrics(context.Background(), newBatch([]string{"unused_metric"}, "a")))
+	require.Len(t, c.actions, 1)
+
+	c.expireActions(time.Now().Add(30 * time.Second))
+	require.Len(t, c.actions, 1)
+	c.expireActions(time.Now().Add(2 * time.Minute))
+	require.Empty(t, c.actions)
+}
+
+func TestLogsConnectorSkipsUnresolvedDecisions(t *testing.T) {
+	f := &fakeClient{
+		decisions: map[string]map[string]bool{"a": {}},
+		errFor:    map[string]error{"failing": context.DeadlineExceeded},
+	}
+	sink := &consumertest.LogsSink{}
+	c := newLogsConnector(newTestConnector(t, f), sink)
+	require.NoError(t, c.ConsumeMetrics(context.Background(), newBatch([]string{"failing"}, "a")))
+	require.Empty(t, sink.AllLogs())
+}
@@ -0,0 +1,90 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricconnector // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector"
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/multierr"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+)
+
+// metricsConnector splits every batch between the pipelines of the used
+// metrics and those of the unused metrics, instead of dropping the unused
+// ones. The data points of a metric are routed by their job.
+type metricsConnector struct {
+	*unusedMetricConnector
+	// nil when no pipeline receives them
+	used   consumer.Metrics
+	unused consumer.Metrics
+}
+
+func newMetricsConnector(c *unusedMetricConnector, used consumer.Metrics, unused consumer.Metrics) *metricsConnector {
+	return &metricsConnector{
+		unusedMetricConnector: c,
+		used:                  used,
+		unused:                unused,
+	}
+}
+
+func (c *metricsConnector) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: true}
+}
+
+func (c *metricsConnector) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) error {
+	points, err := c.decide(ctx, md)
+	if err != nil {
+		return err
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	unusedPoints := make([]bool, len(points))
+	unusedCount := 0
+	for i, d := range points {
+		action := c.config.FailureAction
+		if d != nil {
+			action = d.action
+		}
+		if action == actionDrop {
+			unusedPoints[i] = true
+			unusedCount++
+		}
+	}
+
+	switch unusedCount {
+	case 0:
+		return c.consume(ctx, c.used, md)
+	case len(points):
+		return c.consume(ctx, c.unused, md)
+	}
+	unused := pmetric.NewMetrics()
+	md.CopyTo(unused)
+	metricdata.RemoveDatapointsIf(unused, pointsIf(unusedPoints, false))
+	metricdata.RemoveDatapointsIf(md, pointsIf(unusedPoints, true))
+	return multierr.Append(c.consume(ctx, c.used, md), c.consume(ctx, c.unused, unused))
+}
+
+// pointsIf returns a function matching the data points, walked in the order
+// of the flags, whose flag is set to flag.
+func pointsIf(flags []bool, flag bool) func(pmetric.Metric, pcommon.Map) bool {
+	i := 0
+	return func(pmetric.Metric, pcommon.Map) bool {
+		matched := flags[i] == flag
+		i++
+		return matched
+	}
+}
+
+// consume sends the metrics to the pipelines of next, if any.
+func (c *metricsConnector) consume(ctx context.Context, next consumer.Metrics, md pmetric.Metrics) error {
+	if next == nil {
+		return nil
+	}
+	return next.ConsumeMetrics(ctx, md)
+}
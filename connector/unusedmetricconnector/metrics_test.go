@@ -0,0 +1,102 @@
+package unusedmetricconnector
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/connector"
+	"go.opentelemetry.io/collector/connector/connectortest"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pipeline"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/connector/unusedmetricconnector/internal/metadata"
+)
+
+func metricNames(md pmetric.Metrics) map[string]int {
+	names := map[string]int{}
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		sms := md.ResourceMetrics().At(i).ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			ms := sms.At(j).Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				names[ms.At(k).Name()] = ms.At(k).Gauge().DataPoints().Len()
+			}
+		}
+	}
+	return names
+}
+
+func TestMetricsConnectorSplitsBatches(t *testing.T) {
+	f := &fakeClient{
+		decisions: map[string]map[string]bool{
+			"a": {"unused_metric": true, "partly_unused": true},
+			"b": {},
+		},
+		errFor: map[string]error{"failing": errors.New("boom")},
+	}
+	c := newTestConnector(t, f)
+	used, unused := &consumertest.MetricsSink{}, &consumertest.MetricsSink{}
+	mc := newMetricsConnector(c, used, unused)
+
+	md := newBatch([]string{"used_metric", "unused_metric", "failing"}, "a")
+	partly := newBatch([]string{"partly_unused"}, "a", "b")
+	partly.ResourceMetrics().MoveAndAppendTo(md.ResourceMetrics())
+	require.NoError(t, mc.ConsumeMetrics(context.Background(), md))
+
+	require.Len(t, used.AllMetrics(), 1)
+	require.Len(t, unused.AllMetrics(), 1)
+	// failed lookups are routed with the used metrics by default
+	require.Equal(t, map[string]int{"used_metric": 1, "failing": 1, "partly_unused": 1}, metricNames(used.AllMetrics()[0]))
+	require.Equal(t, map[string]int{"unused_metric": 1, "partly_unused": 1}, metricNames(unused.AllMetrics()[0]))
+	// the resource is kept on both sides
+	resource, _ := unused.AllMetrics()[0].ResourceMetrics().At(0).Resource().Attributes().Get("service.namespace")
+	require.Equal(t, "shop", resource.Str())
+
+	// batches that are entirely used or unused are not copied
+	require.NoError(t, mc.ConsumeMetrics(context.Background(), newBatch([]string{"unused_metric"}, "a")))
+	require.Len(t, used.AllMetrics(), 1)
+	require.Len(t, unused.AllMetrics(), 2)
+
+	c.config.FailureAction = actionDrop
+	require.NoError(t, mc.ConsumeMetrics(context.Background(), newBatch([]string{"failing"}, "a")))
+	require.Len(t, unused.AllMetrics(), 3)
+
+	// without unused pipelines, the unused metrics are dropped
+	mc = newMetricsConnector(c, used, nil)
+	require.NoError(t, mc.ConsumeMetrics(context.Background(), newBatch([]string{"unused_metric"}, "a")))
+	require.Len(t, used.AllMetrics(), 1)
+	require.Len(t, unused.AllMetrics(), 3)
+}
+
+func TestCreateMetricsToMetrics(t *testing.T) {
+	usedID := pipeline.NewIDWithName(pipeline.SignalMetrics, "used")
+	unusedID := pipeline.NewIDWithName(pipeline.SignalMetrics, "unused")
+	router := connector.NewMetricsRouter(map[pipeline.ID]consumer.Metrics{
+		usedID:   consumertest.NewNop(),
+		unusedID: consumertest.NewNop(),
+	})
+	factory := NewFactory()
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	set := connectortest.NewNopSettings(metadata.Type)
+
+	_, err := factory.CreateMetricsToMetrics(context.Background(), set, cfg, router)
+	require.ErrorContains(t, err, "used_pipelines or unused_pipelines are required")
+
+	cfg.UsedPipelines = []pipeline.ID{usedID}
+	cfg.UnusedPipelines = []pipeline.ID{pipeline.NewIDWithName(pipeline.SignalMetrics, "missing")}
+	_, err = factory.CreateMetricsToMetrics(context.Background(), set, cfg, router)
+	require.ErrorContains(t, err, "unused_pipelines")
+
+	cfg.UnusedPipelines = []pipeline.ID{unusedID}
+	mc, err := factory.CreateMetricsToMetrics(context.Background(), set, cfg, router)
+	require.NoError(t, err)
+	require.True(t, mc.Capabilities().MutatesData)
+
+	cfg.FailureAction = "route"
+	require.ErrorContains(t, cfg.Validate(), `failure_action must be "keep" or "drop"`)
+}
@@ -0,0 +1,34 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricusageextension // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension"
+
+import (
+	"errors"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+type Config struct {
+	// prevents unkeyed literal initialization
+	_ struct{}
+
+	// connection to the server and decisions shared by the processors
+	usagestore.Config `mapstructure:",squash"`
+
+	// requests to the server running in parallel, across every processor
+	// default is 8
+	MaxConcurrentLookups int `mapstructure:"max_concurrent_lookups"`
+}
+
+func (c *Config) Validate() error {
+	if c.Server.Address == "" {
+		return errors.New("server address is required")
+	}
+	c.Config.MaxConcurrentLookups = c.MaxConcurrentLookups
+	if err := c.Config.Validate(); err != nil {
+		return err
+	}
+	c.MaxConcurrentLookups = c.Config.MaxConcurrentLookups
+	return nil
+}
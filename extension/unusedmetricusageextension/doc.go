@@ -0,0 +1,8 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+//go:generate mdatagen metadata.yaml
+
+// Package unusedmetricusageextension shares the connection to the analytics
+// server and the decisions it returns between unusedmetric processors.
+package unusedmetricusageextension // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension"
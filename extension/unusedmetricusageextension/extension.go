@@ -0,0 +1,73 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricusageextension // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension"
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// usageExtension owns the connection to the server and the decisions, so
+// every processor referencing it shares one cache, one decision index and
+// one set of connections.
+type usageExtension struct {
+	store *usagestore.Store
+}
+
+var _ usagestore.StoreProvider = (*usageExtension)(nil)
+
+func newUsageExtension(settings extension.Settings, cfg *Config, client usage.Client) (*usageExtension, error) {
+	telemetry, err := metadata.NewTelemetryBuilder(settings.TelemetrySettings)
+	if err != nil {
+		return nil, err
+	}
+	logger := settings.Logger.With(zap.String("component", "unusedmetricusageextension"))
+	store := usagestore.NewStore(usagestore.Settings{
+		ID:             settings.ID,
+		Kind:           component.KindExtension,
+		Logger:         logger,
+		Telemetry:      storeTelemetry{telemetry},
+		TracerProvider: settings.TracerProvider,
+	}, &cfg.Config, client)
+	return &usageExtension{store: store}, nil
+}
+
+func (e *usageExtension) Start(ctx context.Context, host component.Host) error {
+	return e.store.Start(ctx, host)
+}
+
+func (e *usageExtension) Shutdown(ctx context.Context) error {
+	return e.store.Shutdown(ctx)
+}
+
+// Store returns the decisions shared by the processors.
+func (e *usageExtension) Store() *usagestore.Store {
+	return e.store
+}
+
+type storeTelemetry struct {
+	telemetry *metadata.TelemetryBuilder
+}
+
+func (t storeTelemetry) RecordBackendCall(ctx context.Context, operation string, duration time.Duration, err error) {
+	attrs := []attribute.KeyValue{attribute.String("operation", operation)}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error_class", usagestore.ErrorClass(err)))
+	}
+	t.telemetry.ExtensionUnusedmetricusageBackendDuration.Record(ctx, usagestore.Milliseconds(duration), metric.WithAttributes(attrs...))
+}
+
+func (t storeTelemetry) RecordIndexSize(ctx context.Context, size int64) {
+	t.telemetry.ExtensionUnusedmetricusageIndexSize.Record(ctx, size)
+}
@@ -0,0 +1,77 @@
+package unusedmetricusageextension
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/extension/extensiontest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+type fakeClient struct {
+	// map[job][metricName] => unused
+	decisions map[string]map[string]bool
+	// number of GetMetricUsage calls
+	calls atomic.Int64
+}
+
+func (f *fakeClient) GetMetricUsage(_ context.Context, job string, name string) (usage.MetricUsage, error) {
+	f.calls.Add(1)
+	return usage.MetricUsage{Name: name, Unused: f.decisions[job][name]}, nil
+}
+
+func (f *fakeClient) ListMetricUsage(context.Context, string) ([]usage.MetricUsage, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) StreamMetricUsage(context.Context, string, string, func(usage.MetricUsage) error) (usage.StreamResult, error) {
+	return usage.StreamResult{}, nil
+}
+
+func (f *fakeClient) WatchMetricUsage(ctx context.Context, _ string, _ func(usage.WatchEvent) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeClient) Ping(context.Context) error {
+	return nil
+}
+
+func (f *fakeClient) CloseIdleConnections() {}
+
+func TestExtensionSharesStore(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	ext, err := newUsageExtension(extensiontest.NewNopSettings(metadata.Type), cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, ext.Start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, ext.Shutdown(context.Background())) })
+
+	var provider usagestore.StoreProvider = ext
+	for range 2 {
+		entry, err := provider.Store().Lookup(context.Background(), usagestore.Key{Job: "myJob", Metric: "unused_metric"})
+		require.NoError(t, err)
+		require.True(t, entry.Usage.Unused)
+	}
+	require.Equal(t, int64(1), f.calls.Load())
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	require.ErrorContains(t, cfg.Validate(), "server address is required")
+
+	cfg.Server.Address = "http://localhost:0"
+	cfg.MaxConcurrentLookups = 2
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, 2, cfg.Config.MaxConcurrentLookups)
+	require.NotNil(t, cfg.Server.Timeout)
+}
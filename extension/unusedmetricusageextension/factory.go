@@ -0,0 +1,42 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricusageextension // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension"
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// NewFactory returns a new factory for the unusedmetricusage extension.
+func NewFactory() extension.Factory {
+	return extension.NewFactory(
+		metadata.Type,
+		createDefaultConfig,
+		createExtension,
+		metadata.ExtensionStability)
+}
+
+func createDefaultConfig() component.Config {
+	return &Config{}
+}
+
+func createExtension(
+	_ context.Context,
+	params extension.Settings,
+	baseCfg component.Config,
+) (extension.Extension, error) {
+	cfg := baseCfg.(*Config)
+
+	// Ensure defaults are applied to prevent nil dereference in client setup
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return newUsageExtension(params, cfg, usage.NewClient(cfg.ClientConfig(), usage.WithTracerProvider(params.TracerProvider)))
+}
@@ -0,0 +1,16 @@
+// Code generated by mdatagen. DO NOT EDIT.
+
+package metadata
+
+import (
+	"go.opentelemetry.io/collector/component"
+)
+
+var (
+	Type      = component.MustNewType("unusedmetricusage")
+	ScopeName = "github.com/nicolastakashi/ntakashi-opentelemetry-collector/extension/unusedmetricusageextension"
+)
+
+const (
+	ExtensionStability = component.StabilityLevelAlpha
+)
@@ -0,0 +1,136 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metricdata holds the helpers shared by the components walking the
+// data points of metrics to apply usage decisions.
+package metricdata // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+
+import (
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// ForEachDatapoint calls fn with every data point of the metric and its attributes.
+func ForEachDatapoint(m pmetric.Metric, fn func(dp any, attrs pcommon.Map)) {
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
+			dp := m.Gauge().DataPoints().At(i)
+			fn(dp, dp.Attributes())
+		}
+	case pmetric.MetricTypeSum:
+		for i := 0; i < m.Sum().DataPoints().Len(); i++ {
+			dp := m.Sum().DataPoints().At(i)
+			fn(dp, dp.Attributes())
+		}
+	case pmetric.MetricTypeHistogram:
+		for i := 0; i < m.Histogram().DataPoints().Len(); i++ {
+			dp := m.Histogram().DataPoints().At(i)
+			fn(dp, dp.Attributes())
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		for i := 0; i < m.ExponentialHistogram().DataPoints().Len(); i++ {
+			dp := m.ExponentialHistogram().DataPoints().At(i)
+			fn(dp, dp.Attributes())
+		}
+	case pmetric.MetricTypeSummary:
+		for i := 0; i < m.Summary().DataPoints().Len(); i++ {
+			dp := m.Summary().DataPoints().At(i)
+			fn(dp, dp.Attributes())
+		}
+	}
+}
+
+// RemoveDatapointsIf removes the data points for which remove returns true,
+// along with the metrics, scopes and resources left empty.
+func RemoveDatapointsIf(md pmetric.Metrics, remove func(m pmetric.Metric, attrs pcommon.Map) bool) {
+	md.ResourceMetrics().RemoveIf(func(rm pmetric.ResourceMetrics) bool {
+		rm.ScopeMetrics().RemoveIf(func(sm pmetric.ScopeMetrics) bool {
+			sm.Metrics().RemoveIf(func(m pmetric.Metric) bool {
+				switch m.Type() {
+				case pmetric.MetricTypeGauge:
+					m.Gauge().DataPoints().RemoveIf(func(dp pmetric.NumberDataPoint) bool {
+						return remove(m, dp.Attributes())
+					})
+					return m.Gauge().DataPoints().Len() == 0
+				case pmetric.MetricTypeSum:
+					m.Sum().DataPoints().RemoveIf(func(dp pmetric.NumberDataPoint) bool {
+						return remove(m, dp.Attributes())
+					})
+					return m.Sum().DataPoints().Len() == 0
+				case pmetric.MetricTypeHistogram:
+					m.Histogram().DataPoints().RemoveIf(func(dp pmetric.HistogramDataPoint) bool {
+						return remove(m, dp.Attributes())
+					})
+					return m.Histogram().DataPoints().Len() == 0
+				case pmetric.MetricTypeExponentialHistogram:
+					m.ExponentialHistogram().DataPoints().RemoveIf(func(dp pmetric.ExponentialHistogramDataPoint) bool {
+						return remove(m, dp.Attributes())
+					})
+					return m.ExponentialHistogram().DataPoints().Len() == 0
+				case pmetric.MetricTypeSummary:
+					m.Summary().DataPoints().RemoveIf(func(dp pmetric.SummaryDataPoint) bool {
+						return remove(m, dp.Attributes())
+					})
+					return m.Summary().DataPoints().Len() == 0
+				}
+				return false
+			})
+			return sm.Metrics().Len() == 0
+		})
+		return rm.ScopeMetrics().Len() == 0
+	})
+}
+
+// Job returns the job of a data point, taken from the scrape_job,
+// service.name or job attribute, in that order of precedence.
+func Job(attrs pcommon.Map) string {
+	for _, name := range []string{"scrape_job", "service.name", "job"} {
+		if j, ok := attrs.Get(name); ok {
+			return j.AsString()
+		}
+	}
+	return ""
+}
+
+// TypeName returns the name of a metric type as reported in telemetry.
+func TypeName(t pmetric.MetricType) string {
+	switch t {
+	case pmetric.MetricTypeGauge:
+		return "gauge"
+	case pmetric.MetricTypeSum:
+		return "sum"
+	case pmetric.MetricTypeHistogram:
+		return "histogram"
+	case pmetric.MetricTypeExponentialHistogram:
+		return "exponential_histogram"
+	case pmetric.MetricTypeSummary:
+		return "summary"
+	}
+	return ""
+}
+
+// EstimatedSize estimates the encoded size of a data point: its attributes,
+// timestamps and values, ignoring the framing of the encoding.
+func EstimatedSize(dp any, attrs pcommon.Map) int64 {
+	// start and current timestamps
+	size := int64(16)
+	attrs.Range(func(k string, v pcommon.Value) bool {
+		size += int64(len(k) + len(v.AsString()))
+		return true
+	})
+	switch dp := dp.(type) {
+	case pmetric.NumberDataPoint:
+		size += 8
+	case pmetric.HistogramDataPoint:
+		// count, sum, min and max
+		size += 32 + 8*int64(dp.BucketCounts().Len()+dp.ExplicitBounds().Len())
+	case pmetric.ExponentialHistogramDataPoint:
+		// count, sum, min, max and zero count
+		size += 40 + 8*int64(dp.Positive().BucketCounts().Len()+dp.Negative().BucketCounts().Len())
+	case pmetric.SummaryDataPoint:
+		// count, sum, and quantile and value of every quantile
+		size += 16 + 16*int64(dp.QuantileValues().Len())
+	}
+	return size
+}
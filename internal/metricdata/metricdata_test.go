@@ -0,0 +1,62 @@
+package metricdata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestJob(t *testing.T) {
+	attrs := pcommon.NewMap()
+	require.Empty(t, Job(attrs))
+	attrs.PutStr("job", "job")
+	require.Equal(t, "job", Job(attrs))
+	attrs.PutStr("service.name", "service")
+	require.Equal(t, "service", Job(attrs))
+	attrs.PutStr("scrape_job", "scrape")
+	require.Equal(t, "scrape", Job(attrs))
+}
+
+func TestForEachDatapoint(t *testing.T) {
+	m := pmetric.NewMetric()
+	dps := m.SetEmptyHistogram().DataPoints()
+	dps.AppendEmpty().Attributes().PutStr("job", "a")
+	dps.AppendEmpty().Attributes().PutStr("job", "b")
+
+	var jobs []string
+	var size int64
+	ForEachDatapoint(m, func(dp any, attrs pcommon.Map) {
+		jobs = append(jobs, Job(attrs))
+		size += EstimatedSize(dp, attrs)
+	})
+	require.Equal(t, []string{"a", "b"}, jobs)
+	// timestamps, count, sum, min, max and the job attribute
+	require.Equal(t, int64(2*(16+32+4)), size)
+	require.Equal(t, "histogram", TypeName(m.Type()))
+}
+
+func TestRemoveDatapointsIf(t *testing.T) {
+	md := pmetric.NewMetrics()
+	metrics := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	for _, name := range []string{"a", "b"} {
+		m := metrics.AppendEmpty()
+		m.SetName(name)
+		dps := m.SetEmptySum().DataPoints()
+		dps.AppendEmpty().Attributes().PutStr("job", "x")
+		dps.AppendEmpty().Attributes().PutStr("job", "y")
+	}
+	// an empty resource is removed as well
+	md.ResourceMetrics().AppendEmpty()
+
+	RemoveDatapointsIf(md, func(m pmetric.Metric, attrs pcommon.Map) bool {
+		return m.Name() == "a" || Job(attrs) == "x"
+	})
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	require.Equal(t, 1, md.MetricCount())
+	require.Equal(t, 1, md.DataPointCount())
+	m := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+	require.Equal(t, "b", m.Name())
+	require.Equal(t, "y", Job(m.Sum().DataPoints().At(0).Attributes()))
+}
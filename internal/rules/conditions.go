@@ -0,0 +1,172 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package rules // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottldatapoint"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlmetric"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/ottlfuncs"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Result is the outcome of evaluating the keep and drop conditions.
+type Result int
+
+const (
+	// no condition matched, the server decides
+	ResultNone Result = iota
+	// a keep condition matched
+	ResultKeep
+	// a drop condition matched and no keep condition did
+	ResultDrop
+)
+
+type conditions struct {
+	keepMetric    *ottl.ConditionSequence[ottlmetric.TransformContext]
+	dropMetric    *ottl.ConditionSequence[ottlmetric.TransformContext]
+	keepDatapoint *ottl.ConditionSequence[ottldatapoint.TransformContext]
+	dropDatapoint *ottl.ConditionSequence[ottldatapoint.TransformContext]
+}
+
+// newConditions parses the keep and drop conditions.
+func newConditions(cfg Config, set component.TelemetrySettings) (*conditions, error) {
+	metricParser, err := ottlmetric.NewParser(ottlfuncs.StandardConverters[ottlmetric.TransformContext](), set)
+	if err != nil {
+		return nil, err
+	}
+	datapointParser, err := ottldatapoint.NewParser(ottlfuncs.StandardConverters[ottldatapoint.TransformContext](), set)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conditions{}
+	if c.keepMetric, err = parseConditionSequence(metricParser, cfg.KeepConditions.Metric, cfg.ErrorMode, set); err != nil {
+		return nil, fmt.Errorf("keep_conditions::metric: %w", err)
+	}
+	if c.dropMetric, err = parseConditionSequence(metricParser, cfg.DropConditions.Metric, cfg.ErrorMode, set); err != nil {
+		return nil, fmt.Errorf("drop_conditions::metric: %w", err)
+	}
+	if c.keepDatapoint, err = parseConditionSequence(datapointParser, cfg.KeepConditions.Datapoint, cfg.ErrorMode, set); err != nil {
+		return nil, fmt.Errorf("keep_conditions::datapoint: %w", err)
+	}
+	if c.dropDatapoint, err = parseConditionSequence(datapointParser, cfg.DropConditions.Datapoint, cfg.ErrorMode, set); err != nil {
+		return nil, fmt.Errorf("drop_conditions::datapoint: %w", err)
+	}
+	return c, nil
+}
+
+func parseConditionSequence[K any](
+	parser ottl.Parser[K],
+	statements []string,
+	errorMode ottl.ErrorMode,
+	set component.TelemetrySettings,
+) (*ottl.ConditionSequence[K], error) {
+	if len(statements) == 0 {
+		return nil, nil
+	}
+	parsed, err := parser.ParseConditions(statements)
+	if err != nil {
+		return nil, err
+	}
+	seq := ottl.NewConditionSequence(parsed, set, ottl.WithConditionSequenceErrorMode[K](errorMode))
+	return &seq, nil
+}
+
+// EvalName evaluates the conditions on a gauge of the metric with a single
+// data point whose only attribute is the job, which is all the admin API
+// knows of a metric it explains.
+func (r *Rules) EvalName(ctx context.Context, job string, metricName string) (Result, error) {
+	rm := pmetric.NewResourceMetrics()
+	sm := rm.ScopeMetrics().AppendEmpty()
+	m := sm.Metrics().AppendEmpty()
+	m.SetName(metricName)
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.Attributes().PutStr("job", job)
+
+	result, err := r.EvalMetric(ctx, rm, sm, m)
+	if err != nil {
+		return ResultNone, err
+	}
+	return r.EvalDatapoint(ctx, rm, sm, m, result, dp)
+}
+
+// EvalMetric evaluates the metric level conditions.
+func (r *Rules) EvalMetric(ctx context.Context, rm pmetric.ResourceMetrics, sm pmetric.ScopeMetrics, m pmetric.Metric) (Result, error) {
+	c := r.conditions
+	if c.keepMetric == nil && c.dropMetric == nil {
+		return ResultNone, nil
+	}
+	tCtx := ottlmetric.NewTransformContext(m, sm.Metrics(), sm.Scope(), rm.Resource(), sm, rm)
+	return evalSequences(ctx, c.keepMetric, c.dropMetric, tCtx)
+}
+
+// EvalDatapoint combines the result of the metric level conditions with the
+// data point conditions. Keep conditions always take precedence over drop
+// conditions.
+func (r *Rules) EvalDatapoint(
+	ctx context.Context,
+	rm pmetric.ResourceMetrics,
+	sm pmetric.ScopeMetrics,
+	m pmetric.Metric,
+	metricResult Result,
+	dp any,
+) (Result, error) {
+	c := r.conditions
+	if metricResult == ResultKeep || (c.keepDatapoint == nil && c.dropDatapoint == nil) {
+		return metricResult, nil
+	}
+	tCtx := ottldatapoint.NewTransformContext(dp, m, sm.Metrics(), sm.Scope(), rm.Resource(), sm, rm)
+	if c.keepDatapoint != nil {
+		keep, err := c.keepDatapoint.Eval(ctx, tCtx)
+		if err != nil {
+			return ResultNone, err
+		}
+		if keep {
+			return ResultKeep, nil
+		}
+	}
+	if metricResult == ResultDrop || c.dropDatapoint == nil {
+		return metricResult, nil
+	}
+	drop, err := c.dropDatapoint.Eval(ctx, tCtx)
+	if err != nil {
+		return ResultNone, err
+	}
+	if drop {
+		return ResultDrop, nil
+	}
+	return ResultNone, nil
+}
+
+func evalSequences[K any](
+	ctx context.Context,
+	keep *ottl.ConditionSequence[K],
+	drop *ottl.ConditionSequence[K],
+	tCtx K,
+) (Result, error) {
+	if keep != nil {
+		matched, err := keep.Eval(ctx, tCtx)
+		if err != nil {
+			return ResultNone, err
+		}
+		if matched {
+			return ResultKeep, nil
+		}
+	}
+	if drop != nil {
+		matched, err := drop.Eval(ctx, tCtx)
+		if err != nil {
+			return ResultNone, err
+		}
+		if matched {
+			return ResultDrop, nil
+		}
+	}
+	return ResultNone, nil
+}
@@ -0,0 +1,89 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package rules // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/component"
+	"go.uber.org/zap"
+)
+
+var defaultExemptionsReloadInterval = 30 * time.Second
+
+// Config gathers the rules of a component, which configures them at its top
+// level next to the store.
+type Config struct {
+	Exemptions     ExemptionsConfig
+	KeepConditions ConditionsConfig
+	DropConditions ConditionsConfig
+	ErrorMode      ottl.ErrorMode
+}
+
+// Validate defaults the reload interval of the exemptions file and the error
+// mode, and checks that the exemptions compile and the conditions parse.
+func (c *Config) Validate() error {
+	if c.Exemptions.ReloadInterval <= 0 {
+		c.Exemptions.ReloadInterval = defaultExemptionsReloadInterval
+	}
+	for i, rule := range c.Exemptions.Rules {
+		if _, err := compileExemption(rule); err != nil {
+			return fmt.Errorf("exemptions::rules[%d]: %w", i, err)
+		}
+	}
+	if c.ErrorMode == "" {
+		c.ErrorMode = ottl.IgnoreError
+	}
+	_, err := newConditions(*c, component.TelemetrySettings{Logger: zap.NewNop()})
+	return err
+}
+
+type ConditionsConfig struct {
+	// conditions evaluated in the OTTL metric context
+	Metric []string `mapstructure:"metric"`
+
+	// conditions evaluated in the OTTL datapoint context
+	Datapoint []string `mapstructure:"datapoint"`
+}
+
+type ExemptionsConfig struct {
+	// exemptions declared inline in the collector configuration
+	Rules []ExemptionRule `mapstructure:"rules"`
+
+	// path to a YAML file with a top-level `rules` list, reloaded when it changes
+	File string `mapstructure:"file"`
+
+	// how often the exemptions file is checked for changes
+	// default is 30 seconds
+	ReloadInterval time.Duration `mapstructure:"reload_interval"`
+}
+
+type ExemptionRule struct {
+	// exact metric name to match
+	MetricName string `mapstructure:"metric_name"`
+
+	// regular expression the whole metric name has to match
+	MetricNameRegex string `mapstructure:"metric_name_regex"`
+
+	// exact job to match, any job if empty
+	Job string `mapstructure:"job"`
+
+	// regular expression the whole job has to match
+	JobRegex string `mapstructure:"job_regex"`
+
+	// resource attributes that must all be present with the given values
+	ResourceAttributes map[string]string `mapstructure:"resource_attributes"`
+
+	// team or person responsible for the exemption
+	Owner string `mapstructure:"owner"`
+
+	// why the metric has to be kept
+	Reason string `mapstructure:"reason"`
+
+	// RFC 3339 timestamp at which the exemption no longer applies, or date
+	// (2006-01-02) until the end of which, in UTC, it still applies
+	ExpiresAt string `mapstructure:"expires_at"`
+}
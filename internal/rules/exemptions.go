@@ -0,0 +1,299 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package rules // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/collector/confmap"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+)
+
+// exemption is a compiled ExemptionRule.
+type exemption struct {
+	rule        ExemptionRule
+	metricRegex *regexp.Regexp
+	jobRegex    *regexp.Regexp
+	expiresAt   time.Time
+	// identifies the rule across reloads of the exemptions file
+	id string
+}
+
+func compileExemption(rule ExemptionRule) (*exemption, error) {
+	if rule.MetricName == "" && rule.MetricNameRegex == "" {
+		return nil, errors.New("one of metric_name or metric_name_regex is required")
+	}
+	if rule.MetricName != "" && rule.MetricNameRegex != "" {
+		return nil, errors.New("metric_name and metric_name_regex are mutually exclusive")
+	}
+	if rule.Job != "" && rule.JobRegex != "" {
+		return nil, errors.New("job and job_regex are mutually exclusive")
+	}
+	if rule.Owner == "" {
+		return nil, errors.New("owner is required")
+	}
+	if rule.Reason == "" {
+		return nil, errors.New("reason is required")
+	}
+	if rule.ExpiresAt == "" {
+		return nil, errors.New("expires_at is required")
+	}
+
+	// the maps are printed sorted by key
+	e := &exemption{rule: rule, id: fmt.Sprintf("%+v", rule)}
+	var err error
+	if e.expiresAt, err = parseExpiry(rule.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if rule.MetricNameRegex != "" {
+		if e.metricRegex, err = compileAnchored(rule.MetricNameRegex); err != nil {
+			return nil, fmt.Errorf("invalid metric_name_regex: %w", err)
+		}
+	}
+	if rule.JobRegex != "" {
+		if e.jobRegex, err = compileAnchored(rule.JobRegex); err != nil {
+			return nil, fmt.Errorf("invalid job_regex: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// compileAnchored compiles a regular expression that has to match the whole
+// value, as Prometheus matches label values.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// parseExpiry returns the time at which an exemption stops applying. A date
+// is inclusive: the exemption applies until the end of that day in UTC.
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expires_at %q: expected a date (2006-01-02) or an RFC 3339 timestamp", value)
+	}
+	return t, nil
+}
+
+func (e *exemption) matches(job string, metricName string, resource pcommon.Map) bool {
+	if !e.matchesMetric(job, metricName) {
+		return false
+	}
+	for k, v := range e.rule.ResourceAttributes {
+		attr, ok := resource.Get(k)
+		if !ok || attr.AsString() != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *exemption) matchesMetric(job string, metricName string) bool {
+	if e.metricRegex != nil {
+		if !e.metricRegex.MatchString(metricName) {
+			return false
+		}
+	} else if e.rule.MetricName != metricName {
+		return false
+	}
+
+	if e.jobRegex != nil {
+		if !e.jobRegex.MatchString(job) {
+			return false
+		}
+	} else if e.rule.Job != "" && e.rule.Job != job {
+		return false
+	}
+	return true
+}
+
+// exemptionFile is the layout of the file referenced by exemptions::file.
+type exemptionFile struct {
+	Rules []ExemptionRule `mapstructure:"rules"`
+}
+
+// exemptions holds the active exemption rules, combining the inline rules
+// with the ones loaded from the exemptions file.
+type exemptions struct {
+	logger   *zap.Logger
+	inline   []*exemption
+	file     string
+	interval time.Duration
+
+	rules atomic.Pointer[[]*exemption]
+	// ids of the expired rules whose warning has been logged, kept across
+	// reloads so a rule is only reported once
+	warned sync.Map
+
+	// modification time and size of the last loaded file
+	modTime time.Time
+	size    int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newExemptions(cfg ExemptionsConfig, logger *zap.Logger) (*exemptions, error) {
+	ex := &exemptions{
+		logger:   logger,
+		file:     cfg.File,
+		interval: cfg.ReloadInterval,
+		done:     make(chan struct{}),
+	}
+	for i, rule := range cfg.Rules {
+		e, err := compileExemption(rule)
+		if err != nil {
+			return nil, fmt.Errorf("exemptions::rules[%d]: %w", i, err)
+		}
+		ex.inline = append(ex.inline, e)
+	}
+
+	rules := ex.inline
+	if ex.file != "" {
+		fileRules, err := ex.loadFile()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(append([]*exemption{}, ex.inline...), fileRules...)
+	}
+	ex.rules.Store(&rules)
+	return ex, nil
+}
+
+func (ex *exemptions) loadFile() ([]*exemption, error) {
+	info, err := os.Stat(ex.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exemptions file: %w", err)
+	}
+	content, err := os.ReadFile(ex.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exemptions file: %w", err)
+	}
+	retrieved, err := confmap.NewRetrievedFromYAML(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exemptions file: %w", err)
+	}
+	conf, err := retrieved.AsConf()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exemptions file: %w", err)
+	}
+	var f exemptionFile
+	if err := conf.Unmarshal(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse exemptions file: %w", err)
+	}
+
+	rules := make([]*exemption, 0, len(f.Rules))
+	for i, rule := range f.Rules {
+		e, err := compileExemption(rule)
+		if err != nil {
+			return nil, fmt.Errorf("exemptions file rules[%d]: %w", i, err)
+		}
+		rules = append(rules, e)
+	}
+	ex.modTime = info.ModTime()
+	ex.size = info.Size()
+	return rules, nil
+}
+
+// start watches the exemptions file for changes until shutdown is called.
+func (ex *exemptions) start() {
+	if ex.file == "" {
+		return
+	}
+	ex.wg.Add(1)
+	go func() {
+		defer ex.wg.Done()
+		ticker := time.NewTicker(ex.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ex.done:
+				return
+			case <-ticker.C:
+				ex.reload()
+			}
+		}
+	}()
+}
+
+func (ex *exemptions) reload() {
+	info, err := os.Stat(ex.file)
+	if err != nil {
+		ex.logger.Warn("failed to stat exemptions file, keeping previous exemptions",
+			zap.String("file", ex.file),
+			zap.Error(err),
+		)
+		return
+	}
+	if info.ModTime().Equal(ex.modTime) && info.Size() == ex.size {
+		return
+	}
+
+	fileRules, err := ex.loadFile()
+	if err != nil {
+		ex.logger.Error("failed to reload exemptions file, keeping previous exemptions",
+			zap.String("file", ex.file),
+			zap.Error(err),
+		)
+		return
+	}
+	rules := append(append([]*exemption{}, ex.inline...), fileRules...)
+	ex.rules.Store(&rules)
+	ex.logger.Info("reloaded exemptions file",
+		zap.String("file", ex.file),
+		zap.Int("rules", len(fileRules)),
+	)
+}
+
+func (ex *exemptions) shutdown() {
+	close(ex.done)
+	ex.wg.Wait()
+}
+
+// match returns the first active exemption matching the metric, if any.
+// Expired exemptions are ignored and a warning is logged once for each.
+func (ex *exemptions) match(now time.Time, job string, metricName string, resource pcommon.Map) *exemption {
+	for _, e := range *ex.rules.Load() {
+		if !now.Before(e.expiresAt) {
+			if _, warned := ex.warned.LoadOrStore(e.id, struct{}{}); !warned {
+				ex.logger.Warn("exemption has expired, normal decisions resume for matching metrics",
+					zap.String("metric_name", e.rule.MetricName),
+					zap.String("metric_name_regex", e.rule.MetricNameRegex),
+					zap.String("owner", e.rule.Owner),
+					zap.String("reason", e.rule.Reason),
+					zap.Time("expires_at", e.expiresAt),
+				)
+			}
+			continue
+		}
+		if e.matches(job, metricName, resource) {
+			return e
+		}
+	}
+	return nil
+}
+
+// candidates returns the active exemptions matching the job and metric name,
+// regardless of their resource attribute selectors.
+func (ex *exemptions) candidates(now time.Time, job string, metricName string) []ExemptionRule {
+	var rules []ExemptionRule
+	for _, e := range *ex.rules.Load() {
+		if now.Before(e.expiresAt) && e.matchesMetric(job, metricName) {
+			rules = append(rules, e.rule)
+		}
+	}
+	return rules
+}
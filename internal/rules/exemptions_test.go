@@ -0,0 +1,191 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCompileExemption(t *testing.T) {
+	valid := ExemptionRule{
+		MetricName: "http_requests_total",
+		Owner:      "team-a",
+		Reason:     "migration",
+		ExpiresAt:  "2030-01-01",
+	}
+
+	testCases := []struct {
+		name    string
+		mutate  func(r *ExemptionRule)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*ExemptionRule) {},
+		},
+		{
+			name:    "missing metric",
+			mutate:  func(r *ExemptionRule) { r.MetricName = "" },
+			wantErr: "one of metric_name or metric_name_regex is required",
+		},
+		{
+			name:    "missing owner",
+			mutate:  func(r *ExemptionRule) { r.Owner = "" },
+			wantErr: "owner is required",
+		},
+		{
+			name:    "missing expiry",
+			mutate:  func(r *ExemptionRule) { r.ExpiresAt = "" },
+			wantErr: "expires_at is required",
+		},
+		{
+			name:    "invalid expiry",
+			mutate:  func(r *ExemptionRule) { r.ExpiresAt = "next week" },
+			wantErr: "invalid expires_at",
+		},
+		{
+			name:   "rfc3339 expiry",
+			mutate: func(r *ExemptionRule) { r.ExpiresAt = "2030-01-01T12:00:00Z" },
+		},
+		{
+			name:    "invalid regex",
+			mutate:  func(r *ExemptionRule) { r.MetricName = ""; r.MetricNameRegex = "(" },
+			wantErr: "invalid metric_name_regex",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := valid
+			tc.mutate(&rule)
+			_, err := compileExemption(rule)
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestExemptionsMatch(t *testing.T) {
+	ex, err := newExemptions(ExemptionsConfig{
+		Rules: []ExemptionRule{
+			{
+				MetricNameRegex:    "migration_.*",
+				Job:                "myJob",
+				ResourceAttributes: map[string]string{"env": "prod"},
+				Owner:              "team-a",
+				Reason:             "migration",
+				ExpiresAt:          "2030-01-01",
+			},
+			{
+				MetricName: "legacy_metric",
+				Owner:      "team-b",
+				Reason:     "legacy dashboards",
+				ExpiresAt:  "2020-01-01",
+			},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	prod := pcommon.NewMap()
+	prod.PutStr("env", "prod")
+
+	require.NotNil(t, ex.match(now, "myJob", "migration_metric", prod))
+	require.Nil(t, ex.match(now, "otherJob", "migration_metric", prod))
+	require.Nil(t, ex.match(now, "myJob", "migration_metric", pcommon.NewMap()))
+	require.Nil(t, ex.match(now, "myJob", "other_metric", prod))
+	// the whole name has to match
+	require.Nil(t, ex.match(now, "myJob", "old_migration_metric", prod))
+
+	// expired exemptions no longer apply
+	require.Nil(t, ex.match(now, "myJob", "legacy_metric", prod))
+	require.Nil(t, ex.match(now.AddDate(10, 0, 0), "myJob", "migration_metric", prod))
+}
+
+func TestExemptionsFileReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "exemptions.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+rules:
+  - metric_name: first_metric
+    owner: team-a
+    reason: migration
+    expires_at: "2030-01-01"
+`), 0o600))
+
+	ex, err := newExemptions(ExemptionsConfig{File: file, ReloadInterval: time.Hour}, zap.NewNop())
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NotNil(t, ex.match(now, "myJob", "first_metric", pcommon.NewMap()))
+
+	require.NoError(t, os.WriteFile(file, []byte(`
+rules:
+  - metric_name: second_metric
+    owner: team-a
+    reason: migration
+    expires_at: "2030-01-01"
+  - metric_name: third_metric
+    owner: team-a
+    reason: migration
+    expires_at: "2030-01-01"
+`), 0o600))
+	ex.reload()
+	require.Nil(t, ex.match(now, "myJob", "first_metric", pcommon.NewMap()))
+	require.NotNil(t, ex.match(now, "myJob", "second_metric", pcommon.NewMap()))
+
+	// an invalid file keeps the previous rules
+	require.NoError(t, os.WriteFile(file, []byte("rules: [{metric_name: broken}]"), 0o600))
+	ex.reload()
+	require.NotNil(t, ex.match(now, "myJob", "second_metric", pcommon.NewMap()))
+}
+
+func TestParseExpiryDateIsInclusive(t *testing.T) {
+	expiresAt, err := parseExpiry("2026-10-19")
+	require.NoError(t, err)
+	lastMoment := time.Date(2026, 10, 19, 23, 59, 59, 0, time.UTC)
+	require.True(t, lastMoment.Before(expiresAt))
+	require.False(t, time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC).Before(expiresAt))
+
+	expiresAt, err = parseExpiry("2026-10-19T12:00:00Z")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), expiresAt.UTC())
+}
+
+func TestExemptionsExpiryWarnedOnceAcrossReloads(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	file := filepath.Join(t.TempDir(), "exemptions.yaml")
+	rules := `
+rules:
+  - metric_name: expired_metric
+    owner: team-a
+    reason: migration
+    expires_at: "2020-01-01"
+`
+	require.NoError(t, os.WriteFile(file, []byte(rules), 0o600))
+	ex, err := newExemptions(ExemptionsConfig{File: file, ReloadInterval: time.Hour}, zap.New(core))
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, ex.match(now, "myJob", "expired_metric", pcommon.NewMap()))
+	require.Equal(t, 1, logs.FilterMessageSnippet("exemption has expired").Len())
+
+	// another rule is added, the expired one is unchanged
+	require.NoError(t, os.WriteFile(file, []byte(rules+`
+  - metric_name: new_metric
+    owner: team-a
+    reason: migration
+    expires_at: "2030-01-01"
+`), 0o600))
+	ex.reload()
+	require.NotNil(t, ex.match(now, "myJob", "new_metric", pcommon.NewMap()))
+	require.Equal(t, 1, logs.FilterMessageSnippet("exemption has expired").Len())
+}
@@ -0,0 +1,103 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package rules holds the decisions taking precedence over those of the
+// server, shared by the components applying usage decisions: keep overrides,
+// exemptions and the keep and drop conditions.
+package rules // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+
+import (
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+// Actions of a verdict.
+const (
+	ActionKeep = "keep"
+	ActionDrop = "drop"
+)
+
+// Sources of a verdict.
+const (
+	SourceOverride  = "override"
+	SourceExemption = "exemption"
+	SourceCondition = "condition"
+)
+
+// Verdict is the action applied to a metric of a job and what it comes from.
+type Verdict struct {
+	Action   string
+	Source   string
+	Reason   string
+	Override *usagestore.Override
+}
+
+// Rules combines the exemptions and the conditions of a component.
+type Rules struct {
+	exemptions *exemptions
+	conditions *conditions
+}
+
+// New compiles the rules and loads the exemptions file, if any.
+func New(cfg Config, set component.TelemetrySettings, logger *zap.Logger) (*Rules, error) {
+	exemptions, err := newExemptions(cfg.Exemptions, logger)
+	if err != nil {
+		return nil, err
+	}
+	conditions, err := newConditions(cfg, set)
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{exemptions: exemptions, conditions: conditions}, nil
+}
+
+// Start watches the exemptions file for changes until Shutdown is called.
+func (r *Rules) Start() {
+	r.exemptions.start()
+}
+
+func (r *Rules) Shutdown() {
+	r.exemptions.shutdown()
+}
+
+// Exempted reports whether an active exemption matches the metric.
+func (r *Rules) Exempted(now time.Time, job string, metricName string, resource pcommon.Map) bool {
+	return r.exemptions.match(now, job, metricName, resource) != nil
+}
+
+// Candidates returns the active exemptions matching the job and metric name,
+// regardless of their resource attribute selectors.
+func (r *Rules) Candidates(now time.Time, job string, metricName string) []ExemptionRule {
+	return r.exemptions.candidates(now, job, metricName)
+}
+
+// Preceding returns the verdict taking precedence over the decision of the
+// server, if any: a keep override, then an exemption, then the keep and drop
+// conditions.
+func (r *Rules) Preceding(
+	now time.Time,
+	store *usagestore.Store,
+	job string,
+	metricName string,
+	resource pcommon.Map,
+	condition Result,
+) (Verdict, bool) {
+	if override, ok := store.ActiveOverride(now, job, metricName); ok {
+		return Verdict{Action: ActionKeep, Source: SourceOverride, Reason: override.Reason, Override: &override}, true
+	}
+	if e := r.exemptions.match(now, job, metricName, resource); e != nil {
+		return Verdict{Action: ActionKeep, Source: SourceExemption, Reason: e.rule.Reason}, true
+	}
+	switch condition {
+	case ResultKeep:
+		return Verdict{Action: ActionKeep, Source: SourceCondition, Reason: "matched a keep condition"}, true
+	case ResultDrop:
+		return Verdict{Action: ActionDrop, Source: SourceCondition, Reason: "matched a drop condition"}, true
+	}
+	return Verdict{}, false
+}
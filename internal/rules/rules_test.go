@@ -0,0 +1,57 @@
+package rules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+func TestPrecedingVerdicts(t *testing.T) {
+	cfg := Config{
+		Exemptions: ExemptionsConfig{Rules: []ExemptionRule{{
+			MetricName: "exempted_metric",
+			Owner:      "team-a",
+			Reason:     "migration",
+			ExpiresAt:  "2030-01-01",
+		}}},
+		DropConditions: ConditionsConfig{Metric: []string{`name == "dropped_metric"`}},
+	}
+	require.NoError(t, cfg.Validate())
+	r, err := New(cfg, componenttest.NewNopTelemetrySettings(), zap.NewNop())
+	require.NoError(t, err)
+
+	storeCfg := &usagestore.Config{Server: usagestore.ServerConfig{Address: "http://localhost:0"}}
+	require.NoError(t, storeCfg.Validate())
+	store := usagestore.NewStore(usagestore.Settings{Logger: zap.NewNop()}, storeCfg, nil)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.AddOverride(usagestore.Override{Job: "myJob", Metric: "dropped_metric", Reason: "INC-1", ExpiresAt: now.Add(time.Hour)})
+
+	testCases := []struct {
+		job    string
+		metric string
+		want   Verdict
+		ok     bool
+	}{
+		{job: "myJob", metric: "dropped_metric", want: Verdict{Action: ActionKeep, Source: SourceOverride, Reason: "INC-1"}, ok: true},
+		{job: "otherJob", metric: "dropped_metric", want: Verdict{Action: ActionDrop, Source: SourceCondition, Reason: "matched a drop condition"}, ok: true},
+		{job: "myJob", metric: "exempted_metric", want: Verdict{Action: ActionKeep, Source: SourceExemption, Reason: "migration"}, ok: true},
+		{job: "myJob", metric: "other_metric"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.job+"/"+tc.metric, func(t *testing.T) {
+			condition, err := r.EvalName(context.Background(), tc.job, tc.metric)
+			require.NoError(t, err)
+			v, ok := r.Preceding(now, store, tc.job, tc.metric, pcommon.NewMap(), condition)
+			require.Equal(t, tc.ok, ok)
+			v.Override = nil
+			require.Equal(t, tc.want, v)
+		})
+	}
+}
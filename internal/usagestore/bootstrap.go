@@ -0,0 +1,147 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	bootstrapRetryInitialInterval = 10 * time.Second
+	bootstrapRetryMaxInterval     = 5 * time.Minute
+)
+
+// loadBootstrap serves the decisions of the bootstrap file when the server
+// cannot be reached at start, so edge collectors enforce them right away.
+// The bootstrap decisions do not expire with the cache ttl and are replaced
+// as soon as the server answers for them. The decisions of the snapshot jobs
+// build the decision index instead, unless one was restored, and are
+// replaced by the first snapshot fetched from the server.
+func (s *Store) loadBootstrap(ctx context.Context) {
+	if s.config.BootstrapFile == "" {
+		return
+	}
+
+	pingCtx, cancel := s.withLifetime(ctx)
+	err := s.client.Ping(pingCtx)
+	cancel()
+	if err == nil {
+		return
+	}
+	s.logger.Warn("server is unreachable, loading bootstrap decisions",
+		zap.String("file", s.config.BootstrapFile),
+		zap.Error(err),
+	)
+
+	snapshot, err := readBootstrapFile(s.config.BootstrapFile, s.config.BootstrapMaxAge, time.Now())
+	if err != nil {
+		s.logger.Warn("ignoring bootstrap file", zap.Error(err))
+		return
+	}
+	indexed := 0
+	if len(s.config.Snapshot.Jobs) > 0 && s.index.Load() == nil {
+		s.restoreIndex(bootstrapIndex(snapshot, s.config.Snapshot.Jobs))
+		if idx := s.index.Load(); idx != nil {
+			indexed = idx.len()
+		}
+	}
+	loaded := 0
+	for _, d := range snapshot.Decisions {
+		if slices.Contains(s.config.Snapshot.Jobs, d.Job) {
+			// answered by the decision index
+			continue
+		}
+		if s.cache.setBootstrap(Key{Job: d.Job, Metric: d.Metric}, d.usage(), d.FetchedAt) {
+			loaded++
+		}
+	}
+	s.logger.Info("loaded bootstrap decisions",
+		zap.Int("decisions", loaded),
+		zap.Int("indexed", indexed),
+		zap.Time("created_at", snapshot.CreatedAt),
+	)
+	s.replaceBootstrap()
+}
+
+// bootstrapIndex returns the decision index of the snapshot jobs in the
+// snapshot, completed with their unused cached decisions. The jobs completed
+// this way lose their version so the first snapshot fetches them again.
+func bootstrapIndex(snapshot *Snapshot, jobs []string) []SnapshotIndexJob {
+	index := make([]SnapshotIndexJob, 0, len(snapshot.Index))
+	positions := map[string]int{}
+	indexed := map[Key]struct{}{}
+	for _, job := range snapshot.Index {
+		positions[job.Job] = len(index)
+		index = append(index, SnapshotIndexJob{Job: job.Job, ETag: job.ETag, Unused: slices.Clone(job.Unused)})
+		for _, metric := range job.Unused {
+			indexed[Key{Job: job.Job, Metric: metric}] = struct{}{}
+		}
+	}
+	for _, d := range snapshot.Decisions {
+		key := Key{Job: d.Job, Metric: d.Metric}
+		if _, ok := indexed[key]; ok || !d.Unused || !slices.Contains(jobs, d.Job) {
+			continue
+		}
+		indexed[key] = struct{}{}
+		i, ok := positions[d.Job]
+		if !ok {
+			i = len(index)
+			positions[d.Job] = i
+			index = append(index, SnapshotIndexJob{Job: d.Job})
+		}
+		index[i].Unused = append(index[i].Unused, d.Metric)
+		index[i].ETag = ""
+	}
+	return index
+}
+
+func readBootstrapFile(path string, maxAge time.Duration, now time.Time) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	if age := now.Sub(snapshot.CreatedAt); age > maxAge {
+		return nil, fmt.Errorf("bootstrap snapshot is %s old, older than the maximum age of %s",
+			age.Truncate(time.Second), maxAge)
+	}
+	return snapshot, nil
+}
+
+// replaceBootstrap refreshes the bootstrap decisions in the background,
+// backing off while the server is unreachable.
+func (s *Store) replaceBootstrap() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		interval := bootstrapRetryInitialInterval
+		for {
+			keys := s.cache.bootstrapKeys()
+			if len(keys) == 0 {
+				s.logger.Info("replaced bootstrap decisions with decisions from the server")
+				return
+			}
+			timer := time.NewTimer(interval)
+			select {
+			case <-s.lifetime.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if _, err := s.refreshKeys(s.lifetime, keys); err != nil && s.lifetime.Err() == nil {
+				s.logger.Debug("failed to refresh bootstrap decisions", zap.Error(err))
+			}
+			interval = min(2*interval, bootstrapRetryMaxInterval)
+		}
+	}()
+}
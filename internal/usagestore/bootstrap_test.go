@@ -0,0 +1,200 @@
+package usagestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// exportBootstrap writes the snapshot exported by a store that knows the
+// decisions to a bootstrap file.
+func exportBootstrap(t *testing.T, decisions map[string]map[string]bool, createdAt time.Time) string {
+	s := newTestStore(newTestConfig(t, nil), &fakeClient{decisions: decisions})
+	for job, metrics := range decisions {
+		for metric := range metrics {
+			_, err := s.Lookup(context.Background(), Key{Job: job, Metric: metric})
+			require.NoError(t, err)
+		}
+	}
+
+	snapshot := s.Export(time.Now())
+	snapshot.CreatedAt = createdAt
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "bootstrap.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func startWithBootstrap(t *testing.T, path string, f *fakeClient) *Store {
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.BootstrapFile = path
+		cfg.BootstrapMaxAge = time.Hour
+	})
+	return startTestStore(t, cfg, f)
+}
+
+func TestBootstrapWhenServerUnreachable(t *testing.T) {
+	path := exportBootstrap(t, map[string]map[string]bool{"myJob": {"unused_metric": true}}, time.Now())
+
+	unreachable := &fakeClient{
+		pingErr: errors.New("connection refused"),
+		errFor:  map[string]map[string]error{"myJob": {"unused_metric": errors.New("connection refused")}},
+	}
+	s := startWithBootstrap(t, path, unreachable)
+
+	entry, err := s.Lookup(context.Background(), Key{Job: "myJob", Metric: "unused_metric"})
+	require.NoError(t, err)
+	require.True(t, entry.Usage.Unused)
+	require.True(t, entry.Bootstrap)
+	require.Zero(t, unreachable.calls.Load())
+}
+
+func TestBootstrapBuildsDecisionIndex(t *testing.T) {
+	// jobA is in the index of the snapshot, jobB was only looked up
+	exporter := newTestStore(newTestConfig(t, func(cfg *Config) { cfg.Snapshot.Jobs = []string{"jobA"} }),
+		&fakeClient{decisions: map[string]map[string]bool{"jobA": {"a": true}, "jobB": {"b": true, "c": false}}, etag: "v1"})
+	exporter.refreshIndex()
+	for _, metric := range []string{"b", "c"} {
+		_, err := exporter.Lookup(context.Background(), Key{Job: "jobB", Metric: metric})
+		require.NoError(t, err)
+	}
+	data, err := json.Marshal(exporter.Export(time.Now()))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "bootstrap.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	unreachable := &fakeClient{pingErr: errors.New("connection refused"), listErr: errors.New("connection refused")}
+	s := startTestStore(t, newTestConfig(t, func(cfg *Config) {
+		cfg.BootstrapFile = path
+		cfg.BootstrapMaxAge = time.Hour
+		cfg.Snapshot.Jobs = []string{"jobA", "jobB"}
+	}), unreachable)
+
+	require.True(t, s.Unused(Key{Job: "jobA", Metric: "a"}))
+	require.True(t, s.Unused(Key{Job: "jobB", Metric: "b"}))
+	require.False(t, s.Unused(Key{Job: "jobB", Metric: "c"}))
+	require.Empty(t, s.cache.keys(time.Now()), "the decisions of the snapshot jobs are not cached")
+	require.Equal(t, map[string]string{"jobA": "v1", "jobB": ""}, s.index.Load().etags)
+}
+
+func TestBootstrapIgnored(t *testing.T) {
+	decisions := map[string]map[string]bool{"myJob": {"unused_metric": true}}
+
+	t.Run("server reachable", func(t *testing.T) {
+		s := startWithBootstrap(t, exportBootstrap(t, decisions, time.Now()), &fakeClient{})
+		require.Empty(t, s.cache.keys(time.Now()))
+	})
+
+	t.Run("snapshot too old", func(t *testing.T) {
+		path := exportBootstrap(t, decisions, time.Now().Add(-2*time.Hour))
+		s := startWithBootstrap(t, path, &fakeClient{pingErr: errors.New("connection refused")})
+		require.Empty(t, s.cache.keys(time.Now()))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing.json")
+		s := startWithBootstrap(t, path, &fakeClient{pingErr: errors.New("connection refused")})
+		require.Empty(t, s.cache.keys(time.Now()))
+	})
+}
+
+// barrierClient answers the lookups only once as many of them as the
+// barrier counts are in flight, or fails when the context is done.
+type barrierClient struct {
+	*fakeClient
+	barrier sync.WaitGroup
+}
+
+func (c *barrierClient) GetMetricUsage(ctx context.Context, job string, name string) (usage.MetricUsage, error) {
+	c.barrier.Done()
+	done := make(chan struct{})
+	go func() {
+		c.barrier.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return c.fakeClient.GetMetricUsage(ctx, job, name)
+	case <-ctx.Done():
+		return usage.MetricUsage{}, ctx.Err()
+	}
+}
+
+func TestBootstrapRefreshedConcurrently(t *testing.T) {
+	decisions := map[string]map[string]bool{"myJob": {"metric_a": true, "metric_b": true, "metric_c": false, "metric_d": true}}
+	client := &barrierClient{fakeClient: &fakeClient{decisions: decisions}}
+	client.barrier.Add(4)
+	cfg := newTestConfig(t, func(cfg *Config) {
+		// half the slots are used by the refresh
+		cfg.MaxConcurrentLookups = 8
+	})
+	s := newTestStore(cfg, client)
+	for metric := range decisions["myJob"] {
+		s.cache.setBootstrap(Key{Job: "myJob", Metric: metric}, usage.MetricUsage{Unused: true}, time.Now())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	refreshed, err := s.refreshKeys(ctx, s.cache.bootstrapKeys())
+	require.NoError(t, err, "the bootstrap decisions are refreshed one at a time")
+	require.Equal(t, 4, refreshed)
+	require.Empty(t, s.cache.bootstrapKeys())
+	entry, ok := s.Cached(Key{Job: "myJob", Metric: "metric_c"}, time.Now())
+	require.True(t, ok)
+	require.False(t, entry.Usage.Unused)
+}
+
+// gateClient blocks the lookups of the gated metrics until the gate is closed.
+type gateClient struct {
+	*fakeClient
+	gated map[string]bool
+	gate  chan struct{}
+}
+
+func (c *gateClient) GetMetricUsage(ctx context.Context, job string, name string) (usage.MetricUsage, error) {
+	if c.gated[name] {
+		<-c.gate
+	}
+	return c.fakeClient.GetMetricUsage(ctx, job, name)
+}
+
+func TestRefreshLeavesLookupSlotsToBatches(t *testing.T) {
+	client := &gateClient{
+		fakeClient: &fakeClient{},
+		gated:      map[string]bool{"metric_a": true, "metric_b": true, "metric_c": true},
+		gate:       make(chan struct{}),
+	}
+	s := newTestStore(newTestConfig(t, func(cfg *Config) {
+		cfg.MaxConcurrentLookups = 2
+	}), client)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = s.refreshKeys(context.Background(), []Key{
+			{Job: "myJob", Metric: "metric_a"},
+			{Job: "myJob", Metric: "metric_b"},
+			{Job: "myJob", Metric: "metric_c"},
+		})
+	}()
+	require.Eventually(t, func() bool { return client.calls.Load() == 0 && len(s.lookupSlots) == 1 }, time.Second, time.Millisecond)
+
+	// the refresh holds one slot, the lookup of a batch gets the other one
+	_, err := s.Lookup(context.Background(), Key{Job: "myJob", Metric: "live_metric"})
+	require.NoError(t, err)
+	require.Len(t, s.lookupSlots, 1)
+	close(client.gate)
+	<-done
+	require.Equal(t, int64(4), client.calls.Load())
+}
@@ -0,0 +1,221 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// Key identifies the decision of a metric of a job.
+type Key struct {
+	Job    string
+	Metric string
+}
+
+// Decision is the answer of the server for a key.
+type Decision struct {
+	Usage     usage.MetricUsage
+	FetchedAt time.Time
+	// loaded from the bootstrap file, served regardless of the ttl until
+	// the server answers for the key
+	Bootstrap bool
+}
+
+// how often the decisions that are no longer looked up are evicted
+const cacheEvictInterval = time.Minute
+
+// decisionCache keeps the answers of the analytics server per (job, metric)
+// so each key is only looked up once per ttl. Keys that are not looked up
+// for maxIdle are evicted, so the cache does not grow with every metric ever
+// seen.
+type decisionCache struct {
+	ttl     time.Duration
+	maxIdle time.Duration
+	mu      sync.RWMutex
+	entries map[Key]*cacheEntry
+}
+
+type cacheEntry struct {
+	decision Decision
+	// last time the key was looked up, in unix nanoseconds, updated under
+	// the read lock
+	accessed atomic.Int64
+}
+
+func newCacheEntry(d Decision, now time.Time) *cacheEntry {
+	e := &cacheEntry{decision: d}
+	e.accessed.Store(now.UnixNano())
+	return e
+}
+
+func newDecisionCache(ttl time.Duration, maxIdle time.Duration) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		maxIdle: maxIdle,
+		entries: map[Key]*cacheEntry{},
+	}
+}
+
+func (c *decisionCache) get(key Key, now time.Time) (Decision, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return Decision{}, false
+	}
+	entry.accessed.Store(now.UnixNano())
+	if !c.valid(entry.decision, now) {
+		return Decision{}, false
+	}
+	return entry.decision, true
+}
+
+func (c *decisionCache) valid(entry Decision, now time.Time) bool {
+	return entry.Bootstrap || now.Sub(entry.FetchedAt) < c.ttl
+}
+
+// set caches the decision of the key and returns the decision it replaced,
+// expired or not, if any.
+func (c *decisionCache) set(key Key, u usage.MetricUsage, now time.Time) (Decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, ok := c.entries[key]
+	if !ok {
+		c.entries[key] = newCacheEntry(Decision{Usage: u, FetchedAt: now}, now)
+		return Decision{}, false
+	}
+	d := prev.decision
+	prev.decision = Decision{Usage: u, FetchedAt: now}
+	return d, true
+}
+
+// update replaces the decision of a cached key, expired or not, and returns
+// the decision it replaced. Keys that are not cached are left alone.
+func (c *decisionCache) update(key Key, u usage.MetricUsage, now time.Time) (Decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, ok := c.entries[key]
+	if !ok {
+		return Decision{}, false
+	}
+	d := prev.decision
+	prev.decision = Decision{Usage: u, FetchedAt: now}
+	return d, true
+}
+
+// setBootstrap caches a bootstrap decision unless the key is already cached,
+// and reports whether it did.
+func (c *decisionCache) setBootstrap(key Key, u usage.MetricUsage, fetchedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+	c.entries[key] = newCacheEntry(Decision{Usage: u, FetchedAt: fetchedAt, Bootstrap: true}, time.Now())
+	return true
+}
+
+// bootstrapKeys returns the keys still served from the bootstrap file.
+func (c *decisionCache) bootstrapKeys() []Key {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var keys []Key
+	for key, entry := range c.entries {
+		if entry.decision.Bootstrap {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// keys returns the keys of the unexpired entries, the expired ones are
+// looked up again when they are needed.
+func (c *decisionCache) keys(now time.Time) []Key {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]Key, 0, len(c.entries))
+	for key, entry := range c.entries {
+		if c.valid(entry.decision, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// snapshot returns a copy of the unexpired entries.
+func (c *decisionCache) snapshot(now time.Time) map[Key]Decision {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := make(map[Key]Decision, len(c.entries))
+	for key, entry := range c.entries {
+		if c.valid(entry.decision, now) {
+			entries[key] = entry.decision
+		}
+	}
+	return entries
+}
+
+// evict removes the entries that were not looked up for maxIdle and
+// returns how many were removed.
+func (c *decisionCache) evict(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	evicted := 0
+	for key, entry := range c.entries {
+		if now.Sub(time.Unix(0, entry.accessed.Load())) >= c.maxIdle {
+			delete(c.entries, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// flush removes every entry and returns how many were removed.
+func (c *decisionCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = map[Key]*cacheEntry{}
+	return n
+}
+
+// evictDecisions evicts the decisions that are no longer looked up every
+// evict interval.
+func (s *Store) evictDecisions() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(cacheEvictInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.lifetime.Done():
+				return
+			case now := <-ticker.C:
+				s.cache.evict(now)
+			}
+		}
+	}()
+}
+
+// Reason describes why the analytics server considers a metric used or unused.
+func Reason(u usage.MetricUsage) string {
+	if u.Unused {
+		return "unused: not referenced by alerts, recording rules, dashboards or queries"
+	}
+	if u.Summary == nil {
+		return "used"
+	}
+	return fmt.Sprintf("used: %d alerts, %d recording rules, %d dashboards, %d queries",
+		u.Summary.AlertCount,
+		u.Summary.RecordCount,
+		u.Summary.DashboardCount,
+		u.Summary.QueryCount,
+	)
+}
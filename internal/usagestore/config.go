@@ -0,0 +1,226 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+var (
+	defaultTimeout                 = 10 * time.Second
+	defaultPageTimeout             = 5 * time.Minute
+	defaultCacheTTL                = 5 * time.Minute
+	defaultCacheMaxIdle            = time.Hour
+	defaultWarmupTimeout           = 30 * time.Second
+	defaultHealthFailureThreshold  = 5
+	defaultPersistenceInterval     = time.Minute
+	defaultBootstrapMaxAge         = 24 * time.Hour
+	defaultMaxConcurrentLookups    = 8
+	defaultSnapshotRefreshInterval = 5 * time.Minute
+	defaultWatchInitialBackoff     = time.Second
+	defaultWatchMaxBackoff         = time.Minute
+)
+
+// Config configures the connection to the analytics server and how the
+// decisions it returns are kept. Components embed it with
+// `mapstructure:",squash"`.
+type Config struct {
+	Server ServerConfig `mapstructure:"server"`
+
+	// cache of the decisions returned by the server
+	Cache CacheConfig `mapstructure:"cache"`
+
+	// decisions fetched in bulk into the decision index
+	Snapshot SnapshotConfig `mapstructure:"snapshot"`
+
+	// decision changes pushed by the server
+	Watch WatchConfig `mapstructure:"watch"`
+
+	// decisions fetched when the collector starts
+	Warmup WarmupConfig `mapstructure:"warmup"`
+
+	// decisions persisted across restarts through a storage extension
+	Persistence PersistenceConfig `mapstructure:"persistence"`
+
+	// decision snapshot exported by the admin API, loaded at start when the
+	// server is unreachable
+	BootstrapFile string `mapstructure:"bootstrap_file"`
+
+	// age of the bootstrap snapshot after which it is ignored
+	// default is 24 hours
+	BootstrapMaxAge time.Duration `mapstructure:"bootstrap_max_age"`
+
+	// component status reported to the health_check extension
+	Health HealthConfig `mapstructure:"health"`
+
+	// requests to the server running in parallel, set by the embedding component
+	// default is 8
+	MaxConcurrentLookups int `mapstructure:"-"`
+}
+
+type ServerConfig struct {
+	// address of the server to connect to
+	Address string `mapstructure:"address"`
+
+	// timeout for the client to connect to the server
+	// default is 10 seconds
+	Timeout *time.Duration `mapstructure:"timeout"`
+
+	// time a page of decisions fetched in bulk may take to be read, the
+	// response headers are still expected within the timeout
+	// default is 5 minutes
+	PageTimeout time.Duration `mapstructure:"page_timeout"`
+
+	// tls configuration
+	TlsConfig TLSConfig `mapstructure:"tls_config"`
+}
+
+type TLSConfig struct {
+	// if true, the server's certificate will not be verified
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+type CacheConfig struct {
+	// how long a decision returned by the server is reused
+	// default is 5 minutes
+	TTL time.Duration `mapstructure:"ttl"`
+
+	// how long a decision is kept once its metric is no longer looked up
+	// default is 1 hour, at least the ttl
+	MaxIdle time.Duration `mapstructure:"max_idle"`
+}
+
+type SnapshotConfig struct {
+	// jobs whose decisions are fetched in bulk into the decision index
+	Jobs []string `mapstructure:"jobs"`
+
+	// how often the decisions are fetched
+	// default is 5 minutes
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+
+	// maximum random delay added to every refresh so collectors do not refresh in lockstep
+	// default is a tenth of the refresh interval
+	RefreshJitter time.Duration `mapstructure:"refresh_jitter"`
+
+	// estimated size in bytes of the decision index above which a snapshot is rejected
+	// unlimited by default
+	MaxIndexSize int64 `mapstructure:"max_index_size"`
+
+	// check a Bloom filter before searching the index
+	BloomFilter bool `mapstructure:"bloom_filter"`
+}
+
+type WatchConfig struct {
+	// apply the decision changes streamed by the server as they happen
+	Enabled bool `mapstructure:"enabled"`
+
+	// delay before the first reconnection, doubled on every failed attempt
+	// default is 1 second
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+
+	// maximum delay between reconnections
+	// default is 1 minute
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+}
+
+type WarmupConfig struct {
+	// jobs whose decisions are fetched before the first batch is processed
+	Jobs []string `mapstructure:"jobs"`
+
+	// how long start waits for the warm-up, it continues in the background afterwards
+	// default is 30 seconds
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type PersistenceConfig struct {
+	// component ID of the storage extension, persistence is disabled if empty
+	Storage *component.ID `mapstructure:"storage"`
+
+	// how often decisions are persisted, they are also persisted on shutdown
+	// default is 1 minute
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+type HealthConfig struct {
+	// consecutive failed calls to the server after which a recoverable error is reported
+	// default is 5
+	FailureThreshold int `mapstructure:"failure_threshold"`
+
+	// age of the last bulk fetch of decisions after which a recoverable error is reported
+	// disabled by default
+	MaxSnapshotAge time.Duration `mapstructure:"max_snapshot_age"`
+}
+
+// Validate applies the defaults. The server address is required by the
+// embedding components, a processor referencing a usage extension does not
+// need one.
+func (c *Config) Validate() error {
+	if c.Server.Timeout == nil {
+		c.Server.Timeout = &defaultTimeout
+	}
+	if c.Server.PageTimeout <= 0 {
+		c.Server.PageTimeout = defaultPageTimeout
+	}
+	if c.Cache.TTL <= 0 {
+		c.Cache.TTL = defaultCacheTTL
+	}
+	if c.Cache.MaxIdle <= 0 {
+		c.Cache.MaxIdle = defaultCacheMaxIdle
+	}
+	c.Cache.MaxIdle = max(c.Cache.MaxIdle, c.Cache.TTL)
+	if c.Snapshot.RefreshInterval <= 0 {
+		c.Snapshot.RefreshInterval = defaultSnapshotRefreshInterval
+	}
+	if c.Snapshot.RefreshJitter < 0 {
+		return errors.New("snapshot refresh_jitter must not be negative")
+	}
+	if c.Snapshot.RefreshJitter == 0 {
+		c.Snapshot.RefreshJitter = c.Snapshot.RefreshInterval / 10
+	}
+	if c.Snapshot.MaxIndexSize < 0 {
+		return errors.New("snapshot max_index_size must not be negative")
+	}
+	if c.MaxConcurrentLookups <= 0 {
+		c.MaxConcurrentLookups = defaultMaxConcurrentLookups
+	}
+	if c.Watch.InitialBackoff <= 0 {
+		c.Watch.InitialBackoff = defaultWatchInitialBackoff
+	}
+	if c.Watch.MaxBackoff <= 0 {
+		c.Watch.MaxBackoff = defaultWatchMaxBackoff
+	}
+	if c.Warmup.Timeout <= 0 {
+		c.Warmup.Timeout = defaultWarmupTimeout
+	}
+	if c.Persistence.Interval <= 0 {
+		c.Persistence.Interval = defaultPersistenceInterval
+	}
+	if c.BootstrapMaxAge <= 0 {
+		c.BootstrapMaxAge = defaultBootstrapMaxAge
+	}
+	if c.Health.FailureThreshold <= 0 {
+		c.Health.FailureThreshold = defaultHealthFailureThreshold
+	}
+	if c.Health.MaxSnapshotAge < 0 {
+		return errors.New("health max_snapshot_age must not be negative")
+	}
+	return nil
+}
+
+// ClientConfig returns the configuration of the client to the server.
+func (c *Config) ClientConfig() *usage.Config {
+	return &usage.Config{
+		Address:     c.Server.Address,
+		Timeout:     c.Server.Timeout,
+		PageTimeout: c.Server.PageTimeout,
+		TlsConfig: usage.TLSConfig{
+			InsecureSkipVerify: c.Server.TlsConfig.InsecureSkipVerify,
+		},
+	}
+}
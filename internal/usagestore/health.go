@@ -0,0 +1,168 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componentstatus"
+)
+
+// health tracks the outcome of the calls to the server and reports the
+// component status through the host, so the health_check extension can
+// surface that the components fell back to keeping every metric.
+type health struct {
+	failureThreshold int
+	maxSnapshotAge   time.Duration
+
+	mu                  sync.Mutex
+	host                component.Host
+	status              componentstatus.Status
+	permanent           bool
+	consecutiveFailures int
+	lastError           error
+	// last time decisions were fetched in bulk, zero if they never were
+	lastSnapshot time.Time
+}
+
+func newHealth(cfg HealthConfig) *health {
+	return &health{
+		failureThreshold: cfg.FailureThreshold,
+		maxSnapshotAge:   cfg.MaxSnapshotAge,
+		status:           componentstatus.StatusStarting,
+	}
+}
+
+// start reports the initial status, which is a permanent error when the
+// server address cannot be used, as reported by addressErr.
+func (h *health) start(host component.Host, addressErr error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.host = host
+	if addressErr != nil {
+		h.permanent = true
+		componentstatus.ReportStatus(host, componentstatus.NewPermanentErrorEvent(addressErr))
+		return
+	}
+	h.reportLocked(time.Now())
+}
+
+func validateAddress(address string) error {
+	u, err := url.Parse(address)
+	if err != nil {
+		return fmt.Errorf("invalid server address: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid server address %q: scheme must be http or https", address)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid server address %q: missing host", address)
+	}
+	return nil
+}
+
+func (h *health) recordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutiveFailures = 0
+	h.lastError = nil
+	h.reportLocked(time.Now())
+}
+
+func (h *health) recordFailure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutiveFailures++
+	h.lastError = err
+	h.reportLocked(time.Now())
+}
+
+func (h *health) recordSnapshot(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastSnapshot = now
+	h.consecutiveFailures = 0
+	h.lastError = nil
+	h.reportLocked(now)
+}
+
+// restoreSnapshot remembers when the restored decisions were fetched, so
+// their age keeps counting across restarts.
+func (h *health) restoreSnapshot(at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if at.After(h.lastSnapshot) {
+		h.lastSnapshot = at
+	}
+}
+
+func (h *health) lastSnapshotAt() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSnapshot
+}
+
+// check re-evaluates the status, which can change without any call to the
+// server as the last snapshot gets older.
+func (h *health) check(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reportLocked(now)
+}
+
+func (h *health) reportLocked(now time.Time) {
+	if h.host == nil || h.permanent {
+		return
+	}
+
+	var err error
+	switch {
+	case h.failureThreshold > 0 && h.consecutiveFailures >= h.failureThreshold:
+		err = fmt.Errorf("%d consecutive calls to the server failed, keeping every metric: %w", h.consecutiveFailures, h.lastError)
+	case h.maxSnapshotAge > 0 && !h.lastSnapshot.IsZero() && now.Sub(h.lastSnapshot) > h.maxSnapshotAge:
+		err = fmt.Errorf("decisions were last fetched %s ago, more than the allowed %s", now.Sub(h.lastSnapshot).Truncate(time.Second), h.maxSnapshotAge)
+	}
+
+	if err == nil {
+		if h.status != componentstatus.StatusOK {
+			h.status = componentstatus.StatusOK
+			componentstatus.ReportStatus(h.host, componentstatus.NewEvent(componentstatus.StatusOK))
+		}
+		return
+	}
+	if h.status == componentstatus.StatusRecoverableError {
+		return
+	}
+	h.status = componentstatus.StatusRecoverableError
+	componentstatus.ReportStatus(h.host, componentstatus.NewRecoverableErrorEvent(err))
+}
+
+// healthCheckInterval is how often the age of the last snapshot is checked.
+const healthCheckInterval = 10 * time.Second
+
+// watchHealth periodically re-evaluates the component status so an aging
+// snapshot is reported even when no call to the server is made.
+func (s *Store) watchHealth() {
+	if s.config.Health.MaxSnapshotAge <= 0 {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.lifetime.Done():
+				return
+			case now := <-ticker.C:
+				s.health.check(now)
+			}
+		}
+	}()
+}
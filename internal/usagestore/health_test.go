@@ -0,0 +1,79 @@
+package usagestore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componentstatus"
+	"go.opentelemetry.io/collector/component/componenttest"
+)
+
+// statusHost records the status events reported by the processor.
+type statusHost struct {
+	component.Host
+	mu       sync.Mutex
+	statuses []componentstatus.Status
+}
+
+func newStatusHost() *statusHost {
+	return &statusHost{Host: componenttest.NewNopHost()}
+}
+
+func (h *statusHost) Report(event *componentstatus.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statuses = append(h.statuses, event.Status())
+}
+
+func (h *statusHost) reported() []componentstatus.Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]componentstatus.Status{}, h.statuses...)
+}
+
+func TestHealthPermanentErrorOnInvalidAddress(t *testing.T) {
+	host := newStatusHost()
+	h := newHealth(HealthConfig{FailureThreshold: 1})
+	h.start(host, validateAddress("localhost:9092"))
+	h.recordSuccess()
+	require.Equal(t, []componentstatus.Status{componentstatus.StatusPermanentError}, host.reported())
+}
+
+func TestHealthFailures(t *testing.T) {
+	host := newStatusHost()
+	h := newHealth(HealthConfig{FailureThreshold: 2})
+	h.start(host, validateAddress("http://localhost:9092"))
+
+	h.recordFailure(errors.New("boom"))
+	h.recordFailure(errors.New("boom"))
+	h.recordFailure(errors.New("boom"))
+	h.recordSuccess()
+
+	require.Equal(t, []componentstatus.Status{
+		componentstatus.StatusOK,
+		componentstatus.StatusRecoverableError,
+		componentstatus.StatusOK,
+	}, host.reported())
+}
+
+func TestHealthSnapshotAge(t *testing.T) {
+	host := newStatusHost()
+	h := newHealth(HealthConfig{FailureThreshold: 5, MaxSnapshotAge: time.Minute})
+	h.start(host, validateAddress("https://localhost:9092"))
+
+	now := time.Now()
+	h.recordSnapshot(now)
+	h.check(now.Add(30 * time.Second))
+	h.check(now.Add(2 * time.Minute))
+	h.recordSnapshot(now.Add(2 * time.Minute))
+
+	require.Equal(t, []componentstatus.Status{
+		componentstatus.StatusOK,
+		componentstatus.StatusRecoverableError,
+		componentstatus.StatusOK,
+	}, host.reported())
+}
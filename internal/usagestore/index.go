@@ -0,0 +1,359 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+const (
+	// estimated overhead of an interned string: the slice and map key string
+	// headers, the id and the map bookkeeping
+	internedStringOverhead = 2*16 + 4 + 16
+	// bits per key of the Bloom filter, about 1% false positives
+	bloomBitsPerKey = 10
+	// hash functions of the Bloom filter
+	bloomHashes = 7
+)
+
+var errIndexTooLarge = errors.New("decision index exceeds the maximum size")
+
+// decisionIndex is an immutable set of the unused (job, metric) pairs. Jobs
+// and metrics are interned so each name is stored once, and a pair is stored
+// as the 64 bits of its two ids in a sorted slice, so millions of pairs fit
+// in a few tens of megabytes.
+type decisionIndex struct {
+	ids    map[string]uint32
+	names  []string
+	unused []uint64
+	bloom  *bloomFilter
+	size   int64
+	// version of the decisions of every job, so the next refresh only
+	// fetches the jobs that changed
+	etags map[string]string
+}
+
+// contains reports whether the metric of the job is unused.
+func (idx *decisionIndex) contains(job string, metric string) bool {
+	jobID, ok := idx.ids[job]
+	if !ok {
+		return false
+	}
+	metricID, ok := idx.ids[metric]
+	if !ok {
+		return false
+	}
+	key := indexKey(jobID, metricID)
+	if idx.bloom != nil && !idx.bloom.mayContain(key) {
+		return false
+	}
+	_, found := slices.BinarySearch(idx.unused, key)
+	return found
+}
+
+// forEachUnused calls fn with every unused metric of the job.
+func (idx *decisionIndex) forEachUnused(job string, fn func(metric string) error) error {
+	jobID, ok := idx.ids[job]
+	if !ok {
+		return nil
+	}
+	i, _ := slices.BinarySearch(idx.unused, indexKey(jobID, 0))
+	for ; i < len(idx.unused) && uint32(idx.unused[i]>>32) == jobID; i++ {
+		if err := fn(idx.names[uint32(idx.unused[i])]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// len returns the number of unused pairs.
+func (idx *decisionIndex) len() int {
+	return len(idx.unused)
+}
+
+func indexKey(jobID uint32, metricID uint32) uint64 {
+	return uint64(jobID)<<32 | uint64(metricID)
+}
+
+// indexBuilder collects the unused pairs of a snapshot, failing as soon as
+// the estimated size of the index exceeds maxSize, if set.
+type indexBuilder struct {
+	maxSize int64
+	bloom   bool
+	idx     *decisionIndex
+}
+
+func newIndexBuilder(maxSize int64, bloom bool) *indexBuilder {
+	return &indexBuilder{
+		maxSize: maxSize,
+		bloom:   bloom,
+		idx:     &decisionIndex{ids: map[string]uint32{}},
+	}
+}
+
+func (b *indexBuilder) add(job string, metric string) error {
+	key := indexKey(b.intern(job), b.intern(metric))
+	b.idx.unused = append(b.idx.unused, key)
+	b.idx.size += 8
+	if b.maxSize > 0 && b.estimatedSize() > b.maxSize {
+		return fmt.Errorf("%w of %d bytes", errIndexTooLarge, b.maxSize)
+	}
+	return nil
+}
+
+func (b *indexBuilder) intern(s string) uint32 {
+	if id, ok := b.idx.ids[s]; ok {
+		return id
+	}
+	id := uint32(len(b.idx.names))
+	b.idx.ids[s] = id
+	b.idx.names = append(b.idx.names, s)
+	b.idx.size += int64(len(s)) + internedStringOverhead
+	return id
+}
+
+// estimatedSize includes the Bloom filter the index would get.
+func (b *indexBuilder) estimatedSize() int64 {
+	if !b.bloom {
+		return b.idx.size
+	}
+	return b.idx.size + bloomFilterSize(len(b.idx.unused))
+}
+
+func (b *indexBuilder) build() *decisionIndex {
+	idx := b.idx
+	slices.Sort(idx.unused)
+	idx.unused = slices.Clip(slices.Compact(idx.unused))
+	idx.size = int64(len(idx.unused)) * 8
+	for _, name := range idx.names {
+		idx.size += int64(len(name)) + internedStringOverhead
+	}
+	if b.bloom && len(idx.unused) > 0 {
+		idx.bloom = newBloomFilter(len(idx.unused))
+		for _, key := range idx.unused {
+			idx.bloom.add(key)
+		}
+		idx.size += bloomFilterSize(len(idx.unused))
+	}
+	return idx
+}
+
+// bloomFilter answers most lookups of used metrics without searching the
+// index. The hashes are derived from two halves of a mixed key.
+type bloomFilter struct {
+	bits []uint64
+}
+
+func newBloomFilter(n int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, bloomFilterSize(n)/8)}
+}
+
+func bloomFilterSize(n int) int64 {
+	words := int64(math.Ceil(float64(n*bloomBitsPerKey) / 64))
+	return max(words, 1) * 8
+}
+
+func (f *bloomFilter) add(key uint64) {
+	h1, h2 := bloomHashPair(key)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) mayContain(key uint64) bool {
+	h1, h2 := bloomHashPair(key)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashPair mixes the key with the splitmix64 finalizer.
+func bloomHashPair(key uint64) (uint64, uint64) {
+	z := key + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return z & math.MaxUint32, z>>32 | 1
+}
+
+// startIndexRefresh fetches the decisions of the snapshot jobs every refresh
+// interval, plus a random jitter. Like the warm-up, start waits for the
+// first snapshot up to the warm-up timeout, metrics are kept until then.
+func (s *Store) startIndexRefresh(ctx context.Context) {
+	if len(s.config.Snapshot.Jobs) == 0 {
+		return
+	}
+
+	first := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.refreshIndex()
+		close(first)
+
+		for {
+			wait := s.config.Snapshot.RefreshInterval
+			if jitter := s.config.Snapshot.RefreshJitter; jitter > 0 {
+				wait += rand.N(jitter)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-s.lifetime.Done():
+				timer.Stop()
+				return
+			case <-s.indexRefreshNow:
+				timer.Stop()
+				s.refreshIndex()
+			case <-timer.C:
+				s.refreshIndex()
+			}
+		}
+	}()
+
+	timer := time.NewTimer(s.config.Warmup.Timeout)
+	defer timer.Stop()
+	select {
+	case <-first:
+	case <-timer.C:
+		s.logger.Warn("first decision snapshot did not finish in time, continuing in the background",
+			zap.Duration("timeout", s.config.Warmup.Timeout),
+		)
+	case <-ctx.Done():
+	}
+}
+
+// refreshIndex replaces the decision index with the current decisions of
+// the snapshot jobs, and keeps the previous index if any of them fails.
+func (s *Store) refreshIndex() {
+	start := time.Now()
+	ctx, span := s.startSpan(s.lifetime, "usage/snapshot", attribute.Int(AttributeJobCount, len(s.config.Snapshot.Jobs)))
+	idx, changed, err := s.buildIndex(ctx)
+	span.SetAttributes(attribute.Bool(AttributeNotModified, err == nil && !changed))
+	if err == nil {
+		span.SetAttributes(attribute.Int(AttributeMetricCount, idx.len()))
+	}
+	EndSpan(span, err)
+	if err != nil {
+		if s.lifetime.Err() == nil {
+			s.logger.Error("failed to refresh the decision snapshot, keeping the previous one", zap.Error(err))
+			s.health.recordFailure(err)
+		}
+		return
+	}
+	s.health.recordSnapshot(time.Now())
+	s.overlay.prune(start)
+	if !changed {
+		s.logger.Debug("decision snapshot not modified", zap.Duration("duration", time.Since(start)))
+		return
+	}
+	s.index.Store(idx)
+	s.telemetry.RecordIndexSize(s.lifetime, idx.size)
+	s.logger.Info("refreshed the decision snapshot",
+		zap.Int("unused", idx.len()),
+		zap.Int64("size_bytes", idx.size),
+		zap.Duration("duration", time.Since(start)),
+	)
+}
+
+// requestIndexRefresh makes the index refresh fetch the snapshot right away.
+func (s *Store) requestIndexRefresh() {
+	select {
+	case s.indexRefreshNow <- struct{}{}:
+	default:
+		// a refresh is already requested
+	}
+}
+
+// transition is a decision of the snapshot that changed since the previous index.
+type transition struct {
+	key   Key
+	usage usage.MetricUsage
+}
+
+// buildIndex streams the decisions of the snapshot jobs into a new index.
+// Jobs whose decisions did not change since the previous index are copied
+// from it, and the previous index is returned unchanged if none did.
+func (s *Store) buildIndex(ctx context.Context) (*decisionIndex, bool, error) {
+	prev := s.index.Load()
+	etags := make(map[string]string, len(s.config.Snapshot.Jobs))
+	changed := prev == nil
+	b := newIndexBuilder(s.config.Snapshot.MaxIndexSize, s.config.Snapshot.BloomFilter)
+	// changed decisions, logged once the index is built so a failed build
+	// does not log them again on the next attempt
+	var transitions []transition
+	for _, job := range s.config.Snapshot.Jobs {
+		etag := ""
+		if prev != nil {
+			etag = prev.etags[job]
+		}
+		// metrics of the job in the snapshot, to find the unused ones of
+		// the previous index that are no longer in it
+		streamed := map[string]struct{}{}
+		result, err := s.streamJob(ctx, job, etag, func(u usage.MetricUsage) error {
+			if prev != nil {
+				streamed[u.Name] = struct{}{}
+				// compared with the overlay too, the changes pushed by the
+				// server were logged when they were received
+				if key := (Key{Job: job, Metric: u.Name}); s.Unused(key) != u.Unused {
+					transitions = append(transitions, transition{key: key, usage: u})
+				}
+			}
+			if !u.Unused {
+				return nil
+			}
+			return b.add(job, u.Name)
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to fetch the decisions of job %q: %w", job, err)
+		}
+		if result.NotModified {
+			err = prev.forEachUnused(job, func(metric string) error {
+				return b.add(job, metric)
+			})
+			if err != nil {
+				return nil, false, err
+			}
+		} else {
+			changed = true
+			if prev != nil {
+				// the unused metrics missing from the snapshot become used
+				_ = prev.forEachUnused(job, func(metric string) error {
+					_, ok := streamed[metric]
+					if key := (Key{Job: job, Metric: metric}); !ok && s.Unused(key) {
+						transitions = append(transitions, transition{key: key, usage: usage.MetricUsage{Name: metric}})
+					}
+					return nil
+				})
+			}
+		}
+		etags[job] = result.ETag
+	}
+	for _, t := range transitions {
+		s.logTransition(t.key, !t.usage.Unused, t.usage)
+	}
+	if !changed {
+		return prev, false, nil
+	}
+	idx := b.build()
+	idx.etags = etags
+	return idx, true, nil
+}
@@ -0,0 +1,114 @@
+package usagestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+func TestDecisionIndex(t *testing.T) {
+	for _, bloom := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bloom=%t", bloom), func(t *testing.T) {
+			b := newIndexBuilder(0, bloom)
+			for i := range 1000 {
+				require.NoError(t, b.add(fmt.Sprintf("job_%d", i%10), fmt.Sprintf("metric_%d", i)))
+			}
+			// duplicates are stored once
+			require.NoError(t, b.add("job_0", "metric_0"))
+			idx := b.build()
+
+			require.Equal(t, 1000, idx.len())
+			require.True(t, idx.contains("job_3", "metric_13"))
+			require.False(t, idx.contains("job_4", "metric_13"))
+			require.False(t, idx.contains("unknown", "metric_13"))
+			require.False(t, idx.contains("job_3", "unknown"))
+			require.Equal(t, bloom, idx.bloom != nil)
+		})
+	}
+}
+
+func TestDecisionIndexMaxSize(t *testing.T) {
+	b := newIndexBuilder(1024, false)
+	var err error
+	for i := 0; err == nil && i < 1000; i++ {
+		err = b.add("myJob", fmt.Sprintf("metric_%d", i))
+	}
+	require.ErrorIs(t, err, errIndexTooLarge)
+}
+
+func TestStoreIndex(t *testing.T) {
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.Snapshot.Jobs = []string{"myJob"}
+		cfg.Snapshot.BloomFilter = true
+	})
+	tel := &recordingTelemetry{}
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"a": true, "b": false}}}
+	s := NewStore(Settings{Logger: zap.NewNop(), Telemetry: tel}, cfg, f)
+	require.NoError(t, s.Start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, s.Shutdown(context.Background())) })
+
+	require.True(t, s.Unused(Key{Job: "myJob", Metric: "a"}))
+	require.False(t, s.Unused(Key{Job: "myJob", Metric: "b"}))
+	require.False(t, s.Unused(Key{Job: "otherJob", Metric: "a"}))
+	require.Zero(t, f.calls.Load())
+	require.Equal(t, s.index.Load().size, tel.indexSize)
+
+	// a failed refresh keeps the previous index
+	f.listErr = errors.New("boom")
+	s.refreshIndex()
+	require.True(t, s.Unused(Key{Job: "myJob", Metric: "a"}))
+}
+
+// unchangedJobClient reports the decisions of one job as not modified.
+type unchangedJobClient struct {
+	fakeClient
+	unchanged string
+}
+
+func (c *unchangedJobClient) StreamMetricUsage(ctx context.Context, job string, etag string, fn func(usage.MetricUsage) error) (usage.StreamResult, error) {
+	if job == c.unchanged && etag != "" {
+		return usage.StreamResult{ETag: etag, NotModified: true}, nil
+	}
+	return c.fakeClient.StreamMetricUsage(ctx, job, etag, fn)
+}
+
+func TestSnapshotIndexReusesUnchangedJobs(t *testing.T) {
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.Snapshot.Jobs = []string{"jobA", "jobB"}
+	})
+
+	client := &unchangedJobClient{
+		fakeClient: fakeClient{etag: "v1", decisions: map[string]map[string]bool{
+			"jobA": {"a1": true, "a2": true},
+			"jobB": {"b1": true},
+		}},
+		unchanged: "jobA",
+	}
+	s := newTestStore(cfg, client)
+
+	s.refreshIndex()
+	first := s.index.Load()
+	require.Equal(t, 3, first.len())
+
+	// nothing changed, the index is kept as is
+	s.refreshIndex()
+	require.Same(t, first, s.index.Load())
+
+	// jobB changed, jobA is copied from the previous index
+	client.etag = "v2"
+	client.decisions["jobA"]["a3"] = true
+	client.decisions["jobB"]["b2"] = true
+	s.refreshIndex()
+	idx := s.index.Load()
+	require.Equal(t, 4, idx.len())
+	require.True(t, idx.contains("jobA", "a2"))
+	require.False(t, idx.contains("jobA", "a3"))
+	require.True(t, idx.contains("jobB", "b2"))
+}
@@ -0,0 +1,83 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// warmup fetches the decisions of the configured jobs so the first batches
+// after a restart do not pay the server latency for every key. It blocks
+// until the decisions are fetched, the warm-up timeout elapses or ctx is
+// cancelled, and keeps fetching in the background afterwards.
+func (s *Store) warmup(ctx context.Context) {
+	if len(s.config.Warmup.Jobs) == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		start := time.Now()
+		ctx, span := s.startSpan(s.lifetime, "usage/warmup", attribute.Int(AttributeJobCount, len(s.config.Warmup.Jobs)))
+		defer span.End()
+		fetched, failed := 0, 0
+		for _, job := range s.config.Warmup.Jobs {
+			n, err := s.fetchJob(ctx, job)
+			if err != nil {
+				failed++
+				continue
+			}
+			fetched += n
+		}
+		if failed == 0 {
+			s.health.recordSnapshot(time.Now())
+		}
+		s.logger.Info("decision warm-up finished",
+			zap.Int("decisions", fetched),
+			zap.Duration("duration", time.Since(start)),
+		)
+	}()
+
+	timer := time.NewTimer(s.config.Warmup.Timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		s.logger.Warn("decision warm-up did not finish in time, continuing in the background",
+			zap.Duration("timeout", s.config.Warmup.Timeout),
+		)
+	case <-ctx.Done():
+	}
+}
+
+// fetchJob caches the decisions of every metric of the job and returns how
+// many were fetched.
+func (s *Store) fetchJob(ctx context.Context, job string) (int, error) {
+	fetched := 0
+	_, err := s.streamJob(ctx, job, "", func(u usage.MetricUsage) error {
+		s.setDecision(Key{Job: job, Metric: u.Name}, u, time.Now())
+		fetched++
+		return nil
+	})
+	if err != nil {
+		if s.lifetime.Err() == nil {
+			s.logger.Warn("failed to fetch decisions during warm-up",
+				zap.String("job", job),
+				zap.Error(err),
+			)
+			s.health.recordFailure(err)
+		}
+		return 0, err
+	}
+	return fetched, nil
+}
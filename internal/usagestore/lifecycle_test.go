@@ -0,0 +1,61 @@
+package usagestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// blockingClient blocks every lookup until its context is cancelled.
+type blockingClient struct {
+	fakeClient
+	started chan struct{}
+}
+
+func (b *blockingClient) GetMetricUsage(ctx context.Context, _ string, _ string) (usage.MetricUsage, error) {
+	close(b.started)
+	<-ctx.Done()
+	return usage.MetricUsage{}, ctx.Err()
+}
+
+func TestWarmupPrefetchesDecisions(t *testing.T) {
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.Warmup.Jobs = []string{"myJob"}
+	})
+	f := &fakeClient{decisions: map[string]map[string]bool{
+		"myJob": {"unused_metric": true, "delta.monotonic.sum": false},
+	}}
+	s := startTestStore(t, cfg, f)
+
+	entry, err := s.Lookup(context.Background(), Key{Job: "myJob", Metric: "unused_metric"})
+	require.NoError(t, err)
+	require.True(t, entry.Usage.Unused)
+	require.Len(t, s.Decisions(time.Now()), 2)
+	require.Zero(t, f.calls.Load())
+}
+
+func TestShutdownCancelsInflightLookups(t *testing.T) {
+	client := &blockingClient{started: make(chan struct{})}
+	s := newTestStore(newTestConfig(t, nil), client)
+	require.NoError(t, s.Start(context.Background(), componenttest.NewNopHost()))
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.Lookup(context.Background(), Key{Job: "myJob", Metric: "metric"})
+		errCh <- err
+	}()
+	<-client.started
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	select {
+	case err := <-errCh:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup was not cancelled on shutdown")
+	}
+}
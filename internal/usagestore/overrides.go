@@ -0,0 +1,116 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// how often the expired overrides are forgotten
+const overridePruneInterval = time.Minute
+
+// Override temporarily keeps a metric regardless of any other decision.
+// An empty job applies the override to every job.
+type Override struct {
+	Job       string    `json:"job"`
+	Metric    string    `json:"metric"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type overrides struct {
+	mu      sync.RWMutex
+	entries map[Key]Override
+}
+
+func newOverrides() *overrides {
+	return &overrides{entries: map[Key]Override{}}
+}
+
+// add sets the override of the metric and forgets the expired ones.
+func (o *overrides) add(override Override) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pruneLocked(time.Now())
+	o.entries[Key{Job: override.Job, Metric: override.Metric}] = override
+}
+
+// prune forgets the expired overrides.
+func (o *overrides) prune(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pruneLocked(now)
+}
+
+func (o *overrides) pruneLocked(now time.Time) {
+	for key, override := range o.entries {
+		if !now.Before(override.ExpiresAt) {
+			delete(o.entries, key)
+		}
+	}
+}
+
+func (o *overrides) remove(key Key) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.entries[key]
+	delete(o.entries, key)
+	return ok
+}
+
+// active returns the unexpired override for the metric, preferring a job
+// specific override over one that applies to every job.
+func (o *overrides) active(now time.Time, job string, metricName string) (Override, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if len(o.entries) == 0 {
+		return Override{}, false
+	}
+	for _, key := range []Key{{Job: job, Metric: metricName}, {Metric: metricName}} {
+		if override, ok := o.entries[key]; ok && now.Before(override.ExpiresAt) {
+			return override, true
+		}
+	}
+	return Override{}, false
+}
+
+// list returns the unexpired overrides and forgets the expired ones.
+func (o *overrides) list(now time.Time) []Override {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pruneLocked(now)
+	list := make([]Override, 0, len(o.entries))
+	for _, override := range o.entries {
+		list = append(list, override)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Job != list[j].Job {
+			return list[i].Job < list[j].Job
+		}
+		return list[i].Metric < list[j].Metric
+	})
+	return list
+}
+
+// pruneOverrides forgets the expired overrides every prune interval, so they
+// are not kept, nor persisted, until the overrides are listed.
+func (s *Store) pruneOverrides() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(overridePruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.lifetime.Done():
+				return
+			case now := <-ticker.C:
+				s.overrides.prune(now)
+			}
+		}
+	}()
+}
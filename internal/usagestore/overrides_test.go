@@ -0,0 +1,20 @@
+package usagestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverridesPruneExpired(t *testing.T) {
+	now := time.Now()
+	o := newOverrides()
+	o.add(Override{Metric: "expired_metric", ExpiresAt: now.Add(-time.Minute)})
+	o.add(Override{Metric: "expiring_metric", ExpiresAt: now.Add(time.Minute)})
+	require.NotContains(t, o.entries, Key{Metric: "expired_metric"}, "setting an override forgets the expired ones")
+	require.Contains(t, o.entries, Key{Metric: "expiring_metric"})
+
+	o.prune(now.Add(2 * time.Minute))
+	require.Empty(t, o.entries)
+}
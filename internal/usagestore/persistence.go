@@ -0,0 +1,164 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// storageKey is the key the decision snapshot is persisted under.
+const storageKey = "decisions"
+
+// loadState restores the decisions persisted by a previous run before the
+// first batch is processed, and reconciles them with the server in the
+// background.
+func (s *Store) loadState(ctx context.Context, host component.Host) error {
+	if s.config.Persistence.Storage == nil {
+		return nil
+	}
+	client, err := getStorageClient(ctx, host, *s.config.Persistence.Storage, s.kind, s.id)
+	if err != nil {
+		return err
+	}
+	s.storage = client
+
+	data, err := client.Get(ctx, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to load persisted decisions: %w", err)
+	}
+	if data != nil {
+		snapshot, err := decodeSnapshot(data)
+		if err != nil {
+			// the state is only an optimization, start from scratch
+			s.logger.Warn("ignoring persisted decisions", zap.Error(err))
+		} else {
+			keys := s.restore(snapshot, time.Now())
+			s.logger.Info("restored persisted decisions",
+				zap.Int("decisions", len(keys)),
+				zap.Time("persisted_at", snapshot.CreatedAt),
+			)
+			s.reconcile(keys)
+		}
+	}
+	s.persistPeriodically()
+	return nil
+}
+
+func getStorageClient(ctx context.Context, host component.Host, storageID component.ID, kind component.Kind, id component.ID) (storage.Client, error) {
+	ext, ok := host.GetExtensions()[storageID]
+	if !ok {
+		return nil, fmt.Errorf("storage extension %q not found", storageID)
+	}
+	storageExt, ok := ext.(storage.Extension)
+	if !ok {
+		return nil, fmt.Errorf("extension %q is not a storage extension", storageID)
+	}
+	return storageExt.GetClient(ctx, kind, id, "")
+}
+
+// reconcile refreshes the restored decisions one at a time, spread over half
+// the cache ttl, so a rolling restart does not turn into a burst of requests
+// to the server and the restored decisions are refreshed before they expire.
+func (s *Store) reconcile(keys []Key) {
+	if len(keys) == 0 {
+		return
+	}
+	interval := s.config.Cache.TTL / 2 / time.Duration(len(keys))
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx, span := s.startSpan(s.lifetime, "usage/reconcile", attribute.Int(AttributeMetricCount, len(keys)))
+		var (
+			refreshed int
+			errs      error
+		)
+		defer func() { EndSpan(span, errs) }()
+		for i, key := range keys {
+			if i > 0 {
+				select {
+				case <-s.lifetime.Done():
+					return
+				case <-time.After(interval):
+				}
+			}
+			response, err := s.getMetricUsage(ctx, key)
+			if s.lifetime.Err() != nil {
+				return
+			}
+			if err != nil {
+				errs = multierr.Append(errs, err)
+				continue
+			}
+			s.setDecision(key, response, time.Now())
+			refreshed++
+		}
+		if errs != nil {
+			s.health.recordFailure(errs)
+			s.logger.Warn("failed to reconcile some restored decisions",
+				zap.Int("refreshed", refreshed),
+				zap.Int("decisions", len(keys)),
+				zap.Error(errs),
+			)
+			return
+		}
+		s.health.recordSnapshot(time.Now())
+		s.logger.Debug("reconciled restored decisions", zap.Int("decisions", refreshed))
+	}()
+}
+
+// persistPeriodically saves the decisions every persistence interval so they
+// also survive a crash.
+func (s *Store) persistPeriodically() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.config.Persistence.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.lifetime.Done():
+				return
+			case <-ticker.C:
+				if err := s.persistState(s.lifetime); err != nil && s.lifetime.Err() == nil {
+					s.logger.Warn("failed to persist decisions", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+func (s *Store) persistState(ctx context.Context) error {
+	if s.storage == nil {
+		return nil
+	}
+	data, err := json.Marshal(s.Export(time.Now()))
+	if err != nil {
+		return err
+	}
+	return s.storage.Set(ctx, storageKey, data)
+}
+
+// closeState persists the decisions one last time and releases the storage client.
+func (s *Store) closeState(ctx context.Context) error {
+	if s.storage == nil {
+		return nil
+	}
+	var errs error
+	if err := s.persistState(ctx); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("failed to persist decisions: %w", err))
+	}
+	if err := s.storage.Close(ctx); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("failed to close storage client: %w", err))
+	}
+	return errs
+}
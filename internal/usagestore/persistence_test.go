@@ -0,0 +1,179 @@
+package usagestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// memoryStorage is a storage extension keeping the data in memory across clients.
+type memoryStorage struct {
+	component.StartFunc
+	component.ShutdownFunc
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (m *memoryStorage) GetClient(context.Context, component.Kind, component.ID, string) (storage.Client, error) {
+	return &memoryStorageClient{storage: m}, nil
+}
+
+type memoryStorageClient struct {
+	storage *memoryStorage
+}
+
+func (c *memoryStorageClient) Get(_ context.Context, key string) ([]byte, error) {
+	c.storage.mu.Lock()
+	defer c.storage.mu.Unlock()
+	return c.storage.data[key], nil
+}
+
+func (c *memoryStorageClient) Set(_ context.Context, key string, value []byte) error {
+	c.storage.mu.Lock()
+	defer c.storage.mu.Unlock()
+	c.storage.data[key] = value
+	return nil
+}
+
+func (c *memoryStorageClient) Delete(_ context.Context, key string) error {
+	c.storage.mu.Lock()
+	defer c.storage.mu.Unlock()
+	delete(c.storage.data, key)
+	return nil
+}
+
+func (c *memoryStorageClient) Batch(context.Context, ...*storage.Operation) error {
+	return nil
+}
+
+func (c *memoryStorageClient) Close(context.Context) error {
+	return nil
+}
+
+type storageHost struct {
+	component.Host
+	extensions map[component.ID]component.Component
+}
+
+func (h *storageHost) GetExtensions() map[component.ID]component.Component {
+	return h.extensions
+}
+
+func TestPersistedDecisionsSurviveRestart(t *testing.T) {
+	storageID := component.MustNewID("file_storage")
+	host := &storageHost{
+		Host:       componenttest.NewNopHost(),
+		extensions: map[component.ID]component.Component{storageID: &memoryStorage{data: map[string][]byte{}}},
+	}
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.Persistence.Storage = &storageID
+	})
+
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	first := newTestStore(cfg, f)
+	require.NoError(t, first.Start(context.Background(), host))
+	_, err := first.Lookup(context.Background(), Key{Job: "myJob", Metric: "unused_metric"})
+	require.NoError(t, err)
+	first.AddOverride(Override{Metric: "kept_metric", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, first.Shutdown(context.Background()))
+
+	// the second instance cannot reach the server but serves the persisted decisions
+	unreachable := &fakeClient{errFor: map[string]map[string]error{"myJob": {"unused_metric": context.DeadlineExceeded}}}
+	second := newTestStore(cfg, unreachable)
+	require.NoError(t, second.Start(context.Background(), host))
+	defer func() { require.NoError(t, second.Shutdown(context.Background())) }()
+
+	entry, err := second.Lookup(context.Background(), Key{Job: "myJob", Metric: "unused_metric"})
+	require.NoError(t, err)
+	require.True(t, entry.Usage.Unused)
+	_, ok := second.ActiveOverride(time.Now(), "otherJob", "kept_metric")
+	require.True(t, ok)
+}
+
+func TestReconcileSpreadsRestoredDecisions(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"first_metric": true, "second_metric": true}}}
+	s := startTestStore(t, newTestConfig(t, func(cfg *Config) {
+		// one lookup every second
+		cfg.Cache.TTL = 4 * time.Second
+	}), f)
+
+	s.reconcile([]Key{{Job: "myJob", Metric: "first_metric"}, {Job: "myJob", Metric: "second_metric"}})
+	require.Eventually(t, func() bool { return f.calls.Load() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, int64(1), f.calls.Load())
+	require.Eventually(t, func() bool {
+		_, ok := s.Cached(Key{Job: "myJob", Metric: "second_metric"}, time.Now())
+		return ok
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestSnapshotKeepsSeriesUsage(t *testing.T) {
+	cfg := newTestConfig(t, nil)
+	key := Key{Job: "myJob", Metric: "http_request_duration_seconds"}
+	scale := int32(2)
+	u := usage.MetricUsage{
+		Name: key.Metric,
+		// no quantile is queried, unlike a nil list
+		Series: &usage.SeriesUsage{Count: true, Quantiles: []float64{}, MaxScale: &scale},
+	}
+	first := newTestStore(cfg, &fakeClient{})
+	first.cache.set(key, u, time.Now())
+
+	data, err := json.Marshal(first.Export(time.Now()))
+	require.NoError(t, err)
+	snapshot, err := decodeSnapshot(data)
+	require.NoError(t, err)
+	second := newTestStore(cfg, &fakeClient{})
+	second.restore(snapshot, time.Now())
+
+	entry, ok := second.Cached(key, time.Now())
+	require.True(t, ok)
+	require.Equal(t, u, entry.Usage)
+}
+
+func TestSnapshotKeepsDecisionIndex(t *testing.T) {
+	cfg := newTestConfig(t, func(cfg *Config) { cfg.Snapshot.Jobs = []string{"myJob"} })
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"a": true, "b": false}}, etag: "v1"}
+	first := newTestStore(cfg, f)
+	first.refreshIndex()
+
+	data, err := json.Marshal(first.Export(time.Now()))
+	require.NoError(t, err)
+	snapshot, err := decodeSnapshot(data)
+	require.NoError(t, err)
+	require.Equal(t, []SnapshotIndexJob{{Job: "myJob", ETag: "v1", Unused: []string{"a"}}}, snapshot.Index)
+
+	// the restored index is served while the server is unreachable
+	unreachable := &fakeClient{listErr: errors.New("boom")}
+	second := newTestStore(cfg, unreachable)
+	second.restore(snapshot, time.Now())
+	second.refreshIndex()
+	require.True(t, second.Unused(Key{Job: "myJob", Metric: "a"}))
+	require.False(t, second.Unused(Key{Job: "myJob", Metric: "b"}))
+
+	// jobs no longer listed in the snapshot are not restored
+	third := newTestStore(newTestConfig(t, func(cfg *Config) { cfg.Snapshot.Jobs = []string{"otherJob"} }), &fakeClient{})
+	third.restore(snapshot, time.Now())
+	require.False(t, third.Unused(Key{Job: "myJob", Metric: "a"}))
+}
+
+func TestMissingStorageExtension(t *testing.T) {
+	storageID := component.MustNewID("file_storage")
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.Persistence.Storage = &storageID
+	})
+
+	s := newTestStore(cfg, &fakeClient{})
+	require.ErrorContains(t, s.Start(context.Background(), componenttest.NewNopHost()), "storage extension \"file_storage\" not found")
+	require.NoError(t, s.Shutdown(context.Background()))
+}
@@ -0,0 +1,152 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes in a way
+// older versions cannot read.
+const snapshotVersion = 1
+
+// Snapshot is the serialized decision state of a store.
+type Snapshot struct {
+	Version   int                `json:"version"`
+	CreatedAt time.Time          `json:"created_at"`
+	Decisions []SnapshotDecision `json:"decisions"`
+	Overrides []Override         `json:"overrides,omitempty"`
+	// last time decisions were fetched in bulk from the server
+	LastSnapshotAt time.Time `json:"last_snapshot_at,omitzero"`
+	// unused metrics of the snapshot jobs, so they are dropped before the
+	// first snapshot is fetched
+	Index []SnapshotIndexJob `json:"index,omitempty"`
+}
+
+// SnapshotIndexJob is the part of the decision index of a snapshot job.
+type SnapshotIndexJob struct {
+	Job    string   `json:"job"`
+	ETag   string   `json:"etag,omitempty"`
+	Unused []string `json:"unused"`
+}
+
+type SnapshotDecision struct {
+	Job       string                    `json:"job"`
+	Metric    string                    `json:"metric"`
+	Unused    bool                      `json:"unused"`
+	Summary   *usage.MetricUsageSummary `json:"summary,omitempty"`
+	Series    *usage.SeriesUsage        `json:"series,omitempty"`
+	FetchedAt time.Time                 `json:"fetched_at"`
+}
+
+// usage returns the decision of the metric.
+func (d SnapshotDecision) usage() usage.MetricUsage {
+	return usage.MetricUsage{Name: d.Metric, Unused: d.Unused, Summary: d.Summary, Series: d.Series}
+}
+
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode decision snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported decision snapshot version %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
+
+// Export captures the cached decisions, the decision index and the active
+// overrides.
+func (s *Store) Export(now time.Time) *Snapshot {
+	entries := s.cache.snapshot(now)
+	snapshot := &Snapshot{
+		Version:        snapshotVersion,
+		CreatedAt:      now,
+		Decisions:      make([]SnapshotDecision, 0, len(entries)),
+		Overrides:      s.overrides.list(now),
+		LastSnapshotAt: s.health.lastSnapshotAt(),
+	}
+	for key, entry := range entries {
+		snapshot.Decisions = append(snapshot.Decisions, SnapshotDecision{
+			Job:       key.Job,
+			Metric:    key.Metric,
+			Unused:    entry.Usage.Unused,
+			Summary:   entry.Usage.Summary,
+			Series:    entry.Usage.Series,
+			FetchedAt: entry.FetchedAt,
+		})
+	}
+	sort.Slice(snapshot.Decisions, func(i, j int) bool {
+		if snapshot.Decisions[i].Job != snapshot.Decisions[j].Job {
+			return snapshot.Decisions[i].Job < snapshot.Decisions[j].Job
+		}
+		return snapshot.Decisions[i].Metric < snapshot.Decisions[j].Metric
+	})
+	if idx := s.index.Load(); idx != nil {
+		for _, job := range s.config.Snapshot.Jobs {
+			indexed := SnapshotIndexJob{Job: job, ETag: idx.etags[job], Unused: []string{}}
+			_ = idx.forEachUnused(job, func(metric string) error {
+				indexed.Unused = append(indexed.Unused, metric)
+				return nil
+			})
+			snapshot.Index = append(snapshot.Index, indexed)
+		}
+	}
+	return snapshot
+}
+
+// restore loads the decisions and overrides of a snapshot. Restored decisions
+// are considered fresh for one cache ttl so they can be served while they are
+// reconciled with the server, and the keys are returned for that purpose.
+func (s *Store) restore(snapshot *Snapshot, now time.Time) []Key {
+	keys := make([]Key, 0, len(snapshot.Decisions))
+	for _, d := range snapshot.Decisions {
+		key := Key{Job: d.Job, Metric: d.Metric}
+		s.cache.set(key, d.usage(), now)
+		keys = append(keys, key)
+	}
+	for _, override := range snapshot.Overrides {
+		if now.Before(override.ExpiresAt) {
+			s.overrides.add(override)
+		}
+	}
+	s.restoreIndex(snapshot.Index)
+	s.health.restoreSnapshot(snapshot.LastSnapshotAt)
+	return keys
+}
+
+// restoreIndex rebuilds the decision index of the snapshot jobs that are
+// still configured. The index is replaced by the first snapshot fetched from
+// the server, which only fetches the jobs whose decisions changed.
+func (s *Store) restoreIndex(jobs []SnapshotIndexJob) {
+	if len(s.config.Snapshot.Jobs) == 0 || len(jobs) == 0 {
+		return
+	}
+	b := newIndexBuilder(s.config.Snapshot.MaxIndexSize, s.config.Snapshot.BloomFilter)
+	etags := make(map[string]string, len(jobs))
+	for _, indexed := range jobs {
+		if !slices.Contains(s.config.Snapshot.Jobs, indexed.Job) {
+			continue
+		}
+		for _, metric := range indexed.Unused {
+			if err := b.add(indexed.Job, metric); err != nil {
+				s.logger.Warn("ignoring the persisted decision index", zap.Error(err))
+				return
+			}
+		}
+		etags[indexed.Job] = indexed.ETag
+	}
+	idx := b.build()
+	idx.etags = etags
+	s.index.Store(idx)
+	s.telemetry.RecordIndexSize(s.lifetime, idx.size)
+}
@@ -0,0 +1,434 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/extension/xextension/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// Settings identify the component owning a store.
+type Settings struct {
+	// ID and Kind of the component, the persisted decisions are stored under them
+	ID   component.ID
+	Kind component.Kind
+
+	Logger *zap.Logger
+
+	// optional, measurements are discarded if nil
+	Telemetry Telemetry
+
+	// optional, calls to the server are not traced if nil
+	TracerProvider trace.TracerProvider
+
+	// the client asks a decider rather than the server, the server address
+	// is not set and is not validated
+	Decider bool
+}
+
+// StoreProvider is implemented by the components sharing their store, such
+// as the unusedmetricusage extension.
+type StoreProvider interface {
+	Store() *Store
+}
+
+// GetStore returns the store shared by the usage extension with the id.
+func GetStore(host component.Host, id component.ID) (*Store, error) {
+	ext, ok := host.GetExtensions()[id]
+	if !ok {
+		return nil, fmt.Errorf("usage extension %q not found", id)
+	}
+	provider, ok := ext.(StoreProvider)
+	if !ok {
+		return nil, fmt.Errorf("extension %q is not a usage extension", id)
+	}
+	return provider.Store(), nil
+}
+
+// Store owns the connection to the analytics server and the decisions it
+// returned: the decision cache, the decision index of the snapshot jobs,
+// the keep overrides and their persistence. It is safe for concurrent use
+// by several components.
+type Store struct {
+	config    *Config
+	client    usage.Client
+	cache     *decisionCache
+	overrides *overrides
+	health    *health
+	storage   storage.Client
+	id        component.ID
+	kind      component.Kind
+	logger    *zap.Logger
+	telemetry Telemetry
+	decider   bool
+	tracer    trace.Tracer
+
+	// coalesces concurrent lookups of the same key
+	inflight singleflight.Group
+	// bounds the requests to the server running in parallel
+	lookupSlots chan struct{}
+	// unused metrics of the snapshot jobs
+	index atomic.Pointer[decisionIndex]
+	// asks the index refresh to fetch the snapshot right away
+	indexRefreshNow chan struct{}
+	// set while every cached decision is fetched again after a watch reset
+	resyncing atomic.Bool
+	// decision changes pushed since the index was built
+	overlay *decisionOverlay
+
+	// cancelled on shutdown to abort in-flight lookups and background work
+	lifetime       context.Context
+	cancelLifetime context.CancelFunc
+	wg             sync.WaitGroup
+}
+
+// NewStore returns a store asking client for the decisions. cfg must have
+// been validated.
+func NewStore(set Settings, cfg *Config, client usage.Client) *Store {
+	s := &Store{
+		config:    cfg,
+		client:    client,
+		cache:     newDecisionCache(cfg.Cache.TTL, cfg.Cache.MaxIdle),
+		overrides: newOverrides(),
+		health:    newHealth(cfg.Health),
+		id:        set.ID,
+		kind:      set.Kind,
+		logger:    set.Logger,
+		telemetry: set.Telemetry,
+		decider:   set.Decider,
+
+		lookupSlots:     make(chan struct{}, cfg.MaxConcurrentLookups),
+		indexRefreshNow: make(chan struct{}, 1),
+		overlay:         newDecisionOverlay(cfg.Snapshot.Jobs),
+	}
+	if s.telemetry == nil {
+		s.telemetry = nopTelemetry{}
+	}
+	tp := set.TracerProvider
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+	s.tracer = tp.Tracer(tracerName)
+	s.lifetime, s.cancelLifetime = context.WithCancel(context.Background())
+	return s
+}
+
+// Start restores the persisted decisions, or the bootstrap decisions when
+// the server is unreachable, and fetches the decisions of the warm-up and
+// snapshot jobs. The component status is reported through host.
+func (s *Store) Start(ctx context.Context, host component.Host) error {
+	var addressErr error
+	if !s.decider {
+		addressErr = validateAddress(s.config.Server.Address)
+	}
+	s.health.start(host, addressErr)
+	s.watchHealth()
+	s.pruneOverrides()
+	s.evictDecisions()
+	if err := s.loadState(ctx, host); err != nil {
+		return err
+	}
+	s.loadBootstrap(ctx)
+	s.startIndexRefresh(ctx)
+	s.warmup(ctx)
+	s.startWatch()
+	return nil
+}
+
+// Shutdown aborts in-flight lookups, waits for background work to finish,
+// persists the decisions and releases the connections to the server.
+func (s *Store) Shutdown(ctx context.Context) error {
+	s.cancelLifetime()
+
+	var errs error
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = multierr.Append(errs, ctx.Err())
+	}
+
+	errs = multierr.Append(errs, s.closeState(ctx))
+	s.client.CloseIdleConnections()
+	return errs
+}
+
+// withLifetime returns a context that is also cancelled when the store shuts down.
+func (s *Store) withLifetime(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.lifetime, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Lookup returns the decision for the key, asking the server only when the
+// cached decision is missing or expired. Concurrent lookups of the same key
+// share a single request to the server.
+func (s *Store) Lookup(ctx context.Context, key Key) (Decision, error) {
+	if entry, ok := s.cache.get(key, time.Now()); ok {
+		return entry, nil
+	}
+
+	ctx, span := s.startSpan(ctx, "usage/lookup",
+		attribute.String(AttributeJob, key.Job),
+		attribute.String(AttributeMetric, key.Metric),
+		attribute.String(AttributeCache, CacheMiss),
+	)
+	// the request is traced under the lookup starting it
+	fetchCtx := trace.ContextWithSpan(s.lifetime, span)
+	result := s.inflight.DoChan(key.Job+"\x00"+key.Metric, func() (any, error) {
+		return s.fetch(fetchCtx, key)
+	})
+	select {
+	case res := <-result:
+		span.SetAttributes(attribute.Bool(AttributeCoalesced, res.Shared))
+		EndSpan(span, res.Err)
+		if res.Err != nil {
+			return Decision{}, res.Err
+		}
+		return res.Val.(Decision), nil
+	case <-ctx.Done():
+		EndSpan(span, ctx.Err())
+		return Decision{}, ctx.Err()
+	}
+}
+
+// LookupResult is the outcome of the lookup of a key of a batch.
+type LookupResult struct {
+	Decision Decision
+	Err      error
+	// the budget was exhausted before the server answered, the decision is
+	// still cached for the following batches once it does
+	TimedOut bool
+}
+
+// LookupBatch looks the keys up concurrently, at most concurrency at once,
+// and spends at most budget doing so. The results are in the order of the
+// keys.
+func (s *Store) LookupBatch(ctx context.Context, keys []Key, concurrency int, budget time.Duration) []LookupResult {
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	results := make([]LookupResult, len(keys))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i].Decision, results[i].Err = s.Lookup(ctx, key)
+		}()
+	}
+	wg.Wait()
+	for i := range results {
+		results[i].TimedOut = errors.Is(results[i].Err, context.DeadlineExceeded) && ctx.Err() != nil
+	}
+	return results
+}
+
+// fetch asks the server for the decision of the key and caches it. The
+// request is shared by every caller waiting for the key, so ctx is only bound
+// to the lifetime of the store and the server timeout.
+func (s *Store) fetch(ctx context.Context, key Key) (Decision, error) {
+	response, err := s.getMetricUsage(ctx, key)
+	if err != nil {
+		if s.lifetime.Err() == nil {
+			s.health.recordFailure(err)
+		}
+		return Decision{}, err
+	}
+	s.health.recordSuccess()
+	entry := Decision{Usage: response, FetchedAt: time.Now()}
+	s.setDecision(key, response, entry.FetchedAt)
+	return entry, nil
+}
+
+// setDecision caches the decision of the key, logging the change if it
+// replaces a different one.
+func (s *Store) setDecision(key Key, u usage.MetricUsage, now time.Time) {
+	if prev, ok := s.cache.set(key, u, now); ok {
+		s.logTransition(key, prev.Usage.Unused, u)
+	}
+}
+
+// logTransition logs the decision of a metric when it changes from used to
+// unused or back. Each change is logged once, by the caller that replaced
+// the previous decision, along with the usage that caused it.
+func (s *Store) logTransition(key Key, wasUnused bool, u usage.MetricUsage) {
+	if wasUnused == u.Unused {
+		return
+	}
+	msg := "metric became used, its data points are kept"
+	if u.Unused {
+		msg = "metric became unused, its data points are dropped"
+	}
+	fields := []zap.Field{
+		zap.String("job", key.Job),
+		zap.String("metric", key.Metric),
+		zap.String("reason", Reason(u)),
+	}
+	if u.Summary != nil {
+		fields = append(fields,
+			zap.Int("alerts", u.Summary.AlertCount),
+			zap.Int("recording_rules", u.Summary.RecordCount),
+			zap.Int("dashboards", u.Summary.DashboardCount),
+			zap.Int("queries", u.Summary.QueryCount),
+		)
+	}
+	s.logger.Info(msg, fields...)
+}
+
+// getMetricUsage asks the server for the decision of the key once one of
+// the lookup slots is available. The time waiting for a slot is not part of
+// the recorded duration.
+func (s *Store) getMetricUsage(ctx context.Context, key Key) (usage.MetricUsage, error) {
+	select {
+	case s.lookupSlots <- struct{}{}:
+	case <-ctx.Done():
+		return usage.MetricUsage{}, ctx.Err()
+	}
+	defer func() { <-s.lookupSlots }()
+	start := time.Now()
+	response, err := s.client.GetMetricUsage(ctx, key.Job, key.Metric)
+	s.telemetry.RecordBackendCall(ctx, OperationLookup, time.Since(start), err)
+	return response, err
+}
+
+// Cached returns the unexpired cached decision for the key.
+func (s *Store) Cached(key Key, now time.Time) (Decision, bool) {
+	return s.cache.get(key, now)
+}
+
+// Decisions returns a copy of the unexpired cached decisions.
+func (s *Store) Decisions(now time.Time) map[Key]Decision {
+	return s.cache.snapshot(now)
+}
+
+// Flush removes every cached decision and returns how many were removed.
+func (s *Store) Flush() int {
+	return s.cache.flush()
+}
+
+// Refresh asks the server again for every cached key and returns how many
+// decisions were refreshed.
+func (s *Store) Refresh(ctx context.Context) (int, error) {
+	return s.refreshKeys(ctx, s.cache.keys(time.Now()))
+}
+
+// refreshKeys asks the server again for the keys with a fixed pool of
+// workers using at most half the lookup slots, so the lookups of live batches
+// are not queued behind a full refresh.
+func (s *Store) refreshKeys(ctx context.Context, keys []Key) (refreshed int, errs error) {
+	ctx, cancel := s.withLifetime(ctx)
+	defer cancel()
+	ctx, span := s.startSpan(ctx, "usage/refresh", attribute.Int(AttributeMetricCount, len(keys)))
+	defer func() { EndSpan(span, errs) }()
+
+	failures := make([]error, len(keys))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(s.refreshWorkers(), len(keys)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				response, err := s.getMetricUsage(ctx, keys[i])
+				if err != nil {
+					failures[i] = err
+					continue
+				}
+				s.setDecision(keys[i], response, time.Now())
+			}
+		}()
+	}
+	for i := range keys {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range failures {
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		refreshed++
+	}
+	if errs != nil {
+		s.health.recordFailure(errs)
+	} else {
+		s.health.recordSnapshot(time.Now())
+	}
+	return refreshed, errs
+}
+
+// refreshWorkers returns how many keys a refresh looks up at once, half the
+// lookup slots so the other half is left to live batches.
+func (s *Store) refreshWorkers() int {
+	return max(1, s.config.MaxConcurrentLookups/2)
+}
+
+// SnapshotJobs returns the jobs whose decisions are fetched into the decision index.
+func (s *Store) SnapshotJobs() []string {
+	return s.config.Snapshot.Jobs
+}
+
+// Unused reports whether the decision index lists the metric as unused,
+// taking into account the changes pushed by the server since it was built.
+// Metrics of the jobs outside the index are never unused.
+func (s *Store) Unused(key Key) bool {
+	if unused, ok := s.overlay.get(key); ok {
+		return unused
+	}
+	idx := s.index.Load()
+	return idx != nil && idx.contains(key.Job, key.Metric)
+}
+
+// AddOverride keeps a metric until the override expires.
+func (s *Store) AddOverride(override Override) {
+	s.overrides.add(override)
+}
+
+// RemoveOverride removes the override of the key and reports whether there was one.
+func (s *Store) RemoveOverride(key Key) bool {
+	return s.overrides.remove(key)
+}
+
+// ActiveOverride returns the unexpired override for the metric, preferring a
+// job specific override over one that applies to every job.
+func (s *Store) ActiveOverride(now time.Time, job string, metricName string) (Override, bool) {
+	return s.overrides.active(now, job, metricName)
+}
+
+// Overrides returns the unexpired overrides.
+func (s *Store) Overrides(now time.Time) []Override {
+	return s.overrides.list(now)
+}
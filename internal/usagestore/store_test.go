@@ -0,0 +1,284 @@
+package usagestore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+type fakeClient struct {
+	// map[job][metricName] => unused
+	decisions map[string]map[string]bool
+	// optional error injection
+	errFor map[string]map[string]error
+	// optional Ping error
+	pingErr error
+	// optional ListMetricUsage and StreamMetricUsage error
+	listErr error
+	// version of the decisions returned by StreamMetricUsage
+	etag string
+	// events streamed by WatchMetricUsage, which blocks if nil
+	watch chan usage.WatchEvent
+	// number of GetMetricUsage calls
+	calls atomic.Int64
+	// number of StreamMetricUsage calls
+	streams atomic.Int64
+}
+
+func (f *fakeClient) GetMetricUsage(ctx context.Context, job string, name string) (usage.MetricUsage, error) {
+	f.calls.Add(1)
+	if jobMap, ok := f.errFor[job]; ok {
+		if err, ok2 := jobMap[name]; ok2 && err != nil {
+			return usage.MetricUsage{}, err
+		}
+	}
+	return usage.MetricUsage{Unused: f.decisions[job][name], Name: name}, nil
+}
+
+func (f *fakeClient) ListMetricUsage(ctx context.Context, job string) ([]usage.MetricUsage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	var usages []usage.MetricUsage
+	for name, unused := range f.decisions[job] {
+		usages = append(usages, usage.MetricUsage{Name: name, Unused: unused})
+	}
+	return usages, nil
+}
+
+func (f *fakeClient) StreamMetricUsage(ctx context.Context, job string, etag string, fn func(usage.MetricUsage) error) (usage.StreamResult, error) {
+	f.streams.Add(1)
+	if etag != "" && etag == f.etag {
+		return usage.StreamResult{ETag: etag, NotModified: true}, nil
+	}
+	usages, err := f.ListMetricUsage(ctx, job)
+	if err != nil {
+		return usage.StreamResult{}, err
+	}
+	for _, u := range usages {
+		if err := fn(u); err != nil {
+			return usage.StreamResult{}, err
+		}
+	}
+	return usage.StreamResult{ETag: f.etag}, nil
+}
+
+func (f *fakeClient) WatchMetricUsage(ctx context.Context, _ string, fn func(usage.WatchEvent) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-f.watch:
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (f *fakeClient) Ping(context.Context) error {
+	return f.pingErr
+}
+
+func (f *fakeClient) CloseIdleConnections() {}
+
+// recordingTelemetry keeps the last measurements of a store.
+type recordingTelemetry struct {
+	mu        sync.Mutex
+	calls     map[string]int
+	errors    map[string]int
+	indexSize int64
+}
+
+func (r *recordingTelemetry) RecordBackendCall(_ context.Context, operation string, _ time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls == nil {
+		r.calls = map[string]int{}
+		r.errors = map[string]int{}
+	}
+	r.calls[operation]++
+	if err != nil {
+		r.errors[ErrorClass(err)]++
+	}
+}
+
+func (r *recordingTelemetry) RecordIndexSize(_ context.Context, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indexSize = size
+}
+
+func newTestConfig(t *testing.T, configure func(*Config)) *Config {
+	cfg := &Config{Server: ServerConfig{Address: "http://localhost:0"}}
+	if configure != nil {
+		configure(cfg)
+	}
+	require.NoError(t, cfg.Validate())
+	return cfg
+}
+
+func newTestStore(cfg *Config, client usage.Client) *Store {
+	return NewStore(Settings{
+		ID:     component.MustNewID("unusedmetricusage"),
+		Kind:   component.KindExtension,
+		Logger: zap.NewNop(),
+	}, cfg, client)
+}
+
+// startTestStore starts a store that is shut down at the end of the test.
+func startTestStore(t *testing.T, cfg *Config, client usage.Client) *Store {
+	s := newTestStore(cfg, client)
+	require.NoError(t, s.Start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, s.Shutdown(context.Background())) })
+	return s
+}
+
+func TestStoreLookupCachesDecisions(t *testing.T) {
+	tel := &recordingTelemetry{}
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	s := NewStore(Settings{Logger: zap.NewNop(), Telemetry: tel}, newTestConfig(t, nil), f)
+
+	key := Key{Job: "myJob", Metric: "unused_metric"}
+	for range 2 {
+		entry, err := s.Lookup(context.Background(), key)
+		require.NoError(t, err)
+		require.True(t, entry.Usage.Unused)
+	}
+	require.Equal(t, int64(1), f.calls.Load())
+	require.Equal(t, map[string]int{OperationLookup: 1}, tel.calls)
+
+	refreshed, err := s.Refresh(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, refreshed)
+	require.Equal(t, map[string]int{OperationLookup: 2}, tel.calls)
+	require.Empty(t, tel.errors)
+	require.Equal(t, 1, s.Flush())
+	_, ok := s.Cached(key, time.Now())
+	require.False(t, ok)
+}
+
+func TestStoreLookupBatchWithinBudget(t *testing.T) {
+	client := &gateClient{
+		fakeClient: &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}},
+		gated:      map[string]bool{"slow_metric": true},
+		gate:       make(chan struct{}),
+	}
+	defer close(client.gate)
+	s := newTestStore(newTestConfig(t, nil), client)
+
+	results := s.LookupBatch(context.Background(), []Key{
+		{Job: "myJob", Metric: "unused_metric"},
+		{Job: "myJob", Metric: "slow_metric"},
+	}, 2, 50*time.Millisecond)
+	require.Len(t, results, 2)
+	require.NoError(t, results[0].Err)
+	require.False(t, results[0].TimedOut)
+	require.True(t, results[0].Decision.Usage.Unused)
+	require.ErrorIs(t, results[1].Err, context.DeadlineExceeded)
+	require.True(t, results[1].TimedOut)
+}
+
+func TestStoreEvictsIdleDecisions(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	s := newTestStore(newTestConfig(t, func(cfg *Config) {
+		cfg.Cache.TTL = time.Minute
+		cfg.Cache.MaxIdle = time.Hour
+	}), f)
+
+	now := time.Now()
+	idle := Key{Job: "myJob", Metric: "idle_metric"}
+	expired := Key{Job: "myJob", Metric: "expired_metric"}
+	recent := Key{Job: "myJob", Metric: "unused_metric"}
+	s.cache.set(idle, usage.MetricUsage{}, now)
+	s.cache.set(expired, usage.MetricUsage{}, now.Add(-2*time.Minute))
+	s.cache.set(recent, usage.MetricUsage{}, now)
+
+	// expired decisions are not refreshed, they are looked up again when seen
+	require.ElementsMatch(t, []Key{idle, recent}, s.cache.keys(now))
+	refreshed, err := s.Refresh(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, refreshed)
+
+	later := now.Add(59 * time.Minute)
+	_, ok := s.cache.get(recent, later)
+	require.False(t, ok)
+	require.Equal(t, 2, s.cache.evict(now.Add(time.Hour)))
+	require.Equal(t, 1, s.cache.evict(later.Add(time.Hour)))
+	require.Empty(t, s.cache.keys(later))
+}
+
+func TestStoreLogsDecisionTransitions(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"a": false}}}
+	s := NewStore(Settings{Logger: zap.New(core)}, newTestConfig(t, nil), f)
+
+	key := Key{Job: "myJob", Metric: "a"}
+	_, err := s.Lookup(context.Background(), key)
+	require.NoError(t, err)
+	_, err = s.Refresh(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, logs.Len())
+
+	// each change is logged once
+	f.decisions["myJob"]["a"] = true
+	for range 2 {
+		_, err = s.Refresh(context.Background())
+		require.NoError(t, err)
+	}
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	require.Equal(t, "metric became unused, its data points are dropped", entries[0].Message)
+	require.Equal(t, "a", entries[0].ContextMap()["metric"])
+
+	f.decisions["myJob"]["a"] = false
+	_, err = s.Refresh(context.Background())
+	require.NoError(t, err)
+	entries = logs.TakeAll()
+	require.Len(t, entries, 1)
+	require.Equal(t, "metric became used, its data points are kept", entries[0].Message)
+}
+
+func TestStoreLogsSnapshotTransitions(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"a": true, "b": false}}, etag: "1"}
+	cfg := newTestConfig(t, func(cfg *Config) { cfg.Snapshot.Jobs = []string{"myJob"} })
+	s := NewStore(Settings{Logger: zap.New(core)}, cfg, f)
+	s.refreshIndex()
+	// the first snapshot has nothing to compare with
+	require.Empty(t, logs.FilterMessageSnippet("metric became").All())
+
+	f.decisions["myJob"] = map[string]bool{"a": false, "b": true}
+	f.etag = "2"
+	s.refreshIndex()
+	require.Len(t, logs.FilterMessage("metric became used, its data points are kept").All(), 1)
+	require.Len(t, logs.FilterMessage("metric became unused, its data points are dropped").All(), 1)
+
+	f.etag = "3"
+	s.refreshIndex()
+	require.Len(t, logs.FilterMessageSnippet("metric became").All(), 2)
+
+	// a metric missing from the snapshot is no longer unused
+	logs.TakeAll()
+	f.decisions["myJob"] = map[string]bool{"a": false}
+	f.etag = "4"
+	s.refreshIndex()
+	entries := logs.FilterMessageSnippet("metric became").All()
+	require.Len(t, entries, 1)
+	require.Equal(t, "metric became used, its data points are kept", entries[0].Message)
+	require.Equal(t, "b", entries[0].ContextMap()["metric"])
+	require.False(t, s.Unused(Key{Job: "myJob", Metric: "b"}))
+}
@@ -0,0 +1,79 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// Operations of the calls to the server.
+const (
+	// the decision of a single metric
+	OperationLookup = "lookup"
+	// the decisions of every metric of a job
+	OperationStream = "stream"
+)
+
+// Error classes of the failed calls to the server.
+const (
+	ErrorClassTimeout     = "timeout"
+	ErrorClassCanceled    = "canceled"
+	ErrorClassStatusCode  = "status_code"
+	ErrorClassDecode      = "decode"
+	ErrorClassConnection  = "connection"
+	ErrorClassUnsupported = "unsupported"
+	ErrorClassOther       = "other"
+)
+
+// Telemetry records the measurements of a store in the telemetry of the
+// component owning it.
+type Telemetry interface {
+	// RecordBackendCall records a call to the server, err is nil if it succeeded.
+	RecordBackendCall(ctx context.Context, operation string, duration time.Duration, err error)
+	RecordIndexSize(ctx context.Context, size int64)
+}
+
+type nopTelemetry struct{}
+
+func (nopTelemetry) RecordBackendCall(context.Context, string, time.Duration, error) {}
+
+func (nopTelemetry) RecordIndexSize(context.Context, int64) {}
+
+// ErrorClass returns the class of an error returned by the server or a
+// lookup of the store.
+func ErrorClass(err error) string {
+	var (
+		statusErr *usage.StatusError
+		decodeErr *usage.DecodeError
+		netErr    net.Error
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.As(err, &statusErr):
+		return ErrorClassStatusCode
+	case errors.As(err, &decodeErr):
+		return ErrorClassDecode
+	case errors.Is(err, usage.ErrUnsupported):
+		return ErrorClassUnsupported
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassConnection
+	}
+	return ErrorClassOther
+}
+
+// Milliseconds returns a duration in fractional milliseconds.
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,44 @@
+package usagestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: fmt.Errorf("lookup: %w", context.DeadlineExceeded), want: ErrorClassTimeout},
+		{err: &net.OpError{Op: "dial", Err: &net.DNSError{IsTimeout: true}}, want: ErrorClassTimeout},
+		{err: context.Canceled, want: ErrorClassCanceled},
+		{err: &usage.StatusError{StatusCode: 503}, want: ErrorClassStatusCode},
+		{err: &usage.DecodeError{Err: errors.New("unexpected EOF")}, want: ErrorClassDecode},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: ErrorClassConnection},
+		{err: usage.ErrUnsupported, want: ErrorClassUnsupported},
+		{err: errors.New("boom"), want: ErrorClassOther},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, ErrorClass(tt.err), tt.err.Error())
+	}
+}
+
+func TestStoreRecordsBackendErrors(t *testing.T) {
+	tel := &recordingTelemetry{}
+	f := &fakeClient{errFor: map[string]map[string]error{"myJob": {"a": &usage.StatusError{StatusCode: 500}}}}
+	s := NewStore(Settings{Logger: zap.NewNop(), Telemetry: tel}, newTestConfig(t, nil), f)
+
+	_, err := s.Lookup(context.Background(), Key{Job: "myJob", Metric: "a"})
+	require.Error(t, err)
+	require.Equal(t, map[string]int{OperationLookup: 1}, tel.calls)
+	require.Equal(t, map[string]int{ErrorClassStatusCode: 1}, tel.errors)
+}
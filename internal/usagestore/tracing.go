@@ -0,0 +1,66 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+const tracerName = "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+// Span attributes, shared with the components tracing their own work.
+const (
+	AttributeJob         = "unusedmetric.job"
+	AttributeMetric      = "unusedmetric.metric"
+	AttributeMetricCount = "unusedmetric.metric_count"
+	AttributeJobCount    = "unusedmetric.job_count"
+	AttributeCache       = "unusedmetric.cache"
+	AttributeCoalesced   = "unusedmetric.coalesced"
+	AttributeNotModified = "unusedmetric.not_modified"
+)
+
+// Cache outcomes of a lookup.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+func (s *Store) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// streamJob streams the decisions of every metric of the job, tracing and
+// measuring the call to the server.
+func (s *Store) streamJob(ctx context.Context, job string, etag string, fn func(usage.MetricUsage) error) (usage.StreamResult, error) {
+	ctx, span := s.startSpan(ctx, "usage/stream", attribute.String(AttributeJob, job))
+	start := time.Now()
+	count := 0
+	result, err := s.client.StreamMetricUsage(ctx, job, etag, func(u usage.MetricUsage) error {
+		count++
+		return fn(u)
+	})
+	s.telemetry.RecordBackendCall(ctx, OperationStream, time.Since(start), err)
+	span.SetAttributes(
+		attribute.Int(AttributeMetricCount, count),
+		attribute.Bool(AttributeNotModified, result.NotModified),
+	)
+	EndSpan(span, err)
+	return result, err
+}
+
+// EndSpan records the error of a span, if any, and ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,48 @@
+package usagestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+func TestStoreTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.Snapshot.Jobs = []string{"myJob"}
+	})
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"a": true, "b": false}}}
+	s := NewStore(Settings{
+		Logger:         zap.NewNop(),
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	}, cfg, f)
+	require.NoError(t, s.Start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, s.Shutdown(context.Background())) })
+
+	_, err := s.Lookup(context.Background(), Key{Job: "myJob", Metric: "a"})
+	require.NoError(t, err)
+	// served from the cache, not traced
+	_, err = s.Lookup(context.Background(), Key{Job: "myJob", Metric: "a"})
+	require.NoError(t, err)
+
+	require.Len(t, recorder.Ended(), 3)
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	snapshot, stream := spans["usage/snapshot"], spans["usage/stream"]
+	require.Equal(t, snapshot.SpanContext().SpanID(), stream.Parent().SpanID())
+	require.Contains(t, snapshot.Attributes(), attribute.Int(AttributeMetricCount, 1))
+	require.Contains(t, stream.Attributes(), attribute.Int(AttributeMetricCount, 2))
+
+	lookup := spans["usage/lookup"]
+	require.Contains(t, lookup.Attributes(), attribute.String(AttributeCache, CacheMiss))
+	require.Contains(t, lookup.Attributes(), attribute.Bool(AttributeCoalesced, false))
+}
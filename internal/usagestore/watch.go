@@ -0,0 +1,161 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usagestore // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// startWatch applies the decision changes streamed by the server, so a
+// metric that becomes used flows again within seconds instead of after the
+// cache ttl or the next snapshot. The stream is reopened with an increasing
+// backoff, and every decision is fetched again once it is reconnected when
+// changes may have been missed.
+func (s *Store) startWatch() {
+	if !s.config.Watch.Enabled {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		backoff := s.config.Watch.InitialBackoff
+		lastEventID := ""
+		for attempt := 0; ; attempt++ {
+			err := s.client.WatchMetricUsage(s.lifetime, lastEventID, func(event usage.WatchEvent) error {
+				if event.Type == usage.WatchEventOpen {
+					if attempt > 0 && lastEventID == "" {
+						// the server cannot replay the changes missed while disconnected
+						s.resync()
+					}
+					return nil
+				}
+				backoff = s.config.Watch.InitialBackoff
+				if event.ID != "" {
+					lastEventID = event.ID
+				}
+				switch event.Type {
+				case usage.WatchEventUpdate:
+					s.applyWatchUpdate(event)
+				case usage.WatchEventReset:
+					s.logger.Info("decision changes were missed, fetching every decision again")
+					s.resync()
+				}
+				return nil
+			})
+			if s.lifetime.Err() != nil {
+				return
+			}
+			s.logger.Warn("decision watch disconnected, reconnecting",
+				zap.Duration("backoff", backoff),
+				zap.Error(err),
+			)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-s.lifetime.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			backoff = min(2*backoff, s.config.Watch.MaxBackoff)
+		}
+	}()
+}
+
+func (s *Store) applyWatchUpdate(event usage.WatchEvent) {
+	key := Key{Job: event.Job, Metric: event.Usage.Name}
+	now := time.Now()
+	// changes of the snapshot jobs go to the overlay, the others to the cache
+	wasUnused := s.Unused(key)
+	if s.overlay.set(key, event.Usage.Unused, now) {
+		s.logTransition(key, wasUnused, event.Usage)
+		return
+	}
+	if prev, ok := s.cache.update(key, event.Usage, now); ok {
+		s.logTransition(key, prev.Usage.Unused, event.Usage)
+	}
+}
+
+// resync fetches every decision again in the background, unless a resync is
+// already running.
+func (s *Store) resync() {
+	if len(s.config.Snapshot.Jobs) > 0 {
+		s.requestIndexRefresh()
+	}
+	if !s.resyncing.CompareAndSwap(false, true) {
+		return
+	}
+	keys := s.cache.keys(time.Now())
+	if len(keys) == 0 {
+		s.resyncing.Store(false)
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.resyncing.Store(false)
+		if _, err := s.refreshKeys(s.lifetime, keys); err != nil && s.lifetime.Err() == nil {
+			s.logger.Warn("failed to fetch every decision again", zap.Error(err))
+		}
+	}()
+}
+
+// decisionOverlay holds the decision changes of the snapshot jobs received
+// since the index was built, since the index itself is immutable.
+type decisionOverlay struct {
+	jobs map[string]struct{}
+
+	mu      sync.RWMutex
+	changes map[Key]overlayChange
+}
+
+type overlayChange struct {
+	unused     bool
+	receivedAt time.Time
+}
+
+func newDecisionOverlay(jobs []string) *decisionOverlay {
+	o := &decisionOverlay{
+		jobs:    make(map[string]struct{}, len(jobs)),
+		changes: map[Key]overlayChange{},
+	}
+	for _, job := range jobs {
+		o.jobs[job] = struct{}{}
+	}
+	return o
+}
+
+// set records the change if the job is a snapshot job and reports whether it did.
+func (o *decisionOverlay) set(key Key, unused bool, now time.Time) bool {
+	if _, ok := o.jobs[key.Job]; !ok {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.changes[key] = overlayChange{unused: unused, receivedAt: now}
+	return true
+}
+
+func (o *decisionOverlay) get(key Key) (bool, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	change, ok := o.changes[key]
+	return change.unused, ok
+}
+
+// prune removes the changes received before the snapshot started being
+// fetched, they are part of the new index.
+func (o *decisionOverlay) prune(before time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for key, change := range o.changes {
+		if change.receivedAt.Before(before) {
+			delete(o.changes, key)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package usagestore
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+func startWatchStore(t *testing.T, snapshotJobs []string, f *fakeClient) *Store {
+	cfg := newTestConfig(t, func(cfg *Config) {
+		cfg.Snapshot.Jobs = snapshotJobs
+		cfg.Watch.Enabled = true
+	})
+	return startTestStore(t, cfg, f)
+}
+
+func usedEvent(metric string) usage.WatchEvent {
+	return usage.WatchEvent{Type: usage.WatchEventUpdate, Job: "myJob", Usage: usage.MetricUsage{Name: metric}}
+}
+
+func TestWatchUpdatesCachedDecisions(t *testing.T) {
+	f := &fakeClient{
+		decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}},
+		watch:     make(chan usage.WatchEvent),
+	}
+	s := startWatchStore(t, nil, f)
+
+	key := Key{Job: "myJob", Metric: "unused_metric"}
+	entry, err := s.Lookup(context.Background(), key)
+	require.NoError(t, err)
+	require.True(t, entry.Usage.Unused)
+
+	f.watch <- usedEvent("unused_metric")
+	// metrics that are not cached are ignored
+	f.watch <- usedEvent("other_metric")
+	require.Eventually(t, func() bool {
+		entry, ok := s.Cached(key, time.Now())
+		return ok && !entry.Usage.Unused
+	}, 5*time.Second, 10*time.Millisecond)
+	_, ok := s.Cached(Key{Job: "myJob", Metric: "other_metric"}, time.Now())
+	require.False(t, ok)
+}
+
+func TestWatchUpdatesSnapshotDecisions(t *testing.T) {
+	f := &fakeClient{
+		decisions: map[string]map[string]bool{"myJob": {"a": true}},
+		watch:     make(chan usage.WatchEvent),
+	}
+	s := startWatchStore(t, []string{"myJob"}, f)
+	require.Equal(t, int64(1), f.streams.Load())
+
+	key := Key{Job: "myJob", Metric: "a"}
+	require.True(t, s.Unused(key))
+
+	f.watch <- usedEvent("a")
+	require.Eventually(t, func() bool {
+		return !s.Unused(key)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// a reset fetches the snapshot again
+	f.watch <- usage.WatchEvent{Type: usage.WatchEventReset}
+	require.Eventually(t, func() bool {
+		return f.streams.Load() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// flakyWatchClient fails to open the watch stream while the server is down.
+type flakyWatchClient struct {
+	*fakeClient
+	down     atomic.Bool
+	attempts atomic.Int64
+}
+
+func (c *flakyWatchClient) WatchMetricUsage(ctx context.Context, lastEventID string, fn func(usage.WatchEvent) error) error {
+	c.attempts.Add(1)
+	if c.down.Load() {
+		return errors.New("connection refused")
+	}
+	if err := fn(usage.WatchEvent{Type: usage.WatchEventOpen}); err != nil {
+		return err
+	}
+	return c.fakeClient.WatchMetricUsage(ctx, lastEventID, fn)
+}
+
+func TestWatchResyncsOnceReconnected(t *testing.T) {
+	client := &flakyWatchClient{fakeClient: &fakeClient{watch: make(chan usage.WatchEvent)}}
+	client.down.Store(true)
+	s := newTestStore(newTestConfig(t, func(cfg *Config) {
+		cfg.Watch.Enabled = true
+		cfg.Watch.InitialBackoff = time.Millisecond
+		cfg.Watch.MaxBackoff = time.Millisecond
+	}), client)
+	s.cache.set(Key{Job: "myJob", Metric: "a"}, usage.MetricUsage{Name: "a"}, time.Now())
+	require.NoError(t, s.Start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, s.Shutdown(context.Background())) })
+
+	// nothing is fetched again while the server is down
+	require.Eventually(t, func() bool { return client.attempts.Load() >= 5 }, 5*time.Second, time.Millisecond)
+	require.Zero(t, client.calls.Load())
+
+	client.down.Store(false)
+	require.Eventually(t, func() bool { return client.calls.Load() == 1 }, 5*time.Second, time.Millisecond)
+}
+
+func TestResyncRunsOnce(t *testing.T) {
+	client := &gateClient{
+		fakeClient: &fakeClient{},
+		gated:      map[string]bool{"a": true},
+		gate:       make(chan struct{}),
+	}
+	s := startTestStore(t, newTestConfig(t, nil), client)
+	s.cache.set(Key{Job: "myJob", Metric: "a"}, usage.MetricUsage{Name: "a"}, time.Now())
+
+	s.resync()
+	// a reset while the previous resync is running does not start another one
+	s.resync()
+	close(client.gate)
+	require.Eventually(t, func() bool { return !s.resyncing.Load() }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int64(1), client.calls.Load())
+}
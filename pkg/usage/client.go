@@ -0,0 +1,544 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usage // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client calls the analytics server (prom-analytics-proxy). Its decisions of
+// single metrics make it a Decider.
+type Client interface {
+	Decider
+	ListMetricUsage(ctx context.Context, job string) ([]MetricUsage, error)
+	// StreamMetricUsage calls fn for every metric of the job without holding
+	// the whole catalog in memory. If etag is the version of the catalog the
+	// server still has, fn is not called and the result is not modified.
+	StreamMetricUsage(ctx context.Context, job string, etag string, fn func(MetricUsage) error) (StreamResult, error)
+	// WatchMetricUsage calls fn for every decision change until the stream
+	// ends or ctx is cancelled. lastEventID asks the server to replay the
+	// changes since that event.
+	WatchMetricUsage(ctx context.Context, lastEventID string, fn func(WatchEvent) error) error
+	Ping(ctx context.Context) error
+	CloseIdleConnections()
+}
+
+// Config configures the connection to the analytics server.
+type Config struct {
+	Address   string         `mapstructure:"address"`
+	Timeout   *time.Duration `mapstructure:"timeout"`
+	TlsConfig TLSConfig      `mapstructure:"tls_config"`
+	// time a page of a bulk fetch may take to be read, without limit if zero.
+	// Its response headers are still expected within Timeout.
+	PageTimeout time.Duration `mapstructure:"page_timeout"`
+}
+
+// TLSConfig configures the TLS connection to the analytics server.
+type TLSConfig struct {
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+type client struct {
+	// shared by the client and the watch client, wrapped when requests are traced
+	transport *http.Transport
+	client    *http.Client
+	// without timeout, for long-lived streams
+	watchClient *http.Client
+	// without total timeout, for bulk fetches whose body takes longer than
+	// the timeout to read, only waiting for the response headers is limited
+	streamTransport *http.Transport
+	streamClient    *http.Client
+	config          *Config
+}
+
+// ClientOption configures a client.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	tracerProvider trace.TracerProvider
+}
+
+// WithTracerProvider traces the requests to the analytics server with the
+// tracer provider and propagates the W3C trace context to the server.
+func WithTracerProvider(tp trace.TracerProvider) ClientOption {
+	return func(o *clientOptions) {
+		o.tracerProvider = tp
+	}
+}
+
+// NewClient returns a client of the analytics server. config.Timeout must be
+// set.
+func NewClient(config *Config, opts ...ClientOption) Client {
+	var options clientOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	base := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: config.TlsConfig.InsecureSkipVerify,
+		},
+	}
+	stream := base.Clone()
+	stream.ResponseHeaderTimeout = *config.Timeout
+	wrap := func(transport *http.Transport) http.RoundTripper {
+		if options.tracerProvider == nil {
+			return transport
+		}
+		return otelhttp.NewTransport(transport,
+			otelhttp.WithTracerProvider(options.tracerProvider),
+			otelhttp.WithPropagators(propagation.TraceContext{}),
+			// the components report their own metrics
+			otelhttp.WithMeterProvider(noop.NewMeterProvider()),
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return r.Method + " " + r.URL.Path
+			}),
+		)
+	}
+	transport := wrap(base)
+	return &client{
+		transport: base,
+		config:    config,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   *config.Timeout,
+		},
+		watchClient: &http.Client{
+			Transport: transport,
+		},
+		streamTransport: stream,
+		streamClient: &http.Client{
+			Transport: wrap(stream),
+		},
+	}
+}
+
+// MetricUsageResponse is the body of a lookup response.
+type MetricUsageResponse struct {
+	Data []MetricUsage `json:"data"`
+}
+
+// MetricUsage is the decision of a metric.
+type MetricUsage struct {
+	Name    string              `json:"name"`
+	Unused  bool                `json:"unused"`
+	Summary *MetricUsageSummary `json:"summary"`
+	// nil unless the server tracks the usage of the series of the metric
+	Series *SeriesUsage `json:"series,omitempty"`
+}
+
+// MetricUsageSummary counts the references that make a metric used.
+type MetricUsageSummary struct {
+	AlertCount     int `json:"alert_count"`
+	RecordCount    int `json:"record_count"`
+	DashboardCount int `json:"dashboard_count"`
+	QueryCount     int `json:"query_count"`
+}
+
+// SeriesUsage is the usage of the series a histogram or a summary is
+// exported as.
+type SeriesUsage struct {
+	// whether the _bucket, _count and _sum series are queried
+	Buckets bool `json:"buckets"`
+	Count   bool `json:"count"`
+	Sum     bool `json:"sum"`
+	// quantiles of a summary that are queried, every quantile is if nil
+	Quantiles []float64 `json:"quantiles"`
+	// finest scale of an exponential histogram that queries need, any scale
+	// is if nil
+	MaxScale *int32 `json:"max_scale,omitempty"`
+}
+
+// /api/v1/metrics/unused?job=myJob&name=http_requests_total
+func (c *client) GetMetricUsage(ctx context.Context, job string, name string) (MetricUsage, error) {
+	query := url.Values{"job": []string{job}, "name": []string{name}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.Address+"/api/v1/metrics/unused?"+query.Encode(), nil)
+	if err != nil {
+		return MetricUsage{}, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return MetricUsage{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return MetricUsage{}, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	var response MetricUsageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return MetricUsage{}, &DecodeError{Err: err}
+	}
+
+	if len(response.Data) == 0 {
+		return MetricUsage{
+			Name:   name,
+			Unused: false,
+		}, nil
+	}
+
+	return response.Data[0], nil
+}
+
+// /api/v1/metrics/unused?job=myJob
+func (c *client) ListMetricUsage(ctx context.Context, job string) ([]MetricUsage, error) {
+	var usages []MetricUsage
+	_, err := c.StreamMetricUsage(ctx, job, "", func(usage MetricUsage) error {
+		usages = append(usages, usage)
+		return nil
+	})
+	return usages, err
+}
+
+const (
+	// the next page of a catalog is requested with the cursor of this header
+	nextCursorHeader = "X-Next-Cursor"
+	ndjsonMediaType  = "application/x-ndjson"
+)
+
+// StreamResult is the outcome of a bulk fetch of decisions.
+type StreamResult struct {
+	// version of the catalog to send on the next request
+	ETag string
+	// the catalog did not change since the version sent
+	NotModified bool
+}
+
+// /api/v1/metrics/unused?job=myJob&cursor=<cursor>
+//
+// The server may answer with newline delimited JSON, compress the response
+// with zstd or gzip, split the catalog in pages and answer 304 when the
+// catalog did not change. Servers supporting none of these are handled too.
+func (c *client) StreamMetricUsage(ctx context.Context, job string, etag string, fn func(MetricUsage) error) (StreamResult, error) {
+	var result StreamResult
+	cursor := ""
+	for page := 0; ; page++ {
+		query := url.Values{"job": []string{job}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		pageCtx, cancel := ctx, context.CancelFunc(func() {})
+		if c.config.PageTimeout > 0 {
+			pageCtx, cancel = context.WithTimeout(ctx, c.config.PageTimeout)
+		}
+		req, err := http.NewRequestWithContext(pageCtx, http.MethodGet, c.config.Address+"/api/v1/metrics/unused?"+query.Encode(), nil)
+		if err != nil {
+			cancel()
+			return result, err
+		}
+		req.Header.Set("Accept", ndjsonMediaType+", application/json;q=0.9")
+		req.Header.Set("Accept-Encoding", "zstd, gzip")
+		if page == 0 && etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		next, err := c.streamPage(req, fn)
+		cancel()
+		if errors.Is(err, errNotModified) {
+			return StreamResult{ETag: etag, NotModified: true}, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if page == 0 {
+			result.ETag = next.etag
+		}
+		if next.cursor == "" {
+			return result, nil
+		}
+		cursor = next.cursor
+	}
+}
+
+var errNotModified = errors.New("not modified")
+
+type pageInfo struct {
+	etag   string
+	cursor string
+}
+
+func (c *client) streamPage(req *http.Request, fn func(MetricUsage) error) (pageInfo, error) {
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		return pageInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return pageInfo{}, errNotModified
+	default:
+		return pageInfo{}, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := decompress(resp)
+	if err != nil {
+		return pageInfo{}, &DecodeError{Err: err}
+	}
+	defer body.Close()
+
+	// errors of fn are returned as is, any other is a decoding error
+	var fnErr error
+	visit := func(usage MetricUsage) error {
+		fnErr = fn(usage)
+		return fnErr
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == ndjsonMediaType {
+		err = decodeNDJSON(body, visit)
+	} else {
+		err = decodeJSON(body, visit)
+	}
+	if err != nil {
+		if fnErr != nil {
+			return pageInfo{}, err
+		}
+		return pageInfo{}, &DecodeError{Err: err}
+	}
+	return pageInfo{etag: resp.Header.Get("ETag"), cursor: resp.Header.Get(nextCursorHeader)}, nil
+}
+
+func decompress(resp *http.Response) (io.ReadCloser, error) {
+	switch resp.Header.Get("Content-Encoding") {
+	case "":
+		return io.NopCloser(resp.Body), nil
+	case "gzip":
+		return gzip.NewReader(resp.Body)
+	case "zstd":
+		decoder, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+// decodeNDJSON decodes one metric per line.
+func decodeNDJSON(r io.Reader, fn func(MetricUsage) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var usage MetricUsage
+		if err := json.Unmarshal(line, &usage); err != nil {
+			return err
+		}
+		if err := fn(usage); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// decodeJSON decodes the metrics of a {"data": [...]} response one by one.
+func decodeJSON(r io.Reader, fn func(MetricUsage) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if token != "data" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		token, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if token == nil {
+			// "data": null
+			continue
+		}
+		if token != json.Delim('[') {
+			return fmt.Errorf("unexpected token %v, expected [", token)
+		}
+		for dec.More() {
+			var usage MetricUsage
+			if err := dec.Decode(&usage); err != nil {
+				return err
+			}
+			if err := fn(usage); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("unexpected token %v, expected %v", token, delim)
+	}
+	return nil
+}
+
+const (
+	// the stream is connected, sent before any other event
+	WatchEventOpen = "open"
+	// a decision changed
+	WatchEventUpdate = "update"
+	// the server cannot replay the changes since the last event, every
+	// decision has to be fetched again
+	WatchEventReset = "reset"
+)
+
+// WatchEvent is a change of decisions streamed by the analytics server.
+type WatchEvent struct {
+	Type string
+	ID   string
+	// set for update events
+	Job   string
+	Usage MetricUsage
+}
+
+type watchUpdate struct {
+	Job string `json:"job"`
+	MetricUsage
+}
+
+// /api/v1/metrics/unused/watch
+//
+// The server sends Server-Sent Events: "update" events whose data is the
+// decision of a metric with its job, and "reset" events. An "open" event is
+// dispatched once the server accepted the stream.
+func (c *client) WatchMetricUsage(ctx context.Context, lastEventID string, fn func(WatchEvent) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.Address+"/api/v1/metrics/unused/watch", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := c.watchClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	if err := fn(WatchEvent{Type: WatchEventOpen}); err != nil {
+		return err
+	}
+	if err := decodeEvents(resp.Body, fn); err != nil {
+		return err
+	}
+	return errors.New("watch stream closed by the server")
+}
+
+// decodeEvents parses a Server-Sent Events stream. Comments, used as
+// keep-alives, and events of unknown types are ignored.
+func decodeEvents(r io.Reader, fn func(WatchEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var eventType, id string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := dispatchEvent(eventType, id, data.Bytes(), fn); err != nil {
+				return err
+			}
+			eventType = ""
+			data.Reset()
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "id":
+			id = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	return scanner.Err()
+}
+
+func dispatchEvent(eventType string, id string, data []byte, fn func(WatchEvent) error) error {
+	switch eventType {
+	case WatchEventReset:
+		return fn(WatchEvent{Type: WatchEventReset, ID: id})
+	case WatchEventUpdate:
+		var update watchUpdate
+		if err := json.Unmarshal(data, &update); err != nil {
+			return fmt.Errorf("invalid update event: %w", err)
+		}
+		return fn(WatchEvent{Type: WatchEventUpdate, ID: id, Job: update.Job, Usage: update.MetricUsage})
+	}
+	return nil
+}
+
+// Ping checks that the server can be reached. Any response other than a
+// server error is enough, since the root path is not part of the API.
+func (c *client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.Address+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+func (c *client) CloseIdleConnections() {
+	c.transport.CloseIdleConnections()
+	c.streamTransport.CloseIdleConnections()
+}
@@ -0,0 +1,237 @@
+package usage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...ClientOption) Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	timeout := 5 * time.Second
+	return NewClient(&Config{Address: srv.URL, Timeout: &timeout}, opts...)
+}
+
+func streamAll(t *testing.T, c Client, etag string) ([]MetricUsage, StreamResult) {
+	var usages []MetricUsage
+	result, err := c.StreamMetricUsage(context.Background(), "myJob", etag, func(usage MetricUsage) error {
+		usages = append(usages, usage)
+		return nil
+	})
+	require.NoError(t, err)
+	return usages, result
+}
+
+func TestStreamMetricUsageEncodings(t *testing.T) {
+	ndjson := []byte("{\"name\":\"a\",\"unused\":true}\n\n{\"name\":\"b\",\"unused\":false}\n")
+	gzipped := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzipped)
+	_, _ = gw.Write(ndjson)
+	require.NoError(t, gw.Close())
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	zstded := encoder.EncodeAll(ndjson, nil)
+
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+	}{
+		{name: "json", contentType: "application/json", body: []byte(`{"status":"ok","data":[{"name":"a","unused":true},{"name":"b","unused":false}]}`)},
+		{name: "ndjson", contentType: ndjsonMediaType, body: ndjson},
+		{name: "gzip", contentType: ndjsonMediaType, encoding: "gzip", body: gzipped.Bytes()},
+		{name: "zstd", contentType: ndjsonMediaType + "; charset=utf-8", encoding: "zstd", body: zstded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "myJob", r.URL.Query().Get("job"))
+				require.Contains(t, r.Header.Get("Accept-Encoding"), "zstd")
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				_, _ = w.Write(tt.body)
+			})
+			usages, _ := streamAll(t, c, "")
+			require.Equal(t, []MetricUsage{{Name: "a", Unused: true}, {Name: "b"}}, usages)
+		})
+	}
+}
+
+func TestStreamMetricUsagePagesAndETag(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", ndjsonMediaType)
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set(nextCursorHeader, "page2")
+			_, _ = w.Write([]byte(`{"name":"a","unused":true}` + "\n"))
+		case "page2":
+			_, _ = w.Write([]byte(`{"name":"b","unused":true}` + "\n"))
+		}
+	})
+
+	usages, result := streamAll(t, c, "")
+	require.Len(t, usages, 2)
+	require.Equal(t, StreamResult{ETag: `"v1"`}, result)
+
+	usages, result = streamAll(t, c, `"v1"`)
+	require.Empty(t, usages)
+	require.Equal(t, StreamResult{ETag: `"v1"`, NotModified: true}, result)
+}
+
+func TestStreamMetricUsageTimeouts(t *testing.T) {
+	// the body of the page takes longer than the timeout to read
+	slowBody := func(headerDelay time.Duration) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(headerDelay)
+			w.Header().Set("Content-Type", ndjsonMediaType)
+			_, _ = w.Write([]byte(`{"name":"a","unused":true}` + "\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"name":"b","unused":true}` + "\n"))
+		}
+	}
+	newClient := func(t *testing.T, handler http.HandlerFunc, pageTimeout time.Duration) Client {
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		timeout := 100 * time.Millisecond
+		return NewClient(&Config{Address: srv.URL, Timeout: &timeout, PageTimeout: pageTimeout})
+	}
+	stream := func(c Client) ([]MetricUsage, error) {
+		var usages []MetricUsage
+		_, err := c.StreamMetricUsage(context.Background(), "myJob", "", func(usage MetricUsage) error {
+			usages = append(usages, usage)
+			return nil
+		})
+		return usages, err
+	}
+
+	t.Run("body read after the timeout", func(t *testing.T) {
+		usages, err := stream(newClient(t, slowBody(0), time.Second))
+		require.NoError(t, err)
+		require.Len(t, usages, 2)
+	})
+
+	t.Run("response headers after the timeout", func(t *testing.T) {
+		_, err := stream(newClient(t, slowBody(200*time.Millisecond), time.Second))
+		require.Error(t, err)
+	})
+
+	t.Run("page after the page timeout", func(t *testing.T) {
+		_, err := stream(newClient(t, slowBody(0), 50*time.Millisecond))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestListMetricUsageNullData(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":null}`))
+	})
+	usages, err := c.ListMetricUsage(context.Background(), "myJob")
+	require.NoError(t, err)
+	require.Empty(t, usages)
+}
+
+func TestGetMetricUsageEscapesQuery(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "my job&x=1", r.URL.Query().Get("job"))
+		_, _ = w.Write([]byte(`{"data":[{"name":"` + r.URL.Query().Get("name") + `","unused":true}]}`))
+	})
+	u, err := c.GetMetricUsage(context.Background(), "my job&x=1", "a+b#c")
+	require.NoError(t, err)
+	require.Equal(t, MetricUsage{Name: "a+b#c", Unused: true}, u)
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("job") == "unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", ndjsonMediaType)
+		_, _ = w.Write([]byte("not json\n"))
+	})
+	ctx := context.Background()
+
+	var statusErr *StatusError
+	_, err := c.GetMetricUsage(ctx, "unavailable", "a")
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+
+	var decodeErr *DecodeError
+	_, err = c.GetMetricUsage(ctx, "myJob", "a")
+	require.ErrorAs(t, err, &decodeErr)
+	_, err = c.ListMetricUsage(ctx, "myJob")
+	require.ErrorAs(t, err, &decodeErr)
+
+	// errors of the callback are not decoding errors
+	errStop := errors.New("stop")
+	c = newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ndjsonMediaType)
+		_, _ = w.Write([]byte(`{"name":"a","unused":true}` + "\n"))
+	})
+	_, err = c.StreamMetricUsage(ctx, "myJob", "", func(MetricUsage) error { return errStop })
+	require.ErrorIs(t, err, errStop)
+	require.NotErrorAs(t, err, &decodeErr)
+}
+
+func TestClientTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	var traceparent string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte(`{"data":[{"name":"a","unused":true}]}`))
+	}, WithTracerProvider(tp))
+
+	_, err := c.GetMetricUsage(context.Background(), "myJob", "a")
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /api/v1/metrics/unused", spans[0].Name())
+	require.Contains(t, traceparent, spans[0].SpanContext().TraceID().String())
+}
+
+func TestWatchMetricUsage(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/metrics/unused/watch", r.URL.Path)
+		require.Equal(t, "41", r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(": keep-alive\n\n" +
+			"event: update\nid: 42\ndata: {\"job\":\"myJob\",\n" +
+			"data: \"name\":\"a\",\"unused\":false}\n\n" +
+			"event: unknown\ndata: {}\n\n" +
+			"event: reset\n\n"))
+	})
+
+	var events []WatchEvent
+	err := c.WatchMetricUsage(context.Background(), "41", func(event WatchEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	require.ErrorContains(t, err, "closed by the server")
+	require.Equal(t, []WatchEvent{
+		{Type: WatchEventOpen},
+		{Type: WatchEventUpdate, ID: "42", Job: "myJob", Usage: MetricUsage{Name: "a"}},
+		{Type: WatchEventReset, ID: "42"},
+	}, events)
+}
@@ -0,0 +1,56 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usage // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+
+import (
+	"context"
+	"errors"
+)
+
+// Decider decides whether a metric of a job is used. The analytics server
+// client is one; distributions can provide their own backed by a CMDB,
+// feature flags or any other source.
+//
+// A Decider implementing Client as well also supports the bulk fetches,
+// streamed changes and health checks of the analytics server.
+type Decider interface {
+	GetMetricUsage(ctx context.Context, job string, name string) (MetricUsage, error)
+}
+
+// ErrUnsupported is returned by the client of a Decider for the calls the
+// Decider does not implement.
+var ErrUnsupported = errors.New("not supported by the decider")
+
+// NewDeciderClient returns a client answering lookups of single metrics with
+// the decider. Bulk fetches and streamed changes return ErrUnsupported, and
+// the decider is always considered reachable. A decider implementing Client
+// is returned as is.
+func NewDeciderClient(decider Decider) Client {
+	if c, ok := decider.(Client); ok {
+		return c
+	}
+	return deciderClient{decider}
+}
+
+type deciderClient struct {
+	Decider
+}
+
+func (deciderClient) ListMetricUsage(context.Context, string) ([]MetricUsage, error) {
+	return nil, ErrUnsupported
+}
+
+func (deciderClient) StreamMetricUsage(context.Context, string, string, func(MetricUsage) error) (StreamResult, error) {
+	return StreamResult{}, ErrUnsupported
+}
+
+func (deciderClient) WatchMetricUsage(context.Context, string, func(WatchEvent) error) error {
+	return ErrUnsupported
+}
+
+func (deciderClient) Ping(context.Context) error {
+	return nil
+}
+
+func (deciderClient) CloseIdleConnections() {}
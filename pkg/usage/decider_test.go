@@ -0,0 +1,33 @@
+package usage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticDecider map[string]bool
+
+func (d staticDecider) GetMetricUsage(_ context.Context, _ string, name string) (MetricUsage, error) {
+	return MetricUsage{Name: name, Unused: d[name]}, nil
+}
+
+func TestDeciderClient(t *testing.T) {
+	ctx := context.Background()
+	c := NewDeciderClient(staticDecider{"unused_metric": true})
+
+	usage, err := c.GetMetricUsage(ctx, "myJob", "unused_metric")
+	require.NoError(t, err)
+	require.True(t, usage.Unused)
+
+	_, err = c.ListMetricUsage(ctx, "myJob")
+	require.ErrorIs(t, err, ErrUnsupported)
+	_, err = c.StreamMetricUsage(ctx, "myJob", "", func(MetricUsage) error { return nil })
+	require.ErrorIs(t, err, ErrUnsupported)
+	require.ErrorIs(t, c.WatchMetricUsage(ctx, "", func(WatchEvent) error { return nil }), ErrUnsupported)
+	require.NoError(t, c.Ping(ctx))
+
+	client := newTestClient(t, nil)
+	require.Same(t, client, NewDeciderClient(client))
+}
@@ -0,0 +1,8 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package usage decides whether Prometheus metrics are used. It provides the
+// client of the analytics server (prom-analytics-proxy) consulted by the
+// unusedmetric processor, the decisions it returns, and the Decider interface
+// through which distributions plug in other decision sources.
+package usage // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
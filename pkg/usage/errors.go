@@ -0,0 +1,30 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package usage // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+
+import "fmt"
+
+// StatusError is returned when the analytics server answers with an
+// unexpected status code.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// DecodeError is returned when a response of the analytics server cannot be
+// decompressed or decoded.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "invalid response: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,327 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// adminServer exposes the decisions of the processor over HTTP so operators
+// can inspect and override them without redeploying the collector.
+type adminServer struct {
+	sp     *unusedMetricProcessor
+	server *http.Server
+}
+
+func newAdminServer(sp *unusedMetricProcessor) *adminServer {
+	return &adminServer{sp: sp}
+}
+
+func (a *adminServer) start(ctx context.Context, host component.Host, settings component.TelemetrySettings) error {
+	cfg := a.sp.config.Admin
+	ln, err := cfg.ToListener(ctx)
+	if err != nil {
+		return err
+	}
+	a.server, err = cfg.ToServer(ctx, host, settings, a.handler())
+	if err != nil {
+		return errors.Join(err, ln.Close())
+	}
+	go func() {
+		if err := a.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.sp.logger.Error("admin server stopped unexpectedly", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (a *adminServer) shutdown(ctx context.Context) error {
+	if a.server == nil {
+		return nil
+	}
+	return a.server.Shutdown(ctx)
+}
+
+func (a *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /decisions", a.listDecisions)
+	mux.HandleFunc("GET /decisions/explain", a.explainDecision)
+	mux.HandleFunc("POST /cache/refresh", a.refreshCache)
+	mux.HandleFunc("POST /cache/flush", a.flushCache)
+	mux.HandleFunc("GET /overrides", a.listOverrides)
+	mux.HandleFunc("POST /overrides", a.addOverride)
+	mux.HandleFunc("DELETE /overrides", a.removeOverride)
+	mux.HandleFunc("GET /snapshot", a.exportSnapshot)
+	return mux
+}
+
+type decisionView struct {
+	Job       string                    `json:"job"`
+	Metric    string                    `json:"metric"`
+	Unused    bool                      `json:"unused"`
+	Reason    string                    `json:"reason"`
+	FetchedAt time.Time                 `json:"fetched_at"`
+	Age       string                    `json:"age"`
+	Summary   *usage.MetricUsageSummary `json:"summary,omitempty"`
+}
+
+func newDecisionView(key usagestore.Key, entry usagestore.Decision, now time.Time) decisionView {
+	return decisionView{
+		Job:       key.Job,
+		Metric:    key.Metric,
+		Unused:    entry.Usage.Unused,
+		Reason:    usagestore.Reason(entry.Usage),
+		FetchedAt: entry.FetchedAt,
+		Age:       now.Sub(entry.FetchedAt).Truncate(time.Second).String(),
+		Summary:   entry.Usage.Summary,
+	}
+}
+
+// GET /decisions?job=<job>&unused=true
+func (a *adminServer) listDecisions(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	job := r.URL.Query().Get("job")
+	onlyUnused := r.URL.Query().Get("unused") == "true"
+
+	decisions := []decisionView{}
+	for key, entry := range a.sp.store.Decisions(now) {
+		if (job != "" && key.Job != job) || (onlyUnused && !entry.Usage.Unused) {
+			continue
+		}
+		decisions = append(decisions, newDecisionView(key, entry, now))
+	}
+	sort.Slice(decisions, func(i, j int) bool {
+		if decisions[i].Job != decisions[j].Job {
+			return decisions[i].Job < decisions[j].Job
+		}
+		return decisions[i].Metric < decisions[j].Metric
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"data": decisions})
+}
+
+type exemptionView struct {
+	MetricName         string            `json:"metric_name,omitempty"`
+	MetricNameRegex    string            `json:"metric_name_regex,omitempty"`
+	Job                string            `json:"job,omitempty"`
+	JobRegex           string            `json:"job_regex,omitempty"`
+	ResourceAttributes map[string]string `json:"resource_attributes,omitempty"`
+	Owner              string            `json:"owner"`
+	Reason             string            `json:"reason"`
+	ExpiresAt          string            `json:"expires_at"`
+}
+
+type explanation struct {
+	Job    string `json:"job"`
+	Metric string `json:"metric"`
+	// keep or drop, unknown until a batch looks the decision up
+	Action string `json:"action"`
+	// override, exemption, condition, index, server, default_action or lookup
+	Source     string               `json:"source"`
+	Reason     string               `json:"reason"`
+	Override   *usagestore.Override `json:"override,omitempty"`
+	Exemptions []exemptionView      `json:"exemptions,omitempty"`
+	Decision   *decisionView        `json:"decision,omitempty"`
+	// tier of the metric and the series limit of the tier, if any
+	Tier           string `json:"tier,omitempty"`
+	MaxSeries      int    `json:"max_series,omitempty"`
+	OverflowAction string `json:"overflow_action,omitempty"`
+	// action applied when the decision cannot be resolved
+	FailureAction string `json:"failure_action,omitempty"`
+}
+
+func (exp *explanation) setVerdict(v rules.Verdict) {
+	exp.Action, exp.Source, exp.Reason = v.Action, v.Source, v.Reason
+	exp.Override = v.Override
+}
+
+// GET /decisions/explain?job=<job>&metric=<metric>
+//
+// The explanation follows the decisions of the pipeline without asking the
+// server: the decision index in snapshot mode and the cached decisions
+// otherwise. Keep and drop conditions are evaluated on a data point with
+// the job as its only attribute, and exemptions are listed when their metric
+// and job selectors match but only decide the outcome when they do not have
+// resource attribute selectors.
+func (a *adminServer) explainDecision(w http.ResponseWriter, r *http.Request) {
+	job := r.URL.Query().Get("job")
+	metricName := r.URL.Query().Get("metric")
+	if metricName == "" {
+		writeError(w, http.StatusBadRequest, errors.New("metric is required"))
+		return
+	}
+
+	sp := a.sp
+	now := time.Now()
+	exp := explanation{Job: job, Metric: metricName}
+	for _, rule := range sp.rules.Candidates(now, job, metricName) {
+		exp.Exemptions = append(exp.Exemptions, exemptionView{
+			MetricName:         rule.MetricName,
+			MetricNameRegex:    rule.MetricNameRegex,
+			Job:                rule.Job,
+			JobRegex:           rule.JobRegex,
+			ResourceAttributes: rule.ResourceAttributes,
+			Owner:              rule.Owner,
+			Reason:             rule.Reason,
+			ExpiresAt:          rule.ExpiresAt,
+		})
+	}
+
+	condition, err := sp.rules.EvalName(r.Context(), job, metricName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to evaluate the conditions: %w", err))
+		return
+	}
+	if v, ok := sp.rules.Preceding(now, sp.store, job, metricName, pcommon.NewMap(), condition); ok {
+		exp.setVerdict(v)
+		writeJSON(w, http.StatusOK, exp)
+		return
+	}
+
+	key := usagestore.Key{Job: job, Metric: metricName}
+	var u usage.MetricUsage
+	if sp.config.Lookup.Mode == lookupModeSnapshot {
+		u = usage.MetricUsage{Name: metricName, Unused: sp.store.Unused(key)}
+		exp.Source = sourceIndex
+	} else {
+		exp.FailureAction = sp.config.Lookup.FailureAction
+		entry, ok := sp.store.Cached(key, now)
+		if !ok {
+			if sp.async != nil {
+				exp.setVerdict(rules.Verdict{
+					Action: sp.config.Lookup.DefaultAction,
+					Source: sourceDefaultAction,
+					Reason: "decision is not known yet, it is looked up in the background",
+				})
+			} else {
+				exp.setVerdict(rules.Verdict{
+					Action: "unknown",
+					Source: sourceLookup,
+					Reason: "decision is not cached, the next batch asks the server for it and applies the failure action if it cannot be resolved",
+				})
+			}
+			writeJSON(w, http.StatusOK, exp)
+			return
+		}
+		u = entry.Usage
+		view := newDecisionView(key, entry, now)
+		exp.Decision = &view
+		exp.Source = sourceServer
+	}
+
+	exp.Reason = usagestore.Reason(u)
+	exp.Action = actionKeep
+	if sp.tiers != nil {
+		// the tiers keep every metric, only the series over a limit are removed
+		exp.Tier = sp.tiers.classify(u)
+		if rule, limited := sp.tiers.limit(exp.Tier); limited {
+			exp.MaxSeries, exp.OverflowAction = rule.MaxSeries, rule.OverflowAction
+		}
+	} else if u.Unused {
+		exp.Action = actionDrop
+	}
+	writeJSON(w, http.StatusOK, exp)
+}
+
+// POST /cache/refresh
+func (a *adminServer) refreshCache(w http.ResponseWriter, r *http.Request) {
+	refreshed, err := a.sp.store.Refresh(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]any{"refreshed": refreshed, "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"refreshed": refreshed})
+}
+
+// POST /cache/flush
+func (a *adminServer) flushCache(w http.ResponseWriter, _ *http.Request) {
+	flushed := a.sp.store.Flush()
+	a.sp.logger.Info("decision cache flushed through the admin API", zap.Int("entries", flushed))
+	writeJSON(w, http.StatusOK, map[string]any{"flushed": flushed})
+}
+
+// GET /overrides
+func (a *adminServer) listOverrides(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"data": a.sp.store.Overrides(time.Now())})
+}
+
+type overrideRequest struct {
+	Job    string `json:"job"`
+	Metric string `json:"metric"`
+	TTL    string `json:"ttl"`
+	Reason string `json:"reason"`
+}
+
+// POST /overrides {"job": "<job>", "metric": "<metric>", "ttl": "1h", "reason": "<reason>"}
+func (a *adminServer) addOverride(w http.ResponseWriter, r *http.Request) {
+	var req overrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Metric == "" {
+		writeError(w, http.StatusBadRequest, errors.New("metric is required"))
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("ttl must be a positive duration such as 30m"))
+		return
+	}
+
+	now := time.Now()
+	override := usagestore.Override{
+		Job:       req.Job,
+		Metric:    req.Metric,
+		Reason:    req.Reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	a.sp.store.AddOverride(override)
+	a.sp.logger.Info("keep override added through the admin API",
+		zap.String("job", override.Job),
+		zap.String("metric", override.Metric),
+		zap.String("reason", override.Reason),
+		zap.Time("expires_at", override.ExpiresAt),
+	)
+	writeJSON(w, http.StatusCreated, override)
+}
+
+// DELETE /overrides?job=<job>&metric=<metric>
+func (a *adminServer) removeOverride(w http.ResponseWriter, r *http.Request) {
+	key := usagestore.Key{Job: r.URL.Query().Get("job"), Metric: r.URL.Query().Get("metric")}
+	if !a.sp.store.RemoveOverride(key) {
+		writeError(w, http.StatusNotFound, errors.New("override not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /snapshot returns the decisions in the format of the bootstrap file.
+func (a *adminServer) exportSnapshot(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, a.sp.store.Export(time.Now()))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,146 @@
+package unusedmetricprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/golden"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/pdatatest/pmetrictest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+)
+
+func newTestAdmin(t *testing.T, f *fakeClient) (*unusedMetricProcessor, http.Handler) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, f)
+	require.NoError(t, err)
+	return sp, newAdminServer(sp).handler()
+}
+
+func doAdminRequest(t *testing.T, handler http.Handler, method string, target string, body string, out any) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if out != nil {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+	}
+	return rec.Code
+}
+
+func TestAdminExplainAndList(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	sp, handler := newTestAdmin(t, f)
+
+	// the explanation does not ask the server
+	var exp explanation
+	code := doAdminRequest(t, handler, http.MethodGet, "/decisions/explain?job=myJob&metric=unused_metric", "", &exp)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "unknown", exp.Action)
+	require.Equal(t, sourceLookup, exp.Source)
+	require.Equal(t, actionKeep, exp.FailureAction)
+	require.Zero(t, f.calls.Load())
+
+	_, err := sp.processMetrics(context.Background(), newBatch("unused_metric"))
+	require.NoError(t, err)
+	exp = explanation{}
+	doAdminRequest(t, handler, http.MethodGet, "/decisions/explain?job=myJob&metric=unused_metric", "", &exp)
+	require.Equal(t, "drop", exp.Action)
+	require.Equal(t, sourceServer, exp.Source)
+	require.NotNil(t, exp.Decision)
+
+	var list struct {
+		Data []decisionView `json:"data"`
+	}
+	code = doAdminRequest(t, handler, http.MethodGet, "/decisions?unused=true", "", &list)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, list.Data, 1)
+	require.Equal(t, "unused_metric", list.Data[0].Metric)
+
+	var refreshed map[string]int
+	code = doAdminRequest(t, handler, http.MethodPost, "/cache/refresh", "", &refreshed)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 1, refreshed["refreshed"])
+	require.Equal(t, int64(2), f.calls.Load())
+
+	var flushed map[string]int
+	code = doAdminRequest(t, handler, http.MethodPost, "/cache/flush", "", &flushed)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 1, flushed["flushed"])
+
+	doAdminRequest(t, handler, http.MethodGet, "/decisions", "", &list)
+	require.Empty(t, list.Data)
+}
+
+func TestAdminOverrides(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	sp, handler := newTestAdmin(t, f)
+
+	code := doAdminRequest(t, handler, http.MethodPost, "/overrides", `{"metric": "unused_metric", "ttl": "bogus"}`, nil)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	var override usagestore.Override
+	code = doAdminRequest(t, handler, http.MethodPost, "/overrides", `{"job": "myJob", "metric": "unused_metric", "ttl": "1h", "reason": "incident"}`, &override)
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, "incident", override.Reason)
+
+	var exp explanation
+	doAdminRequest(t, handler, http.MethodGet, "/decisions/explain?job=myJob&metric=unused_metric", "", &exp)
+	require.Equal(t, "keep", exp.Action)
+	require.Equal(t, "override", exp.Source)
+
+	dir := filepath.Join("testdata", "drop_unused_metric_if_present")
+	md, err := golden.ReadMetrics(filepath.Join(dir, "input.yaml"))
+	require.NoError(t, err)
+	md, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	expected, err := golden.ReadMetrics(filepath.Join(dir, "input.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, pmetrictest.CompareMetrics(expected, md))
+
+	code = doAdminRequest(t, handler, http.MethodDelete, "/overrides?job=myJob&metric=unused_metric", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+	_, err = sp.processMetrics(context.Background(), newBatch("unused_metric"))
+	require.NoError(t, err)
+	exp = explanation{}
+	doAdminRequest(t, handler, http.MethodGet, "/decisions/explain?job=myJob&metric=unused_metric", "", &exp)
+	require.Equal(t, "drop", exp.Action)
+}
+
+func TestAdminExplainFollowsThePipeline(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Lookup.Mode = lookupModeSnapshot
+	cfg.Snapshot.Jobs = []string{"myJob"}
+	cfg.KeepConditions.Metric = []string{`name == "kept_metric"`}
+	require.NoError(t, cfg.Validate())
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true, "kept_metric": true}}}
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, sp.start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, sp.shutdown(context.Background())) })
+	handler := newAdminServer(sp).handler()
+
+	// answered from the decision index, as the pipeline does
+	var exp explanation
+	doAdminRequest(t, handler, http.MethodGet, "/decisions/explain?job=myJob&metric=unused_metric", "", &exp)
+	require.Equal(t, "drop", exp.Action)
+	require.Equal(t, sourceIndex, exp.Source)
+	require.Empty(t, exp.FailureAction)
+
+	exp = explanation{}
+	doAdminRequest(t, handler, http.MethodGet, "/decisions/explain?job=myJob&metric=kept_metric", "", &exp)
+	require.Equal(t, "keep", exp.Action)
+	require.Equal(t, sourceCondition, exp.Source)
+	require.Zero(t, f.calls.Load())
+}
@@ -0,0 +1,123 @@
+package unusedmetricprocessor
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/golden"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/pdatatest/pmetrictest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+)
+
+func TestProcessorConditions(t *testing.T) {
+	testCases := []struct {
+		name      string
+		keep      ConditionsConfig
+		drop      ConditionsConfig
+		decisions map[string]bool
+		output    string
+		calls     int64
+	}{
+		{
+			name:      "keep metric condition overrides the backend",
+			keep:      ConditionsConfig{Metric: []string{`resource.attributes["service.name"] == "myService" and IsMatch(name, "^unused_")`}},
+			decisions: map[string]bool{"unused_metric": true},
+			output:    filepath.Join("testdata", "drop_unused_metric_if_present", "input.yaml"),
+			calls:     1,
+		},
+		{
+			name:      "keep datapoint condition overrides the backend",
+			keep:      ConditionsConfig{Datapoint: []string{`attributes["replica"] == "0"`}},
+			decisions: map[string]bool{"unused_metric": true},
+			output:    filepath.Join("testdata", "drop_unused_metric_if_present", "input.yaml"),
+			calls:     1,
+		},
+		{
+			name:   "drop condition skips the backend",
+			drop:   ConditionsConfig{Metric: []string{`name == "unused_metric"`}},
+			output: filepath.Join("testdata", "drop_unused_metric_if_present", "output.yaml"),
+			calls:  1,
+		},
+		{
+			name:   "keep condition takes precedence over drop condition",
+			keep:   ConditionsConfig{Datapoint: []string{`metric.name == "unused_metric"`}},
+			drop:   ConditionsConfig{Metric: []string{`name == "unused_metric"`}},
+			output: filepath.Join("testdata", "drop_unused_metric_if_present", "input.yaml"),
+			calls:  1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			next := &consumertest.MetricsSink{}
+
+			cfg := NewFactory().CreateDefaultConfig().(*Config)
+			cfg.Server.Address = "http://localhost:0"
+			cfg.KeepConditions = tc.keep
+			cfg.DropConditions = tc.drop
+			require.NoError(t, cfg.Validate())
+
+			f := &fakeClient{decisions: map[string]map[string]bool{"myJob": tc.decisions}}
+			processor, err := newUnusedMetricProcessor(ctx, processortest.NewNopSettings(metadata.Type), cfg, next, f)
+			require.NoError(t, err)
+
+			md, err := golden.ReadMetrics(filepath.Join("testdata", "drop_unused_metric_if_present", "input.yaml"))
+			require.NoError(t, err)
+			require.NoError(t, processor.ConsumeMetrics(ctx, md))
+
+			expected, err := golden.ReadMetrics(tc.output)
+			require.NoError(t, err)
+			require.NoError(t, pmetrictest.CompareMetrics(expected, next.AllMetrics()[0]))
+			require.Equal(t, tc.calls, f.calls.Load())
+		})
+	}
+}
+
+func TestConditionErrorMode(t *testing.T) {
+	for _, mode := range []ottl.ErrorMode{ottl.IgnoreError, ottl.PropagateError} {
+		t.Run(string(mode), func(t *testing.T) {
+			ctx := context.Background()
+			next := &consumertest.MetricsSink{}
+
+			cfg := NewFactory().CreateDefaultConfig().(*Config)
+			cfg.Server.Address = "http://localhost:0"
+			cfg.ErrorMode = mode
+			// fails to evaluate for every data point
+			cfg.DropConditions = ConditionsConfig{Datapoint: []string{`ParseJSON("{") != nil`}}
+			require.NoError(t, cfg.Validate())
+
+			f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+			processor, err := newUnusedMetricProcessor(ctx, processortest.NewNopSettings(metadata.Type), cfg, next, f)
+			require.NoError(t, err)
+
+			md, err := golden.ReadMetrics(filepath.Join("testdata", "drop_unused_metric_if_present", "input.yaml"))
+			require.NoError(t, err)
+			err = processor.ConsumeMetrics(ctx, md)
+			if mode == ottl.PropagateError {
+				// the whole batch is rejected
+				require.Error(t, err)
+				require.Empty(t, next.AllMetrics())
+				return
+			}
+			// the failed condition does not match, the decision of the server applies
+			require.NoError(t, err)
+			expected, err := golden.ReadMetrics(filepath.Join("testdata", "drop_unused_metric_if_present", "output.yaml"))
+			require.NoError(t, err)
+			require.NoError(t, pmetrictest.CompareMetrics(expected, next.AllMetrics()[0]))
+		})
+	}
+}
+
+func TestConfigInvalidCondition(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.DropConditions.Metric = []string{`name ==`}
+	require.ErrorContains(t, cfg.Validate(), "drop_conditions::metric")
+}
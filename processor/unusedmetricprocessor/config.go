@@ -5,41 +5,379 @@ package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-open
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/confighttp"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
 )
 
-var defaultTimeout = 10 * time.Second
+var (
+	defaultLookupQueueSize    = 1000
+	defaultLookupWorkers      = 4
+	defaultLogSummaryInterval = time.Minute
+	defaultLogMaxFailures     = 10
+	defaultSeriesExpiry       = 10 * time.Minute
+	defaultStalenessExpiry    = 5 * time.Minute
+	defaultResumeExpiry       = 10 * time.Minute
+)
+
+const (
+	// lookupModeSync resolves unknown decisions while the batch waits
+	lookupModeSync = "sync"
+	// lookupModeAsync resolves unknown decisions in the background
+	lookupModeAsync = "async"
+	// lookupModeSnapshot answers from an index of the unused metrics of
+	// the snapshot jobs, fetched in bulk
+	lookupModeSnapshot = "snapshot"
+
+	actionKeep = rules.ActionKeep
+	actionDrop = rules.ActionDrop
+)
 
 type Config struct {
 	// prevents unkeyed literal initialization
 	_ struct{}
 
-	Server ServerConfig `mapstructure:"server"`
+	// connection to the server and decisions, ignored when a usage extension is referenced
+	usagestore.Config `mapstructure:",squash"`
+
+	// component ID of an unusedmetricusage extension shared with other processors,
+	// which then owns the connection to the server and the decisions
+	Usage *component.ID `mapstructure:"usage"`
+
+	// how decisions missing from the cache are resolved
+	Lookup LookupConfig `mapstructure:"lookup"`
+
+	// optional local HTTP endpoint to inspect and override decisions
+	Admin *confighttp.ServerConfig `mapstructure:"admin"`
+
+	// metrics matching an exemption are always kept until the exemption expires
+	Exemptions ExemptionsConfig `mapstructure:"exemptions"`
+
+	// OTTL conditions that keep metrics without consulting the server
+	KeepConditions ConditionsConfig `mapstructure:"keep_conditions"`
+
+	// OTTL conditions that drop metrics without consulting the server
+	DropConditions ConditionsConfig `mapstructure:"drop_conditions"`
+
+	// how errors evaluating conditions are handled: ignore, silent or propagate,
+	// which rejects the whole batch
+	// default is ignore
+	ErrorMode ottl.ErrorMode `mapstructure:"error_mode"`
+
+	// how failed lookups are logged
+	Logging LoggingConfig `mapstructure:"logging"`
+
+	// classifies the metrics into usage tiers stamped as an attribute
+	// instead of dropping the unused ones
+	Tiers TiersConfig `mapstructure:"tiers"`
+
+	// removes the parts of the kept histograms and summaries whose series are
+	// not queried, when the server returns the usage of the series
+	Pruning PruningConfig `mapstructure:"pruning"`
+
+	// marks the series of the metrics that become unused as stale
+	StalenessMarkers StalenessMarkersConfig `mapstructure:"staleness_markers"`
+
+	// how the cumulative series of the metrics kept again after being dropped resume
+	Resume ResumeConfig `mapstructure:"resume"`
+
+	// set by NewFactoryWithDecider, replaces the server
+	decider usage.Decider
+}
+
+type LookupConfig struct {
+	// sync waits for the server on unknown decisions, async never blocks the batch,
+	// snapshot only answers from the decisions fetched in bulk
+	// default is sync
+	Mode string `mapstructure:"mode"`
+
+	// async mode: lookups waiting for a worker, further lookups are discarded when full
+	// default is 1000
+	QueueSize int `mapstructure:"queue_size"`
+
+	// async mode: number of background lookup workers
+	// default is 4
+	Workers int `mapstructure:"workers"`
+
+	// async mode: keep or drop metrics whose decision is not known yet
+	// default is keep
+	DefaultAction string `mapstructure:"default_action"`
+
+	// keep or drop metrics whose decision could not be resolved
+	// default is keep
+	FailureAction string `mapstructure:"failure_action"`
+
+	// sync mode: time a batch spends resolving decisions, unresolved ones use the failure action
+	// default is the server timeout
+	MaxBatchLookupTime time.Duration `mapstructure:"max_batch_lookup_time"`
+
+	// requests to the server running in parallel
+	// default is 8
+	MaxConcurrentLookups int `mapstructure:"max_concurrent_lookups"`
+}
+
+type LoggingConfig struct {
+	// period of the summaries of the failed lookups
+	// default is 1 minute
+	SummaryInterval time.Duration `mapstructure:"summary_interval"`
+
+	// failed lookups logged individually per summary interval, each (job, metric,
+	// error class) at most once, the others are only counted in the summary
+	// default is 10
+	MaxFailures int `mapstructure:"max_failures"`
+}
+
+type TiersConfig struct {
+	// stamp the usage tier of the metrics, unused metrics are then kept
+	Enabled bool `mapstructure:"enabled"`
+
+	// attribute holding the tier
+	// default is usage.tier
+	Attribute string `mapstructure:"attribute"`
+
+	// where the attribute is set: datapoint or resource
+	// default is datapoint
+	Location string `mapstructure:"location"`
+
+	// tiers of the used metrics, the first one whose usage a metric has applies
+	// default is critical (alerts), standard (dashboards, recording rules) and cold (queries)
+	Rules []TierRule `mapstructure:"rules"`
+
+	// tier of the unused metrics
+	// default is unused
+	UnusedTier string `mapstructure:"unused_tier"`
+
+	// time after which a series that is no longer received stops counting
+	// against the max_series of its tier
+	// default is 10 minutes
+	SeriesExpiry time.Duration `mapstructure:"series_expiry"`
+}
+
+type PruningConfig struct {
+	// remove the buckets of the histograms whose _bucket series are not
+	// queried while their _count or _sum series are
+	Histograms bool `mapstructure:"histograms"`
+
+	// remove the quantiles of the summaries that are not queried
+	Summaries bool `mapstructure:"summaries"`
+
+	// lower the scale of the exponential histograms to the finest scale queries need
+	ExponentialHistograms bool `mapstructure:"exponential_histograms"`
 }
 
-type ServerConfig struct {
-	// address of the server to connect to
-	Address string `mapstructure:"address"`
+type StalenessMarkersConfig struct {
+	// emit a staleness marker for every series kept by the previous batches
+	// when its metric becomes unused
+	Enabled bool `mapstructure:"enabled"`
+
+	// time after which a series that is no longer received is forgotten,
+	// and not marked stale
+	// default is 5 minutes, the lookback of Prometheus
+	Expiry time.Duration `mapstructure:"expiry"`
+}
 
-	// timeout for the client to connect to the server
-	// default is 10 seconds
-	Timeout *time.Duration `mapstructure:"timeout"`
+type ResumeConfig struct {
+	// none passes the first point of a resumed series unchanged, reset reports
+	// it as a reset of the series and suppress drops it, the following points
+	// of both start at its time until the producer resets the series
+	// default is none
+	Mode string `mapstructure:"mode"`
 
-	// tls configuration
-	TlsConfig TLSConfig `mapstructure:"tls_config"`
+	// time after which a dropped metric or a resumed series that is no longer
+	// received is forgotten
+	// default is 10 minutes
+	Expiry time.Duration `mapstructure:"expiry"`
 }
 
-type TLSConfig struct {
-	// if true, the server's certificate will not be verified
-	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+type TierRule struct {
+	// tier stamped on the matching metrics
+	Name string `mapstructure:"name"`
+
+	// usages putting a metric in the tier: alerts, recording_rules, dashboards or queries
+	UsedBy []string `mapstructure:"used_by"`
+
+	// active series per job and metric of the tier, unlimited if 0
+	MaxSeries int `mapstructure:"max_series"`
+
+	// drop or aggregate the new series over max_series into an overflow series,
+	// the cumulative sums and histograms are never aggregated
+	// default is aggregate
+	OverflowAction string `mapstructure:"overflow_action"`
 }
 
+type (
+	ServerConfig      = usagestore.ServerConfig
+	TLSConfig         = usagestore.TLSConfig
+	CacheConfig       = usagestore.CacheConfig
+	SnapshotConfig    = usagestore.SnapshotConfig
+	WatchConfig       = usagestore.WatchConfig
+	WarmupConfig      = usagestore.WarmupConfig
+	PersistenceConfig = usagestore.PersistenceConfig
+	HealthConfig      = usagestore.HealthConfig
+	ExemptionsConfig  = rules.ExemptionsConfig
+	ExemptionRule     = rules.ExemptionRule
+	ConditionsConfig  = rules.ConditionsConfig
+)
+
 func (c *Config) Validate() error {
-	if c.Server.Address == "" {
+	if c.decider != nil {
+		if c.Usage != nil {
+			return errors.New("a usage extension cannot be referenced when the processor uses a custom decider")
+		}
+		if c.Server.Address != "" {
+			return errors.New("server address must not be set when the processor uses a custom decider")
+		}
+		// a decider that only answers lookups of single metrics cannot
+		// serve the features fetching decisions in bulk or streaming them
+		if _, ok := c.decider.(usage.Client); !ok {
+			switch {
+			case c.Lookup.Mode == lookupModeSnapshot:
+				return errors.New("snapshot lookup mode requires a custom decider implementing usage.Client")
+			case len(c.Warmup.Jobs) > 0:
+				return errors.New("warmup jobs require a custom decider implementing usage.Client")
+			case c.Watch.Enabled:
+				return errors.New("watch requires a custom decider implementing usage.Client")
+			case c.BootstrapFile != "":
+				return errors.New("bootstrap_file requires a custom decider implementing usage.Client")
+			}
+		}
+	} else if c.Usage == nil && c.Server.Address == "" {
 		return errors.New("server address is required")
 	}
-	if c.Server.Timeout == nil {
-		c.Server.Timeout = &defaultTimeout
+	if c.Usage != nil && c.Server.Address != "" {
+		return errors.New("server address must not be set when a usage extension is referenced")
+	}
+	switch c.Lookup.Mode {
+	case "":
+		c.Lookup.Mode = lookupModeSync
+	case lookupModeSync, lookupModeAsync:
+	case lookupModeSnapshot:
+		if c.Usage == nil && len(c.Snapshot.Jobs) == 0 {
+			return errors.New("snapshot jobs are required in snapshot lookup mode")
+		}
+	default:
+		return fmt.Errorf("lookup mode must be %q, %q or %q, got %q", lookupModeSync, lookupModeAsync, lookupModeSnapshot, c.Lookup.Mode)
+	}
+	if c.Lookup.QueueSize <= 0 {
+		c.Lookup.QueueSize = defaultLookupQueueSize
+	}
+	if c.Lookup.Workers <= 0 {
+		c.Lookup.Workers = defaultLookupWorkers
+	}
+	if err := validateAction(&c.Lookup.DefaultAction); err != nil {
+		return fmt.Errorf("lookup default_action %w", err)
+	}
+	if err := validateAction(&c.Lookup.FailureAction); err != nil {
+		return fmt.Errorf("lookup failure_action %w", err)
+	}
+	c.Config.MaxConcurrentLookups = c.Lookup.MaxConcurrentLookups
+	if err := c.Config.Validate(); err != nil {
+		return err
+	}
+	c.Lookup.MaxConcurrentLookups = c.Config.MaxConcurrentLookups
+	if c.Lookup.MaxBatchLookupTime <= 0 {
+		c.Lookup.MaxBatchLookupTime = *c.Server.Timeout
+	}
+	if c.Admin != nil && c.Admin.Endpoint == "" {
+		return errors.New("admin endpoint is required when the admin API is enabled")
+	}
+	if c.Logging.SummaryInterval <= 0 {
+		c.Logging.SummaryInterval = defaultLogSummaryInterval
+	}
+	if c.Logging.MaxFailures <= 0 {
+		c.Logging.MaxFailures = defaultLogMaxFailures
+	}
+	if c.StalenessMarkers.Expiry <= 0 {
+		c.StalenessMarkers.Expiry = defaultStalenessExpiry
+	}
+	switch c.Resume.Mode {
+	case "":
+		c.Resume.Mode = resumeModeNone
+	case resumeModeNone, resumeModeReset, resumeModeSuppress:
+	default:
+		return fmt.Errorf("resume mode must be %q, %q or %q, got %q", resumeModeNone, resumeModeReset, resumeModeSuppress, c.Resume.Mode)
+	}
+	if c.Resume.Expiry <= 0 {
+		c.Resume.Expiry = defaultResumeExpiry
+	}
+	if err := c.Tiers.validate(c.Lookup.Mode); err != nil {
+		return err
+	}
+	rulesConfig := c.rulesConfig()
+	if err := rulesConfig.Validate(); err != nil {
+		return err
+	}
+	c.Exemptions, c.ErrorMode = rulesConfig.Exemptions, rulesConfig.ErrorMode
+	return nil
+}
+
+// rulesConfig returns the rules configured at the top level.
+func (c *Config) rulesConfig() rules.Config {
+	return rules.Config{
+		Exemptions:     c.Exemptions,
+		KeepConditions: c.KeepConditions,
+		DropConditions: c.DropConditions,
+		ErrorMode:      c.ErrorMode,
+	}
+}
+
+// validateAction defaults an empty action to keep.
+func validateAction(action *string) error {
+	switch *action {
+	case "":
+		*action = actionKeep
+	case actionKeep, actionDrop:
+	default:
+		return fmt.Errorf("must be %q or %q, got %q", actionKeep, actionDrop, *action)
 	}
 	return nil
 }
+
+// validate defaults the tiers, if enabled.
+func (c *TiersConfig) validate(lookupMode string) error {
+	if !c.Enabled {
+		return nil
+	}
+	if lookupMode == lookupModeSnapshot {
+		return errTiersSnapshotMode
+	}
+	if c.Attribute == "" {
+		c.Attribute = defaultTierAttribute
+	}
+	switch c.Location {
+	case "":
+		c.Location = tierLocationDatapoint
+	case tierLocationDatapoint, tierLocationResource:
+	default:
+		return fmt.Errorf("tiers location must be %q or %q, got %q", tierLocationDatapoint, tierLocationResource, c.Location)
+	}
+	if c.Rules == nil {
+		c.Rules = slices.Clone(defaultTierRules)
+	}
+	if c.UnusedTier == "" {
+		c.UnusedTier = defaultUnusedTier
+	}
+	if c.SeriesExpiry <= 0 {
+		c.SeriesExpiry = defaultSeriesExpiry
+	}
+	for i := range c.Rules {
+		switch c.Rules[i].OverflowAction {
+		case "":
+			c.Rules[i].OverflowAction = overflowActionAggregate
+		case overflowActionDrop, overflowActionAggregate:
+		default:
+			return fmt.Errorf("tiers::rules[%d]: overflow_action must be %q or %q, got %q",
+				i, overflowActionDrop, overflowActionAggregate, c.Rules[i].OverflowAction)
+		}
+	}
+	_, err := newTiers(*c)
+	return err
+}
@@ -10,8 +10,8 @@ import (
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/processor"
 
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
 	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
-	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/server"
 )
 
 // NewFactory returns a new factory for the Span processor.
@@ -22,6 +22,19 @@ func NewFactory() processor.Factory {
 		processor.WithMetrics(createMetricsProcessor, metadata.MetricsStability))
 }
 
+// NewFactoryWithDecider returns a factory whose processors ask the decider
+// whether metrics are used instead of the analytics server, so distributions
+// can plug in their own decision source. The server address must not be set
+// and no usage extension can be referenced.
+func NewFactoryWithDecider(decider usage.Decider) processor.Factory {
+	return processor.NewFactory(
+		metadata.Type,
+		func() component.Config {
+			return &Config{decider: decider}
+		},
+		processor.WithMetrics(createMetricsProcessor, metadata.MetricsStability))
+}
+
 func createDefaultConfig() component.Config {
 	return &Config{}
 }
@@ -39,13 +52,14 @@ func createMetricsProcessor(
 		return nil, err
 	}
 
-	client := server.NewClient(&server.Config{
-		Address: cfg.Server.Address,
-		Timeout: cfg.Server.Timeout,
-		TlsConfig: server.TLSConfig{
-			InsecureSkipVerify: cfg.Server.TlsConfig.InsecureSkipVerify,
-		},
-	})
+	// the usage extension owns the client when one is referenced
+	var client usage.Client
+	switch {
+	case cfg.decider != nil:
+		client = usage.NewDeciderClient(cfg.decider)
+	case cfg.Usage == nil:
+		client = usage.NewClient(cfg.ClientConfig(), usage.WithTracerProvider(params.TracerProvider))
+	}
 
 	unusedMetricProcessor, err := newUnusedMetricProcessor(ctx,
 		params,
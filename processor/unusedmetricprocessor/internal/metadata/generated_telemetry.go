@@ -23,14 +23,24 @@ func Tracer(settings component.TelemetrySettings) trace.Tracer {
 // TelemetryBuilder provides an interface for components to report telemetry
 // as defined in metadata and user config.
 type TelemetryBuilder struct {
-	meter                                         metric.Meter
-	mu                                            sync.Mutex
-	registrations                                 []metric.Registration
-	OtelcolProcessorUnusedmetricBackendDuration   metric.Int64Histogram
-	OtelcolProcessorUnusedmetricDropped           metric.Int64Counter
-	OtelcolProcessorUnusedmetricDroppedDatapoints metric.Int64UpDownCounter
-	OtelcolProcessorUnusedmetricError             metric.Int64Counter
-	OtelcolProcessorUnusedmetricKept              metric.Int64Counter
+	meter                                           metric.Meter
+	mu                                              sync.Mutex
+	registrations                                   []metric.Registration
+	OtelcolProcessorUnusedmetricBackendDuration     metric.Float64Histogram
+	OtelcolProcessorUnusedmetricBatchDroppedSeries  metric.Int64Counter
+	OtelcolProcessorUnusedmetricBatchLookupDuration metric.Float64Histogram
+	OtelcolProcessorUnusedmetricBytesSaved          metric.Int64Counter
+	OtelcolProcessorUnusedmetricDropped             metric.Int64Counter
+	OtelcolProcessorUnusedmetricDroppedDatapoints   metric.Int64Counter
+	OtelcolProcessorUnusedmetricError               metric.Int64Counter
+	OtelcolProcessorUnusedmetricIndexSize           metric.Int64Gauge
+	OtelcolProcessorUnusedmetricKept                metric.Int64Counter
+	OtelcolProcessorUnusedmetricKeptDatapoints      metric.Int64Counter
+	OtelcolProcessorUnusedmetricLookupQueueDropped  metric.Int64Counter
+	OtelcolProcessorUnusedmetricOverflowDatapoints  metric.Int64Counter
+	OtelcolProcessorUnusedmetricPrunedDatapoints    metric.Int64Counter
+	OtelcolProcessorUnusedmetricResumedSeries       metric.Int64Counter
+	OtelcolProcessorUnusedmetricStalenessMarkers    metric.Int64Counter
 }
 
 // TelemetryBuilderOption applies changes to default builder.
@@ -62,35 +72,97 @@ func NewTelemetryBuilder(settings component.TelemetrySettings, options ...Teleme
 	}
 	builder.meter = Meter(settings)
 	var err, errs error
-	builder.OtelcolProcessorUnusedmetricBackendDuration, err = builder.meter.Int64Histogram(
+	builder.OtelcolProcessorUnusedmetricBackendDuration, err = builder.meter.Float64Histogram(
 		"otelcol_otelcol_processor_unusedmetric_backend_duration",
-		metric.WithDescription("The duration of the backend call to the unusedmetric backend"),
-		metric.WithUnit("s"),
+		metric.WithDescription("The duration of the calls to the analytics server, the error_class attribute is set on failed calls"),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries([]float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}...),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricBatchDroppedSeries, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_batch_dropped_series",
+		metric.WithDescription("The number of distinct series dropped by the unusedmetric processor in each batch, summed over the batches so a series dropped in several batches is counted once per batch"),
+		metric.WithUnit("{series}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricBatchLookupDuration, err = builder.meter.Float64Histogram(
+		"otelcol_otelcol_processor_unusedmetric_batch_lookup_duration",
+		metric.WithDescription("The time a batch waited for the decisions missing from the cache in sync lookup mode"),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries([]float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}...),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricBytesSaved, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_bytes_saved",
+		metric.WithDescription("The estimated size of the data points dropped by the unusedmetric processor"),
+		metric.WithUnit("By"),
 	)
 	errs = errors.Join(errs, err)
 	builder.OtelcolProcessorUnusedmetricDropped, err = builder.meter.Int64Counter(
 		"otelcol_otelcol_processor_unusedmetric_dropped",
-		metric.WithDescription("The number of metrics dropped by the unusedmetric processor"),
+		metric.WithDescription("The number of metrics dropped by the unusedmetric processor, a metric counts once per job when all its data points of the job are dropped"),
 		metric.WithUnit("{metrics}"),
 	)
 	errs = errors.Join(errs, err)
-	builder.OtelcolProcessorUnusedmetricDroppedDatapoints, err = builder.meter.Int64UpDownCounter(
+	builder.OtelcolProcessorUnusedmetricDroppedDatapoints, err = builder.meter.Int64Counter(
 		"otelcol_otelcol_processor_unusedmetric_dropped_datapoints",
-		metric.WithDescription("The number of datapoints dropped by the unusedmetric processor"),
+		metric.WithDescription("The number of data points dropped by the unusedmetric processor"),
 		metric.WithUnit("{datapoints}"),
 	)
 	errs = errors.Join(errs, err)
 	builder.OtelcolProcessorUnusedmetricError, err = builder.meter.Int64Counter(
 		"otelcol_otelcol_processor_unusedmetric_error",
-		metric.WithDescription("The number of errors returned by the unusedmetric processor"),
+		metric.WithDescription("The number of decisions the unusedmetric processor could not resolve"),
 		metric.WithUnit("{errors}"),
 	)
 	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricIndexSize, err = builder.meter.Int64Gauge(
+		"otelcol_otelcol_processor_unusedmetric_index_size",
+		metric.WithDescription("The estimated size of the decision index in snapshot lookup mode"),
+		metric.WithUnit("By"),
+	)
+	errs = errors.Join(errs, err)
 	builder.OtelcolProcessorUnusedmetricKept, err = builder.meter.Int64Counter(
 		"otelcol_otelcol_processor_unusedmetric_kept",
-		metric.WithDescription("The number of metrics kept by the unusedmetric processor"),
+		metric.WithDescription("The number of metrics kept by the unusedmetric processor, a metric counts once per job when any of its data points of the job is kept"),
 		metric.WithUnit("{metrics}"),
 	)
 	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricKeptDatapoints, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_kept_datapoints",
+		metric.WithDescription("The number of data points kept by the unusedmetric processor"),
+		metric.WithUnit("{datapoints}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricLookupQueueDropped, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_lookup_queue_dropped",
+		metric.WithDescription("The number of lookups not enqueued because the async lookup queue was full"),
+		metric.WithUnit("{lookups}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricOverflowDatapoints, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_overflow_datapoints",
+		metric.WithDescription("The number of data points of new series over the series limit of their tier"),
+		metric.WithUnit("{datapoints}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricPrunedDatapoints, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_pruned_datapoints",
+		metric.WithDescription("The number of kept data points whose buckets or quantiles that are not queried were removed"),
+		metric.WithUnit("{datapoints}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricResumedSeries, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_resumed_series",
+		metric.WithDescription("The number of cumulative series whose first point after their metric was kept again was reset or suppressed"),
+		metric.WithUnit("{series}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.OtelcolProcessorUnusedmetricStalenessMarkers, err = builder.meter.Int64Counter(
+		"otelcol_otelcol_processor_unusedmetric_staleness_markers",
+		metric.WithDescription("The number of staleness markers emitted for the series of the metrics that became unused"),
+		metric.WithUnit("{datapoints}"),
+	)
+	errs = errors.Join(errs, err)
 	return &builder, errs
 }
@@ -21,12 +21,12 @@ func NewSettings(tt *componenttest.Telemetry) processor.Settings {
 	return set
 }
 
-func AssertEqualOtelcolProcessorUnusedmetricBackendDuration(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.HistogramDataPoint[int64], opts ...metricdatatest.Option) {
+func AssertEqualOtelcolProcessorUnusedmetricBackendDuration(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.HistogramDataPoint[float64], opts ...metricdatatest.Option) {
 	want := metricdata.Metrics{
 		Name:        "otelcol_otelcol_processor_unusedmetric_backend_duration",
-		Description: "The duration of the backend call to the unusedmetric backend",
-		Unit:        "s",
-		Data: metricdata.Histogram[int64]{
+		Description: "The duration of the calls to the analytics server, the error_class attribute is set on failed calls",
+		Unit:        "ms",
+		Data: metricdata.Histogram[float64]{
 			Temporality: metricdata.CumulativeTemporality,
 			DataPoints:  dps,
 		},
@@ -36,10 +36,57 @@ func AssertEqualOtelcolProcessorUnusedmetricBackendDuration(t *testing.T, tt *co
 	metricdatatest.AssertEqual(t, want, got, opts...)
 }
 
+func AssertEqualOtelcolProcessorUnusedmetricBatchDroppedSeries(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_batch_dropped_series",
+		Description: "The number of distinct series dropped by the unusedmetric processor in each batch, summed over the batches so a series dropped in several batches is counted once per batch",
+		Unit:        "{series}",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_batch_dropped_series")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
+func AssertEqualOtelcolProcessorUnusedmetricBatchLookupDuration(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.HistogramDataPoint[float64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_batch_lookup_duration",
+		Description: "The time a batch waited for the decisions missing from the cache in sync lookup mode",
+		Unit:        "ms",
+		Data: metricdata.Histogram[float64]{
+			Temporality: metricdata.CumulativeTemporality,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_batch_lookup_duration")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
+func AssertEqualOtelcolProcessorUnusedmetricBytesSaved(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_bytes_saved",
+		Description: "The estimated size of the data points dropped by the unusedmetric processor",
+		Unit:        "By",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_bytes_saved")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
 func AssertEqualOtelcolProcessorUnusedmetricDropped(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
 	want := metricdata.Metrics{
 		Name:        "otelcol_otelcol_processor_unusedmetric_dropped",
-		Description: "The number of metrics dropped by the unusedmetric processor",
+		Description: "The number of metrics dropped by the unusedmetric processor, a metric counts once per job when all its data points of the job are dropped",
 		Unit:        "{metrics}",
 		Data: metricdata.Sum[int64]{
 			Temporality: metricdata.CumulativeTemporality,
@@ -55,11 +102,11 @@ func AssertEqualOtelcolProcessorUnusedmetricDropped(t *testing.T, tt *componentt
 func AssertEqualOtelcolProcessorUnusedmetricDroppedDatapoints(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
 	want := metricdata.Metrics{
 		Name:        "otelcol_otelcol_processor_unusedmetric_dropped_datapoints",
-		Description: "The number of datapoints dropped by the unusedmetric processor",
+		Description: "The number of data points dropped by the unusedmetric processor",
 		Unit:        "{datapoints}",
 		Data: metricdata.Sum[int64]{
 			Temporality: metricdata.CumulativeTemporality,
-			IsMonotonic: false,
+			IsMonotonic: true,
 			DataPoints:  dps,
 		},
 	}
@@ -71,7 +118,7 @@ func AssertEqualOtelcolProcessorUnusedmetricDroppedDatapoints(t *testing.T, tt *
 func AssertEqualOtelcolProcessorUnusedmetricError(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
 	want := metricdata.Metrics{
 		Name:        "otelcol_otelcol_processor_unusedmetric_error",
-		Description: "The number of errors returned by the unusedmetric processor",
+		Description: "The number of decisions the unusedmetric processor could not resolve",
 		Unit:        "{errors}",
 		Data: metricdata.Sum[int64]{
 			Temporality: metricdata.CumulativeTemporality,
@@ -84,10 +131,24 @@ func AssertEqualOtelcolProcessorUnusedmetricError(t *testing.T, tt *componenttes
 	metricdatatest.AssertEqual(t, want, got, opts...)
 }
 
+func AssertEqualOtelcolProcessorUnusedmetricIndexSize(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_index_size",
+		Description: "The estimated size of the decision index in snapshot lookup mode",
+		Unit:        "By",
+		Data: metricdata.Gauge[int64]{
+			DataPoints: dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_index_size")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
 func AssertEqualOtelcolProcessorUnusedmetricKept(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
 	want := metricdata.Metrics{
 		Name:        "otelcol_otelcol_processor_unusedmetric_kept",
-		Description: "The number of metrics kept by the unusedmetric processor",
+		Description: "The number of metrics kept by the unusedmetric processor, a metric counts once per job when any of its data points of the job is kept",
 		Unit:        "{metrics}",
 		Data: metricdata.Sum[int64]{
 			Temporality: metricdata.CumulativeTemporality,
@@ -99,3 +160,99 @@ func AssertEqualOtelcolProcessorUnusedmetricKept(t *testing.T, tt *componenttest
 	require.NoError(t, err)
 	metricdatatest.AssertEqual(t, want, got, opts...)
 }
+
+func AssertEqualOtelcolProcessorUnusedmetricKeptDatapoints(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_kept_datapoints",
+		Description: "The number of data points kept by the unusedmetric processor",
+		Unit:        "{datapoints}",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_kept_datapoints")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
+func AssertEqualOtelcolProcessorUnusedmetricLookupQueueDropped(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_lookup_queue_dropped",
+		Description: "The number of lookups not enqueued because the async lookup queue was full",
+		Unit:        "{lookups}",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_lookup_queue_dropped")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
+func AssertEqualOtelcolProcessorUnusedmetricOverflowDatapoints(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_overflow_datapoints",
+		Description: "The number of data points of new series over the series limit of their tier",
+		Unit:        "{datapoints}",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_overflow_datapoints")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
+func AssertEqualOtelcolProcessorUnusedmetricPrunedDatapoints(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_pruned_datapoints",
+		Description: "The number of kept data points whose buckets or quantiles that are not queried were removed",
+		Unit:        "{datapoints}",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_pruned_datapoints")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
+func AssertEqualOtelcolProcessorUnusedmetricResumedSeries(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_resumed_series",
+		Description: "The number of cumulative series whose first point after their metric was kept again was reset or suppressed",
+		Unit:        "{series}",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_resumed_series")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
+
+func AssertEqualOtelcolProcessorUnusedmetricStalenessMarkers(t *testing.T, tt *componenttest.Telemetry, dps []metricdata.DataPoint[int64], opts ...metricdatatest.Option) {
+	want := metricdata.Metrics{
+		Name:        "otelcol_otelcol_processor_unusedmetric_staleness_markers",
+		Description: "The number of staleness markers emitted for the series of the metrics that became unused",
+		Unit:        "{datapoints}",
+		Data: metricdata.Sum[int64]{
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+			DataPoints:  dps,
+		},
+	}
+	got, err := tt.GetMetric("otelcol_otelcol_processor_unusedmetric_staleness_markers")
+	require.NoError(t, err)
+	metricdatatest.AssertEqual(t, want, got, opts...)
+}
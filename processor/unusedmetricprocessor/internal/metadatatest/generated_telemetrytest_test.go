@@ -20,12 +20,31 @@ func TestSetupTelemetry(t *testing.T) {
 	require.NoError(t, err)
 	defer tb.Shutdown()
 	tb.OtelcolProcessorUnusedmetricBackendDuration.Record(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricBatchDroppedSeries.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricBatchLookupDuration.Record(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricBytesSaved.Add(context.Background(), 1)
 	tb.OtelcolProcessorUnusedmetricDropped.Add(context.Background(), 1)
 	tb.OtelcolProcessorUnusedmetricDroppedDatapoints.Add(context.Background(), 1)
 	tb.OtelcolProcessorUnusedmetricError.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricIndexSize.Record(context.Background(), 1)
 	tb.OtelcolProcessorUnusedmetricKept.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricKeptDatapoints.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricLookupQueueDropped.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricOverflowDatapoints.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricPrunedDatapoints.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricResumedSeries.Add(context.Background(), 1)
+	tb.OtelcolProcessorUnusedmetricStalenessMarkers.Add(context.Background(), 1)
 	AssertEqualOtelcolProcessorUnusedmetricBackendDuration(t, testTel,
-		[]metricdata.HistogramDataPoint[int64]{{}}, metricdatatest.IgnoreValue(),
+		[]metricdata.HistogramDataPoint[float64]{{}}, metricdatatest.IgnoreValue(),
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricBatchDroppedSeries(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricBatchLookupDuration(t, testTel,
+		[]metricdata.HistogramDataPoint[float64]{{}}, metricdatatest.IgnoreValue(),
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricBytesSaved(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
 		metricdatatest.IgnoreTimestamp())
 	AssertEqualOtelcolProcessorUnusedmetricDropped(t, testTel,
 		[]metricdata.DataPoint[int64]{{Value: 1}},
@@ -36,9 +55,30 @@ func TestSetupTelemetry(t *testing.T) {
 	AssertEqualOtelcolProcessorUnusedmetricError(t, testTel,
 		[]metricdata.DataPoint[int64]{{Value: 1}},
 		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricIndexSize(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
 	AssertEqualOtelcolProcessorUnusedmetricKept(t, testTel,
 		[]metricdata.DataPoint[int64]{{Value: 1}},
 		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricKeptDatapoints(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricLookupQueueDropped(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricOverflowDatapoints(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricPrunedDatapoints(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricResumedSeries(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
+	AssertEqualOtelcolProcessorUnusedmetricStalenessMarkers(t, testTel,
+		[]metricdata.DataPoint[int64]{{Value: 1}},
+		metricdatatest.IgnoreTimestamp())
 
 	require.NoError(t, testTel.Shutdown(context.Background()))
 }
@@ -0,0 +1,176 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+// summaryTopJobs is the number of jobs named in a summary of failed lookups.
+const summaryTopJobs = 5
+
+// failureLog keeps the failed lookups from flooding the logs while the server
+// is unreachable. Within a summary interval, each (job, metric, error class)
+// is logged at most once and at most MaxFailures are logged, and the failures
+// are summarized at the end of the interval.
+type failureLog struct {
+	logger *zap.Logger
+	config LoggingConfig
+
+	mu sync.Mutex
+	// failures logged in the current interval
+	logged map[failureKey]struct{}
+	// failures in the current interval, by job and by error class
+	failures      int
+	jobs          map[string]int
+	errorClasses  map[string]int
+	batchTimeouts int
+}
+
+type failureKey struct {
+	key        usagestore.Key
+	errorClass string
+}
+
+func newFailureLog(logger *zap.Logger, config LoggingConfig) *failureLog {
+	l := &failureLog{logger: logger, config: config}
+	l.reset()
+	return l
+}
+
+func (l *failureLog) reset() {
+	l.logged = map[failureKey]struct{}{}
+	l.failures = 0
+	l.jobs = map[string]int{}
+	l.errorClasses = map[string]int{}
+	l.batchTimeouts = 0
+}
+
+// record counts a failed lookup and logs it unless it is a repeat or the
+// limit of the interval is reached.
+func (l *failureLog) record(key usagestore.Key, errorClass string, err error) {
+	l.mu.Lock()
+	l.failures++
+	l.jobs[key.Job]++
+	l.errorClasses[errorClass]++
+	fk := failureKey{key: key, errorClass: errorClass}
+	_, repeat := l.logged[fk]
+	log := !repeat && len(l.logged) < l.config.MaxFailures
+	if log {
+		l.logged[fk] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	if log {
+		l.logger.Error("error getting metric usage",
+			zap.String("job", key.Job),
+			zap.String("metric", key.Metric),
+			zap.String("error_class", errorClass),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordBatchTimeout counts the lookups of a batch cut short by the batch
+// lookup time and logs the first batch of the interval.
+func (l *failureLog) recordBatchTimeout(keys []usagestore.Key, decisions int, maxBatchLookupTime time.Duration) {
+	l.mu.Lock()
+	l.batchTimeouts++
+	first := l.batchTimeouts == 1
+	for _, key := range keys {
+		l.failures++
+		l.jobs[key.Job]++
+		l.errorClasses[usagestore.ErrorClassTimeout]++
+	}
+	l.mu.Unlock()
+
+	if first {
+		l.logger.Warn("batch lookup time exceeded, applying the failure action to unresolved decisions",
+			zap.Int("unresolved", len(keys)),
+			zap.Int("decisions", decisions),
+			zap.Duration("max_batch_lookup_time", maxBatchLookupTime),
+		)
+	}
+}
+
+// summarize logs the failures of the interval, if any, and starts a new one.
+func (l *failureLog) summarize() {
+	l.mu.Lock()
+	failures, logged, batchTimeouts := l.failures, len(l.logged), l.batchTimeouts
+	jobs, errorClasses := l.jobs, l.errorClasses
+	l.reset()
+	l.mu.Unlock()
+
+	if failures == 0 {
+		return
+	}
+	l.logger.Warn(fmt.Sprintf("%s lookups failed in the last %s, top jobs: %s", formatCount(failures), l.config.SummaryInterval, topJobs(jobs)),
+		zap.Int("failures", failures),
+		zap.Int("suppressed", failures-logged),
+		zap.Int("timed_out_batches", batchTimeouts),
+		zap.Any("error_classes", errorClasses),
+	)
+}
+
+// topJobs formats the jobs with the most failures, most failures first.
+func topJobs(jobs map[string]int) string {
+	names := slices.SortedFunc(maps.Keys(jobs), func(a, b string) int {
+		return cmp.Or(cmp.Compare(jobs[b], jobs[a]), strings.Compare(a, b))
+	})
+	var sb strings.Builder
+	for i, name := range names[:min(len(names), summaryTopJobs)] {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%s (%s)", name, formatCount(jobs[name]))
+	}
+	if len(names) > summaryTopJobs {
+		fmt.Fprintf(&sb, " and %d more", len(names)-summaryTopJobs)
+	}
+	return sb.String()
+}
+
+// formatCount formats a count with thousands separators.
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	var sb strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(d)
+	}
+	return sb.String()
+}
+
+// startFailureSummaries summarizes the failed lookups every summary interval
+// until the processor shuts down, and once more then.
+func (sp *unusedMetricProcessor) startFailureSummaries() {
+	sp.wg.Add(1)
+	go func() {
+		defer sp.wg.Done()
+		ticker := time.NewTicker(sp.config.Logging.SummaryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sp.lifetime.Done():
+				sp.failures.summarize()
+				return
+			case <-ticker.C:
+				sp.failures.summarize()
+			}
+		}
+	}()
+}
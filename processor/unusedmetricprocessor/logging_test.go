@@ -0,0 +1,90 @@
+package unusedmetricprocessor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/processor/processortest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+)
+
+func TestFailureLog(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := newFailureLog(zap.New(core), LoggingConfig{SummaryInterval: time.Minute, MaxFailures: 2})
+	err := errors.New("connection refused")
+
+	a := usagestore.Key{Job: "api", Metric: "a"}
+	for range 3 {
+		l.record(a, usagestore.ErrorClassConnection, err)
+	}
+	// another error class of the same key is logged
+	l.record(a, usagestore.ErrorClassTimeout, err)
+	// the limit of the interval is reached
+	for i := range 1000 {
+		l.record(usagestore.Key{Job: "checkout", Metric: strconv.Itoa(i)}, usagestore.ErrorClassConnection, err)
+	}
+	l.recordBatchTimeout([]usagestore.Key{a}, 2, time.Second)
+	l.recordBatchTimeout([]usagestore.Key{a}, 2, time.Second)
+	require.Len(t, logs.FilterMessage("error getting metric usage").All(), 2)
+	require.Len(t, logs.FilterMessageSnippet("batch lookup time exceeded").All(), 1)
+
+	l.summarize()
+	summaries := logs.FilterMessageSnippet("lookups failed").All()
+	require.Len(t, summaries, 1)
+	require.Equal(t, "1,006 lookups failed in the last 1m0s, top jobs: checkout (1,000), api (6)", summaries[0].Message)
+	require.Equal(t, int64(1004), summaries[0].ContextMap()["suppressed"])
+
+	// a new interval logs the failures again, and is only summarized if any
+	l.record(a, usagestore.ErrorClassConnection, err)
+	require.Len(t, logs.FilterMessage("error getting metric usage").All(), 3)
+	l.summarize()
+	l.summarize()
+	require.Len(t, logs.FilterMessageSnippet("lookups failed").All(), 2)
+}
+
+func TestTopJobs(t *testing.T) {
+	jobs := map[string]int{"a": 1, "b": 5, "c": 5, "d": 2, "e": 1, "f": 3, "g": 1}
+	require.Equal(t, "b (5), c (5), f (3), d (2), a (1) and 2 more", topJobs(jobs))
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, want := range map[int]string{0: "0", 999: "999", 1000: "1,000", 1234567: "1,234,567"} {
+		require.Equal(t, want, formatCount(n))
+	}
+}
+
+func TestProcessorDeduplicatesFailureLogs(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	settings := processortest.NewNopSettings(metadata.Type)
+	settings.Logger = zap.New(core)
+
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+	f := &fakeClient{errFor: map[string]map[string]error{"myJob": {"a": errors.New("boom")}}}
+	sp, err := newProcessor(settings, cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, sp.start(context.Background(), componenttest.NewNopHost()))
+
+	for range 5 {
+		_, err = sp.processMetrics(context.Background(), newBatch("a", "a"))
+		require.NoError(t, err)
+	}
+	require.Len(t, logs.FilterMessage("error getting metric usage").All(), 1)
+
+	// the failures are summarized on shutdown
+	require.NoError(t, sp.shutdown(context.Background()))
+	summaries := logs.FilterMessageSnippet("lookups failed").All()
+	require.Len(t, summaries, 1)
+	require.Equal(t, "5 lookups failed in the last 1m0s, top jobs: myJob (5)", summaries[0].Message)
+}
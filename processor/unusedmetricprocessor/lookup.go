@@ -0,0 +1,237 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+// asyncLookups resolves decisions missing from the cache in the background,
+// so batches never wait for the server. Each key is enqueued at most once
+// until its lookup finishes, and lookups are discarded when the queue is full.
+type asyncLookups struct {
+	queue chan usagestore.Key
+
+	mu      sync.Mutex
+	pending map[usagestore.Key]struct{}
+}
+
+func newAsyncLookups(queueSize int) *asyncLookups {
+	return &asyncLookups{
+		queue:   make(chan usagestore.Key, queueSize),
+		pending: map[usagestore.Key]struct{}{},
+	}
+}
+
+// enqueue schedules a lookup for the key and reports false if the queue is full.
+func (a *asyncLookups) enqueue(key usagestore.Key) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.pending[key]; ok {
+		return true
+	}
+	select {
+	case a.queue <- key:
+		a.pending[key] = struct{}{}
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *asyncLookups) done(key usagestore.Key) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, key)
+}
+
+// startLookupWorkers starts the async lookup workers, they stop when the
+// processor shuts down.
+func (sp *unusedMetricProcessor) startLookupWorkers() {
+	if sp.async == nil {
+		return
+	}
+	for i := 0; i < sp.config.Lookup.Workers; i++ {
+		sp.wg.Add(1)
+		go func() {
+			defer sp.wg.Done()
+			for {
+				select {
+				case <-sp.lifetime.Done():
+					return
+				case key := <-sp.async.queue:
+					if _, err := sp.lookupUsage(sp.lifetime, key); err != nil && sp.lifetime.Err() == nil {
+						sp.failures.record(key, usagestore.ErrorClass(err), err)
+					}
+					sp.async.done(key)
+				}
+			}
+		}()
+	}
+}
+
+// enqueueLookup schedules the lookup of a decision missing from the cache.
+func (sp *unusedMetricProcessor) enqueueLookup(ctx context.Context, key usagestore.Key) {
+	if sp.async.enqueue(key) {
+		return
+	}
+	sp.logger.Debug("async lookup queue is full, discarding lookup",
+		zap.String("job", key.Job),
+		zap.String("metric", key.Metric),
+	)
+	sp.telemetry.OtelcolProcessorUnusedmetricLookupQueueDropped.Add(ctx, 1)
+}
+
+// applyAction reports whether a metric without a decision from the server
+// has to be removed according to the configured action.
+func (sp *unusedMetricProcessor) applyAction(ctx context.Context, action string, job string, metricName string, reason string) bool {
+	if action == actionDrop {
+		sp.logger.Debug("metric dropped",
+			zap.String("job", job),
+			zap.String("metric", metricName),
+			zap.String("reason", reason),
+		)
+		return true
+	}
+	return false
+}
+
+// batchDecisions memoizes the lookups of a batch, including failed ones, so
+// each (job, metric) is resolved once per batch rather than per data point.
+type batchDecisions map[usagestore.Key]batchDecision
+
+type batchDecision struct {
+	entry usagestore.Decision
+	err   error
+}
+
+func (sp *unusedMetricProcessor) batchLookup(ctx context.Context, decisions batchDecisions, key usagestore.Key) (usagestore.Decision, error) {
+	if d, ok := decisions[key]; ok {
+		return d.entry, d.err
+	}
+	entry, err := sp.lookupUsage(ctx, key)
+	sp.recordLookup(ctx, decisions, key, entry, err)
+	return entry, err
+}
+
+func (sp *unusedMetricProcessor) recordLookup(ctx context.Context, decisions batchDecisions, key usagestore.Key, entry usagestore.Decision, err error) {
+	decisions[key] = batchDecision{entry: entry, err: err}
+	if err != nil {
+		errorClass := usagestore.ErrorClass(err)
+		sp.failures.record(key, errorClass, err)
+		sp.recordError(ctx, key.Job, errorClass)
+	}
+}
+
+func (sp *unusedMetricProcessor) recordError(ctx context.Context, job string, errorClass string) {
+	sp.telemetry.OtelcolProcessorUnusedmetricError.Add(
+		ctx,
+		1,
+		metric.WithAttributes(
+			attribute.String("job", job),
+			attribute.String("error_class", errorClass),
+		),
+	)
+}
+
+// resolveBatch looks up the decisions of the batch missing from the cache
+// concurrently, within the lookup time budget of a batch. Decisions still
+// unresolved when the budget is exhausted are recorded as failed, and are
+// cached for the following batches once the server answers.
+func (sp *unusedMetricProcessor) resolveBatch(ctx context.Context, md pmetric.Metrics, decisions batchDecisions) {
+	keys, hits := sp.uncachedKeys(ctx, md)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int(attributeCacheHits, hits),
+		attribute.Int(attributeCacheMisses, len(keys)),
+	)
+	if len(keys) == 0 {
+		return
+	}
+
+	start := time.Now()
+	ctx, span := sp.tracer.Start(ctx, "unusedmetric/resolve_batch", trace.WithAttributes(
+		attribute.Int(usagestore.AttributeMetricCount, len(keys)),
+	))
+	defer span.End()
+	results := sp.store.LookupBatch(ctx, keys, sp.config.Lookup.MaxConcurrentLookups, sp.config.Lookup.MaxBatchLookupTime)
+	sp.telemetry.OtelcolProcessorUnusedmetricBatchLookupDuration.Record(ctx, usagestore.Milliseconds(time.Since(start)))
+
+	var unresolved []usagestore.Key
+	for i, key := range keys {
+		if results[i].TimedOut {
+			// logged for the whole batch below
+			decisions[key] = batchDecision{err: results[i].Err}
+			sp.recordError(ctx, key.Job, usagestore.ErrorClassTimeout)
+			unresolved = append(unresolved, key)
+			continue
+		}
+		sp.recordLookup(ctx, decisions, key, results[i].Decision, results[i].Err)
+	}
+	span.SetAttributes(attribute.Int(attributeUnresolved, len(unresolved)))
+	if len(unresolved) > 0 {
+		sp.failures.recordBatchTimeout(unresolved, len(keys), sp.config.Lookup.MaxBatchLookupTime)
+	}
+}
+
+// uncachedKeys returns the distinct keys of the batch the server has to be
+// asked for, leaving out the metrics kept by an override or an exemption and
+// those decided by a condition, and how many distinct keys were cached.
+func (sp *unusedMetricProcessor) uncachedKeys(ctx context.Context, md pmetric.Metrics) ([]usagestore.Key, int) {
+	now := time.Now()
+	added := map[usagestore.Key]struct{}{}
+	var keys []usagestore.Key
+	hits := 0
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		rm := md.ResourceMetrics().At(i)
+		resource := rm.Resource().Attributes()
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				m := sm.Metrics().At(k)
+				// evaluated for the first uncached key of the metric
+				result, evaluated := rules.ResultNone, false
+				metricdata.ForEachDatapoint(m, func(dp any, attrs pcommon.Map) {
+					key := usagestore.Key{Job: metricdata.Job(attrs), Metric: m.Name()}
+					if _, ok := added[key]; ok {
+						return
+					}
+					if _, ok := sp.store.Cached(key, now); ok {
+						added[key] = struct{}{}
+						hits++
+						return
+					}
+					// conditions are only evaluated for the few uncached keys,
+					// errors are reported when the batch is processed
+					if !evaluated {
+						result, _ = sp.rules.EvalMetric(ctx, rm, sm, m)
+						evaluated = true
+					}
+					condition, err := sp.rules.EvalDatapoint(ctx, rm, sm, m, result, dp)
+					if err != nil {
+						return
+					}
+					if _, ok := sp.rules.Preceding(now, sp.store, key.Job, key.Metric, resource, condition); ok {
+						return
+					}
+					added[key] = struct{}{}
+					keys = append(keys, key)
+				})
+			}
+		}
+	}
+	return keys, hits
+}
@@ -0,0 +1,242 @@
+package unusedmetricprocessor
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/golden"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+)
+
+func newAsyncProcessor(t *testing.T, defaultAction string, f *fakeClient) *unusedMetricProcessor {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Lookup.Mode = lookupModeAsync
+	cfg.Lookup.DefaultAction = defaultAction
+	require.NoError(t, cfg.Validate())
+
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, sp.start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, sp.shutdown(context.Background())) })
+	return sp
+}
+
+func TestAsyncLookupDecidesOnLaterBatches(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	sp := newAsyncProcessor(t, actionKeep, f)
+	input := filepath.Join("testdata", "drop_unused_metric_if_present", "input.yaml")
+
+	md, err := golden.ReadMetrics(input)
+	require.NoError(t, err)
+	metricCount := md.MetricCount()
+	md, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, metricCount, md.MetricCount())
+
+	require.Eventually(t, func() bool {
+		_, ok := sp.store.Cached(usagestore.Key{Job: "myJob", Metric: "unused_metric"}, time.Now())
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	md, err = golden.ReadMetrics(input)
+	require.NoError(t, err)
+	md, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, metricCount-1, md.MetricCount())
+}
+
+func TestAsyncLookupDefaultActionDrop(t *testing.T) {
+	sp := newAsyncProcessor(t, actionDrop, &fakeClient{})
+
+	md, err := golden.ReadMetrics(filepath.Join("testdata", "drop_unused_metric_if_present", "input.yaml"))
+	require.NoError(t, err)
+	md, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Zero(t, md.MetricCount())
+}
+
+func TestAsyncLookupQueue(t *testing.T) {
+	a := newAsyncLookups(1)
+	key := usagestore.Key{Job: "myJob", Metric: "unused_metric"}
+
+	require.True(t, a.enqueue(key))
+	// already pending, not enqueued twice
+	require.True(t, a.enqueue(key))
+	require.Len(t, a.queue, 1)
+	// the queue is full, the lookup is discarded
+	require.False(t, a.enqueue(usagestore.Key{Job: "myJob", Metric: "other_metric"}))
+
+	<-a.queue
+	a.done(key)
+	require.True(t, a.enqueue(key))
+}
+
+func TestConfigInvalidLookup(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Lookup.Mode = "eventually"
+	require.ErrorContains(t, cfg.Validate(), "lookup mode")
+
+	cfg.Lookup.Mode = lookupModeAsync
+	cfg.Lookup.DefaultAction = "maybe"
+	require.ErrorContains(t, cfg.Validate(), "default_action")
+}
+
+// gatedClient holds every lookup until the gate is closed.
+type gatedClient struct {
+	fakeClient
+	gate chan struct{}
+}
+
+func (g *gatedClient) GetMetricUsage(ctx context.Context, job string, name string) (usage.MetricUsage, error) {
+	<-g.gate
+	return g.fakeClient.GetMetricUsage(ctx, job, name)
+}
+
+func TestConcurrentLookupsAreCoalesced(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+
+	client := &gatedClient{
+		fakeClient: fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}},
+		gate:       make(chan struct{}),
+	}
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, client)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry, err := sp.lookupUsage(context.Background(), usagestore.Key{Job: "myJob", Metric: "unused_metric"})
+			require.NoError(t, err)
+			require.True(t, entry.Usage.Unused)
+		}()
+	}
+	// let the lookups pile up on the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(client.gate)
+	wg.Wait()
+	require.Equal(t, int64(1), client.calls.Load())
+}
+
+func TestFailedLookupResolvedOncePerBatch(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+
+	f := &fakeClient{errFor: map[string]map[string]error{"myJob": {"unused_metric": errors.New("boom")}}}
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, f)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	metrics := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	for range 3 {
+		m := metrics.AppendEmpty()
+		m.SetName("unused_metric")
+		dps := m.SetEmptyGauge().DataPoints()
+		for range 5 {
+			dps.AppendEmpty().Attributes().PutStr("job", "myJob")
+		}
+	}
+
+	md, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, 3, md.MetricCount())
+	require.Equal(t, int64(1), f.calls.Load())
+}
+
+// newBatch returns a batch with one gauge data point of myJob per metric name.
+func newBatch(names ...string) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	metrics := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	for _, name := range names {
+		m := metrics.AppendEmpty()
+		m.SetName(name)
+		m.SetEmptyGauge().DataPoints().AppendEmpty().Attributes().PutStr("job", "myJob")
+	}
+	return md
+}
+
+func TestBatchLookupTimeBudget(t *testing.T) {
+	for _, action := range []string{actionKeep, actionDrop} {
+		t.Run(action, func(t *testing.T) {
+			cfg := NewFactory().CreateDefaultConfig().(*Config)
+			cfg.Server.Address = "http://localhost:0"
+			cfg.Lookup.MaxBatchLookupTime = 50 * time.Millisecond
+			cfg.Lookup.FailureAction = action
+			require.NoError(t, cfg.Validate())
+
+			client := &gatedClient{gate: make(chan struct{})}
+			sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, client)
+			require.NoError(t, err)
+
+			start := time.Now()
+			md, err := sp.processMetrics(context.Background(), newBatch("a", "b", "c"))
+			require.NoError(t, err)
+			require.Less(t, time.Since(start), time.Second)
+			if action == actionDrop {
+				require.Zero(t, md.MetricCount())
+			} else {
+				require.Equal(t, 3, md.MetricCount())
+			}
+
+			// the lookups complete in the background for the following batches
+			close(client.gate)
+			require.Eventually(t, func() bool {
+				return len(sp.store.Decisions(time.Now())) == 3
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+// concurrencyClient records the maximum number of lookups running at once.
+type concurrencyClient struct {
+	fakeClient
+	running atomic.Int64
+	max     atomic.Int64
+}
+
+func (c *concurrencyClient) GetMetricUsage(ctx context.Context, job string, name string) (usage.MetricUsage, error) {
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		m := c.max.Load()
+		if n <= m || c.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return c.fakeClient.GetMetricUsage(ctx, job, name)
+}
+
+func TestMaxConcurrentLookups(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Lookup.MaxConcurrentLookups = 2
+	require.NoError(t, cfg.Validate())
+
+	client := &concurrencyClient{}
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, client)
+	require.NoError(t, err)
+
+	_, err = sp.processMetrics(context.Background(), newBatch("a", "b", "c", "d", "e", "f", "g", "h"))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), client.calls.Load())
+	require.Equal(t, int64(2), client.max.Load())
+}
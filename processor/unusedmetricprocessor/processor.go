@@ -2,27 +2,62 @@ package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-open
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/rules"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
 	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
-	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/server"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/processor"
 	"go.opentelemetry.io/collector/processor/processorhelper"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
 type unusedMetricProcessor struct {
-	config *Config
-	client server.Client
-	component.StartFunc
-	component.ShutdownFunc
+	config   *Config
+	rules    *rules.Rules
+	admin    *adminServer
+	settings component.TelemetrySettings
+
+	// nil unless the metrics are classified into usage tiers
+	tiers *tiers
+	// active series of the tiers with a series limit, nil if there is none
+	series *seriesLimiter
+	// series of the kept metrics, nil unless staleness markers are enabled
+	kept *keptSeries
+	// metrics dropped and resumed, nil unless the resumed series are adjusted
+	resumes *resumes
+
+	// decisions of the server, owned by the processor unless it references
+	// a usage extension, in which case it is resolved on start
+	store    *usagestore.Store
+	ownStore bool
+
+	// background lookups, nil in sync lookup mode
+	async *asyncLookups
+
+	// deduplicated logs of the failed lookups
+	failures *failureLog
+
+	// cancelled on shutdown to abort in-flight lookups and background work
+	lifetime       context.Context
+	cancelLifetime context.CancelFunc
+	wg             sync.WaitGroup
+
 	logger    *zap.Logger
 	telemetry *metadata.TelemetryBuilder
+	tracer    trace.Tracer
 }
 
 func newUnusedMetricProcessor(
@@ -30,216 +65,422 @@ func newUnusedMetricProcessor(
 	settings processor.Settings,
 	cfg *Config,
 	nextConsumer consumer.Metrics,
-	client server.Client,
+	client usage.Client,
 ) (processor.Metrics, error) {
-
-	telemetry, err := metadata.NewTelemetryBuilder(settings.TelemetrySettings)
+	sp, err := newProcessor(settings, cfg, client)
 	if err != nil {
 		return nil, err
 	}
-	sp := &unusedMetricProcessor{
-		config:    cfg,
-		client:    client,
-		logger:    settings.Logger.With(zap.String("component", "unusedmetricprocessor")),
-		telemetry: telemetry,
-	}
 
 	return processorhelper.NewMetrics(ctx,
 		settings,
 		cfg,
 		nextConsumer,
 		sp.processMetrics,
+		processorhelper.WithStart(sp.start),
+		processorhelper.WithShutdown(sp.shutdown),
 		processorhelper.WithCapabilities(consumer.Capabilities{MutatesData: true}))
 }
 
+// newProcessor creates the processor and, unless it references a usage
+// extension, the store asking client for the decisions.
+func newProcessor(
+	settings processor.Settings,
+	cfg *Config,
+	client usage.Client,
+) (*unusedMetricProcessor, error) {
+	telemetry, err := metadata.NewTelemetryBuilder(settings.TelemetrySettings)
+	if err != nil {
+		return nil, err
+	}
+	logger := settings.Logger.With(zap.String("component", "unusedmetricprocessor"))
+	rs, err := rules.New(cfg.rulesConfig(), settings.TelemetrySettings, logger)
+	if err != nil {
+		return nil, err
+	}
+	tiers, err := newTiers(cfg.Tiers)
+	if err != nil {
+		return nil, err
+	}
+	sp := &unusedMetricProcessor{
+		config:    cfg,
+		rules:     rs,
+		tiers:     tiers,
+		settings:  settings.TelemetrySettings,
+		logger:    logger,
+		telemetry: telemetry,
+		tracer:    settings.TracerProvider.Tracer(metadata.ScopeName),
+		failures:  newFailureLog(logger, cfg.Logging),
+	}
+	if tiers != nil && len(tiers.limited) > 0 {
+		sp.series = newSeriesLimiter(cfg.Tiers.SeriesExpiry)
+	}
+	if cfg.StalenessMarkers.Enabled {
+		sp.kept = newKeptSeries(cfg.StalenessMarkers.Expiry)
+	}
+	if cfg.Resume.Mode != resumeModeNone {
+		sp.resumes = newResumes(cfg.Resume.Mode, cfg.Resume.Expiry)
+	}
+	sp.lifetime, sp.cancelLifetime = context.WithCancel(context.Background())
+	if cfg.Usage == nil {
+		storeCfg := cfg.Config
+		if cfg.Lookup.Mode != lookupModeSnapshot {
+			// the decision index is only used in snapshot lookup mode
+			storeCfg.Snapshot.Jobs = nil
+		}
+		sp.store = usagestore.NewStore(usagestore.Settings{
+			ID:             settings.ID,
+			Kind:           component.KindProcessor,
+			Logger:         logger,
+			Telemetry:      storeTelemetry{telemetry},
+			TracerProvider: settings.TracerProvider,
+			Decider:        cfg.decider != nil,
+		}, &storeCfg, client)
+		sp.ownStore = true
+	}
+	if cfg.Admin != nil {
+		sp.admin = newAdminServer(sp)
+	}
+	if cfg.Lookup.Mode == lookupModeAsync {
+		sp.async = newAsyncLookups(cfg.Lookup.QueueSize)
+	}
+	return sp, nil
+}
+
+func (sp *unusedMetricProcessor) start(ctx context.Context, host component.Host) error {
+	if sp.ownStore {
+		if err := sp.store.Start(ctx, host); err != nil {
+			return err
+		}
+	} else {
+		store, err := usagestore.GetStore(host, *sp.config.Usage)
+		if err != nil {
+			return err
+		}
+		if sp.config.Lookup.Mode == lookupModeSnapshot && len(store.SnapshotJobs()) == 0 {
+			return fmt.Errorf("usage extension %q has no snapshot jobs, they are required in snapshot lookup mode", *sp.config.Usage)
+		}
+		sp.store = store
+	}
+	if sp.admin != nil {
+		if err := sp.admin.start(ctx, host, sp.settings); err != nil {
+			return err
+		}
+	}
+	sp.rules.Start()
+	sp.startLookupWorkers()
+	sp.startFailureSummaries()
+	// forget the series no longer received
+	if sp.series != nil {
+		sp.startExpiry(sp.series.expiry, sp.series.expire)
+	}
+	if sp.kept != nil {
+		sp.startExpiry(sp.kept.expiry, sp.kept.expire)
+	}
+	if sp.resumes != nil {
+		sp.startExpiry(sp.resumes.expiry, sp.resumes.expire)
+	}
+	return nil
+}
+
+// shutdown aborts in-flight lookups, waits for background work to finish
+// and shuts the store down if the processor owns it.
+func (sp *unusedMetricProcessor) shutdown(ctx context.Context) error {
+	sp.cancelLifetime()
+	sp.rules.Shutdown()
+
+	var errs error
+	if sp.admin != nil {
+		errs = multierr.Append(errs, sp.admin.shutdown(ctx))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sp.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = multierr.Append(errs, ctx.Err())
+	}
+
+	if sp.ownStore {
+		errs = multierr.Append(errs, sp.store.Shutdown(ctx))
+	}
+	return errs
+}
+
+// lookupUsage returns the decision for the key, asking the server only when
+// the cached decision is missing or expired.
+func (sp *unusedMetricProcessor) lookupUsage(ctx context.Context, key usagestore.Key) (usagestore.Decision, error) {
+	return sp.store.Lookup(ctx, key)
+}
+
 func (sp *unusedMetricProcessor) shouldRemoveDatapoint(
 	ctx context.Context,
+	mc *metricContext,
+	dp any,
+	attrs pcommon.Map,
 	job string,
-	metricName string) bool {
+	metricName string,
+	condition rules.Result) bool {
 
 	now := time.Now()
-	response, err := sp.client.GetMetricUsage(ctx, job, metricName)
-	duration := time.Since(now)
-	sp.telemetry.OtelcolProcessorUnusedmetricBackendDuration.Record(
-		ctx,
-		int64(duration.Seconds()),
-	)
-
-	if err != nil {
-		sp.logger.Error("error getting metric usage",
+	if v, ok := sp.rules.Preceding(now, sp.store, job, metricName, mc.resourceMetrics.Resource().Attributes(), condition); ok {
+		sp.logger.Debug("metric decided before asking the server",
 			zap.String("job", job),
 			zap.String("metric", metricName),
-			zap.Error(err),
+			zap.String("action", v.Action),
+			zap.String("source", v.Source),
+			zap.String("reason", v.Reason),
 		)
-		sp.telemetry.OtelcolProcessorUnusedmetricError.Add(
-			ctx,
-			1,
-			metric.WithAttributes(attribute.String("job", job)),
-		)
-		return false
-	}
-	if response.Unused {
-		sp.telemetry.OtelcolProcessorUnusedmetricDropped.Add(
-			ctx,
-			1,
-			metric.WithAttributes(attribute.String("job", job)),
-		)
-		sp.logger.Debug("metric is unused",
-			zap.String("job", job),
-			zap.String("metric", metricName),
-		)
-		return true
+		return v.Action == actionDrop
 	}
-	sp.telemetry.OtelcolProcessorUnusedmetricKept.Add(
-		ctx,
-		1,
-		metric.WithAttributes(attribute.String("job", job)),
-	)
-	return false
-}
 
-func (sp *unusedMetricProcessor) processNumberDataPoint(
-	ctx context.Context,
-	dp pmetric.NumberDataPoint,
-	dps pmetric.NumberDataPointSlice,
-	metricName string,
-) bool {
-	job := ""
-	if j, ok := dp.Attributes().Get("job"); ok {
-		job = j.AsString()
+	if sp.config.Lookup.Mode == lookupModeSnapshot {
+		return sp.applyDecision(ctx, mc, job, metricName, sp.store.Unused(usagestore.Key{Job: job, Metric: metricName}))
 	}
-	if j, ok := dp.Attributes().Get("service.name"); ok {
-		job = j.AsString()
-	}
-	if j, ok := dp.Attributes().Get("scrape_job"); ok {
-		job = j.AsString()
+
+	key := usagestore.Key{Job: job, Metric: metricName}
+	if sp.async != nil {
+		entry, ok := sp.store.Cached(key, now)
+		if !ok {
+			sp.enqueueLookup(ctx, key)
+			return sp.applyAction(ctx, sp.config.Lookup.DefaultAction, job, metricName, "decision is not known yet")
+		}
+		return sp.applyUsage(ctx, mc, dp, attrs, job, metricName, entry.Usage)
 	}
 
-	if sp.shouldRemoveDatapoint(ctx, job, metricName) {
-		sp.telemetry.OtelcolProcessorUnusedmetricDroppedDatapoints.Add(
-			ctx,
-			int64(dps.Len()),
-			metric.WithAttributes(attribute.String("job", job)),
-		)
-		return true
+	entry, err := sp.batchLookup(ctx, mc.decisions, key)
+	if err != nil {
+		return sp.applyAction(ctx, sp.config.Lookup.FailureAction, job, metricName, "decision could not be resolved")
 	}
-	return false
+	return sp.applyUsage(ctx, mc, dp, attrs, job, metricName, entry.Usage)
 }
 
-func (sp *unusedMetricProcessor) processExponentialHistogramDataPoint(
+// Sources of a verdict.
+const (
+	sourceOverride      = rules.SourceOverride
+	sourceExemption     = rules.SourceExemption
+	sourceCondition     = rules.SourceCondition
+	sourceIndex         = "index"
+	sourceServer        = "server"
+	sourceDefaultAction = "default_action"
+	sourceLookup        = "lookup"
+)
+
+// applyUsage reports whether the metric has to be removed according to the
+// decision of the server, or stamps its tier when the metrics are classified
+// into tiers, and prunes the kept data point.
+func (sp *unusedMetricProcessor) applyUsage(
 	ctx context.Context,
-	dp pmetric.ExponentialHistogramDataPoint,
-	dps pmetric.ExponentialHistogramDataPointSlice,
+	mc *metricContext,
+	dp any,
+	attrs pcommon.Map,
+	job string,
 	metricName string,
+	u usage.MetricUsage,
 ) bool {
-	job := ""
-	if j, ok := dp.Attributes().Get("job"); ok {
-		job = j.AsString()
-	}
-	if j, ok := dp.Attributes().Get("service.name"); ok {
-		job = j.AsString()
-	}
-	if j, ok := dp.Attributes().Get("scrape_job"); ok {
-		job = j.AsString()
-	}
-
-	if sp.shouldRemoveDatapoint(ctx, job, metricName) {
-		sp.telemetry.OtelcolProcessorUnusedmetricDroppedDatapoints.Add(
-			ctx,
-			int64(dps.Len()),
-			metric.WithAttributes(attribute.String("job", job)),
-		)
+	if sp.tiers != nil {
+		if sp.applyTier(ctx, mc, dp, attrs, job, metricName, u) {
+			return true
+		}
+	} else if sp.applyDecision(ctx, mc, job, metricName, u.Unused) {
 		return true
 	}
+	sp.prune(ctx, mc, dp, job, u.Series)
 	return false
 }
 
-func (sp *unusedMetricProcessor) processHistogramDataPoint(
+// applyTier stamps the tier of the metric on the data point and reports
+// whether the data point has to be removed because its series is over the
+// series limit of the tier.
+func (sp *unusedMetricProcessor) applyTier(
 	ctx context.Context,
-	dp pmetric.HistogramDataPoint,
-	dps pmetric.HistogramDataPointSlice,
+	mc *metricContext,
+	dp any,
+	attrs pcommon.Map,
+	job string,
 	metricName string,
+	u usage.MetricUsage,
 ) bool {
-	job := ""
-	if j, ok := dp.Attributes().Get("job"); ok {
-		job = j.AsString()
+	tier := sp.tiers.classify(u)
+	attrs.PutStr(sp.config.Tiers.Attribute, tier)
+	rule, limited := sp.tiers.limit(tier)
+	if !limited {
+		return false
 	}
-	if j, ok := dp.Attributes().Get("service.name"); ok {
-		job = j.AsString()
+	if sp.series.admit(usagestore.Key{Job: job, Metric: metricName}, seriesHash(mc, attrs), rule.MaxSeries, time.Now()) {
+		return false
 	}
-	if j, ok := dp.Attributes().Get("scrape_job"); ok {
-		job = j.AsString()
+	return sp.applyOverflow(ctx, mc, dp, job, tier, rule.OverflowAction)
+}
+
+// applyOverflow removes a data point over the series limit of its tier,
+// after merging it into the overflow series of its metric when they are
+// aggregated.
+func (sp *unusedMetricProcessor) applyOverflow(ctx context.Context, mc *metricContext, dp any, job string, tier string, action string) bool {
+	if action == overflowActionAggregate {
+		if mc.overflow == nil {
+			mc.overflow = newOverflowSeries(mc.metric)
+		}
+		if !mc.overflow.add(dp, sp.config.Tiers.Attribute, tier) {
+			action = overflowActionDrop
+		}
 	}
+	sp.telemetry.OtelcolProcessorUnusedmetricOverflowDatapoints.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("job", job),
+		attribute.String("tier", tier),
+		attribute.String("overflow_action", action),
+	))
+	return true
+}
 
-	if sp.shouldRemoveDatapoint(ctx, job, metricName) {
-		sp.telemetry.OtelcolProcessorUnusedmetricDroppedDatapoints.Add(
-			ctx,
-			int64(dps.Len()),
-			metric.WithAttributes(attribute.String("job", job)),
+// applyDecision reports whether the metric has to be removed according to
+// the decision of the server.
+func (sp *unusedMetricProcessor) applyDecision(ctx context.Context, mc *metricContext, job string, metricName string, unused bool) bool {
+	if unused {
+		sp.logger.Debug("metric is unused",
+			zap.String("job", job),
+			zap.String("metric", metricName),
 		)
+		sp.markUnused(ctx, mc, job, metricName)
+		if sp.resumes != nil {
+			sp.resumes.drop(usagestore.Key{Job: job, Metric: metricName}, time.Now())
+		}
 		return true
 	}
+	if sp.resumes != nil {
+		sp.resumes.keep(usagestore.Key{Job: job, Metric: metricName}, time.Now())
+	}
 	return false
 }
 
-func (sp *unusedMetricProcessor) processSummaryDataPoint(
+// metricContext carries the metric being processed, the result of its
+// metric level conditions and the decisions of the batch down to the data
+// points.
+type metricContext struct {
+	resourceMetrics pmetric.ResourceMetrics
+	scopeMetrics    pmetric.ScopeMetrics
+	metric          pmetric.Metric
+	result          rules.Result
+	decisions       batchDecisions
+	// data points over the series limit of the tier of the metric
+	overflow *overflowSeries
+	// staleness markers added to the batch
+	markers *staleMarkers
+}
+
+// evalDatapoint evaluates the conditions of the data point of the metric.
+func (sp *unusedMetricProcessor) evalDatapoint(ctx context.Context, mc *metricContext, dp any) (rules.Result, error) {
+	return sp.rules.EvalDatapoint(ctx, mc.resourceMetrics, mc.scopeMetrics, mc.metric, mc.result, dp)
+}
+
+// processDatapoint reports whether the data point has to be removed and
+// counts it in the tally of its metric.
+func (sp *unusedMetricProcessor) processDatapoint(
 	ctx context.Context,
-	dp pmetric.SummaryDataPoint,
-	dps pmetric.SummaryDataPointSlice,
+	mc *metricContext,
+	dp any,
+	attrs pcommon.Map,
 	metricName string,
-) bool {
-	job := ""
-	if j, ok := dp.Attributes().Get("job"); ok {
-		job = j.AsString()
+	tally metricTally,
+) (bool, error) {
+	job := metricdata.Job(attrs)
+
+	condition, err := sp.evalDatapoint(ctx, mc, dp)
+	if err != nil {
+		tally.keep(job)
+		return false, err
 	}
-	if j, ok := dp.Attributes().Get("service.name"); ok {
-		job = j.AsString()
+	if sp.shouldRemoveDatapoint(ctx, mc, dp, attrs, job, metricName, condition) {
+		tally.drop(job, dp, attrs)
+		return true, nil
 	}
-	if j, ok := dp.Attributes().Get("scrape_job"); ok {
-		job = j.AsString()
+	if sp.applyResume(ctx, mc, dp, attrs, job, metricName) {
+		tally.drop(job, dp, attrs)
+		return true, nil
 	}
-
-	if sp.shouldRemoveDatapoint(ctx, job, metricName) {
-		sp.telemetry.OtelcolProcessorUnusedmetricDroppedDatapoints.Add(
-			ctx,
-			int64(dps.Len()),
-			metric.WithAttributes(attribute.String("job", job)),
-		)
-		return true
+	tally.keep(job)
+	if sp.kept != nil {
+		sp.kept.record(usagestore.Key{Job: job, Metric: metricName}, mc, dp, attrs, time.Now())
 	}
-	return false
+	return false, nil
 }
 
 func (sp *unusedMetricProcessor) processMetrics(ctx context.Context, md pmetric.Metrics) (pmetric.Metrics, error) {
+	metricCount := md.MetricCount()
+	ctx, span := sp.tracer.Start(ctx, "unusedmetric/process", trace.WithAttributes(
+		attribute.String(attributeLookupMode, sp.config.Lookup.Mode),
+		attribute.Int(usagestore.AttributeMetricCount, metricCount),
+	))
+	var errs error
+	// staleness markers of the series of the metrics that became unused
+	markers := newStaleMarkers()
+	markerCount := 0
+	defer func() {
+		span.SetAttributes(attribute.Int(attributeDroppedMetricCount, metricCount-md.MetricCount()+markerCount))
+		usagestore.EndSpan(span, errs)
+	}()
+	decisions := batchDecisions{}
+	if sp.config.Lookup.Mode == lookupModeSync {
+		sp.resolveBatch(ctx, md, decisions)
+	}
+	// keep data points whose conditions could not be evaluated, the errors
+	// are only returned in propagate error mode, which rejects the whole batch
+	removeIf := func(remove bool, err error) bool {
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			return false
+		}
+		return remove
+	}
+
 	md.ResourceMetrics().RemoveIf(func(rm pmetric.ResourceMetrics) bool {
 		rm.ScopeMetrics().RemoveIf(func(sm pmetric.ScopeMetrics) bool {
 			sm.Metrics().RemoveIf(func(m pmetric.Metric) bool {
 				metricName := m.Name()
+				mc := &metricContext{resourceMetrics: rm, scopeMetrics: sm, metric: m, decisions: decisions, markers: markers}
+				result, err := sp.rules.EvalMetric(ctx, rm, sm, m)
+				if err != nil {
+					errs = multierr.Append(errs, err)
+					return false
+				}
+				mc.result = result
+
+				tally := metricTally{}
+				defer sp.recordMetric(ctx, m.Type(), tally)
 				switch m.Type() {
 				case pmetric.MetricTypeGauge:
 					m.Gauge().DataPoints().RemoveIf(func(dp pmetric.NumberDataPoint) bool {
-						return sp.processNumberDataPoint(ctx, dp, m.Gauge().DataPoints(), metricName)
+						return removeIf(sp.processDatapoint(ctx, mc, dp, dp.Attributes(), metricName, tally))
 					})
+					mc.overflow.moveTo(m)
 					return m.Gauge().DataPoints().Len() == 0
 				case pmetric.MetricTypeSum:
 					m.Sum().DataPoints().RemoveIf(func(dp pmetric.NumberDataPoint) bool {
-						return sp.processNumberDataPoint(ctx, dp, m.Sum().DataPoints(), metricName)
+						return removeIf(sp.processDatapoint(ctx, mc, dp, dp.Attributes(), metricName, tally))
 					})
+					mc.overflow.moveTo(m)
 					return m.Sum().DataPoints().Len() == 0
 				case pmetric.MetricTypeExponentialHistogram:
 					m.ExponentialHistogram().DataPoints().RemoveIf(func(dp pmetric.ExponentialHistogramDataPoint) bool {
-						return sp.processExponentialHistogramDataPoint(ctx, dp, m.ExponentialHistogram().DataPoints(), metricName)
+						return removeIf(sp.processDatapoint(ctx, mc, dp, dp.Attributes(), metricName, tally))
 					})
+					mc.overflow.moveTo(m)
 					return m.ExponentialHistogram().DataPoints().Len() == 0
 				case pmetric.MetricTypeHistogram:
 					m.Histogram().DataPoints().RemoveIf(func(dp pmetric.HistogramDataPoint) bool {
-						return sp.processHistogramDataPoint(ctx, dp, m.Histogram().DataPoints(), metricName)
+						return removeIf(sp.processDatapoint(ctx, mc, dp, dp.Attributes(), metricName, tally))
 					})
+					mc.overflow.moveTo(m)
 					return m.Histogram().DataPoints().Len() == 0
 				case pmetric.MetricTypeSummary:
 					m.Summary().DataPoints().RemoveIf(func(dp pmetric.SummaryDataPoint) bool {
-						return sp.processSummaryDataPoint(ctx, dp, m.Summary().DataPoints(), metricName)
+						return removeIf(sp.processDatapoint(ctx, mc, dp, dp.Attributes(), metricName, tally))
 					})
+					mc.overflow.moveTo(m)
 					return m.Summary().DataPoints().Len() == 0
 				}
 				return false
@@ -248,6 +489,11 @@ func (sp *unusedMetricProcessor) processMetrics(ctx context.Context, md pmetric.
 		})
 		return rm.ScopeMetrics().Len() == 0
 	})
+	markerCount = markers.md.MetricCount()
+	markers.md.ResourceMetrics().MoveAndAppendTo(md.ResourceMetrics())
+	if sp.tiers != nil && sp.config.Tiers.Location == tierLocationResource {
+		moveToResource(md, sp.config.Tiers.Attribute)
+	}
 
-	return md, nil
+	return md, errs
 }
@@ -3,16 +3,24 @@ package unusedmetricprocessor
 import (
 	"context"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
 	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
-	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/server"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadatatest"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/golden"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/pdatatest/pmetrictest"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componentstatus"
+	"go.opentelemetry.io/collector/component/componenttest"
 	"go.opentelemetry.io/collector/consumer/consumertest"
 	"go.opentelemetry.io/collector/processor/processortest"
+	"go.uber.org/zap"
 )
 
 type fakeClient struct {
@@ -20,12 +28,17 @@ type fakeClient struct {
 	decisions map[string]map[string]bool
 	// optional error injection
 	errFor map[string]map[string]error
+	// optional ListMetricUsage and StreamMetricUsage error
+	listErr error
+	// number of GetMetricUsage calls
+	calls atomic.Int64
 }
 
-func (f *fakeClient) GetMetricUsage(ctx context.Context, job string, name string) (server.MetricUsage, error) {
+func (f *fakeClient) GetMetricUsage(ctx context.Context, job string, name string) (usage.MetricUsage, error) {
+	f.calls.Add(1)
 	if jobMap, ok := f.errFor[job]; ok {
 		if err, ok2 := jobMap[name]; ok2 && err != nil {
-			return server.MetricUsage{}, err
+			return usage.MetricUsage{}, err
 		}
 	}
 	unused := false
@@ -34,13 +47,49 @@ func (f *fakeClient) GetMetricUsage(ctx context.Context, job string, name string
 			unused = val
 		}
 	}
-	return server.MetricUsage{Unused: unused, Name: name}, nil
+	return usage.MetricUsage{Unused: unused, Name: name}, nil
 }
 
+func (f *fakeClient) ListMetricUsage(ctx context.Context, job string) ([]usage.MetricUsage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	var usages []usage.MetricUsage
+	for name, unused := range f.decisions[job] {
+		usages = append(usages, usage.MetricUsage{Name: name, Unused: unused})
+	}
+	return usages, nil
+}
+
+func (f *fakeClient) StreamMetricUsage(ctx context.Context, job string, _ string, fn func(usage.MetricUsage) error) (usage.StreamResult, error) {
+	usages, err := f.ListMetricUsage(ctx, job)
+	if err != nil {
+		return usage.StreamResult{}, err
+	}
+	for _, u := range usages {
+		if err := fn(u); err != nil {
+			return usage.StreamResult{}, err
+		}
+	}
+	return usage.StreamResult{}, nil
+}
+
+func (f *fakeClient) WatchMetricUsage(ctx context.Context, _ string, _ func(usage.WatchEvent) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeClient) Ping(context.Context) error {
+	return nil
+}
+
+func (f *fakeClient) CloseIdleConnections() {}
+
 func TestProcessor(t *testing.T) {
 	t.Parallel()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 
 	testCases := []struct {
 		name string
@@ -56,6 +105,8 @@ func TestProcessor(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
 			next := &consumertest.MetricsSink{}
 
 			// Build cfg with defaults
@@ -103,3 +154,222 @@ func TestProcessor(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessorKeepsExemptedMetrics(t *testing.T) {
+	ctx := context.Background()
+	next := &consumertest.MetricsSink{}
+
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Exemptions.Rules = []ExemptionRule{
+		{
+			MetricName: "unused_metric",
+			Job:        "myJob",
+			Owner:      "team-a",
+			Reason:     "migration",
+			ExpiresAt:  "2999-01-01",
+		},
+	}
+	require.NoError(t, cfg.Validate())
+
+	f := &fakeClient{decisions: map[string]map[string]bool{
+		"myJob": {"unused_metric": true},
+	}}
+	processor, err := newUnusedMetricProcessor(ctx, processortest.NewNopSettings(metadata.Type), cfg, next, f)
+	require.NoError(t, err)
+
+	dir := filepath.Join("testdata", "drop_unused_metric_if_present")
+	md, err := golden.ReadMetrics(filepath.Join(dir, "input.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, processor.ConsumeMetrics(ctx, md))
+
+	expected, err := golden.ReadMetrics(filepath.Join(dir, "input.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, pmetrictest.CompareMetrics(expected, next.AllMetrics()[0]))
+}
+
+func TestSnapshotLookupMode(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Lookup.Mode = lookupModeSnapshot
+	cfg.Snapshot.Jobs = []string{"myJob"}
+	require.NoError(t, cfg.Validate())
+
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"a": true, "b": false}}}
+	sp, err := newProcessor(metadatatest.NewSettings(tel), cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, sp.start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, sp.shutdown(context.Background())) })
+
+	md, err := sp.processMetrics(context.Background(), newBatch("a", "b", "c"))
+	require.NoError(t, err)
+	require.Equal(t, 2, md.MetricCount())
+	require.Zero(t, f.calls.Load())
+
+	_, err = tel.GetMetric("otelcol_otelcol_processor_unusedmetric_index_size")
+	require.NoError(t, err)
+}
+
+// usageHost exposes a store the way the unusedmetricusage extension does.
+type usageHost struct {
+	component.Host
+	extensions map[component.ID]component.Component
+}
+
+func (h *usageHost) GetExtensions() map[component.ID]component.Component {
+	return h.extensions
+}
+
+type storeExtension struct {
+	component.StartFunc
+	component.ShutdownFunc
+	store *usagestore.Store
+}
+
+func (e *storeExtension) Store() *usagestore.Store {
+	return e.store
+}
+
+func TestProcessorsShareUsageExtension(t *testing.T) {
+	storeCfg := &usagestore.Config{Server: usagestore.ServerConfig{Address: "http://localhost:0"}}
+	require.NoError(t, storeCfg.Validate())
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"a": true}}}
+	store := usagestore.NewStore(usagestore.Settings{Logger: zap.NewNop()}, storeCfg, f)
+
+	usageID := component.MustNewID("unusedmetricusage")
+	host := &usageHost{
+		Host:       componenttest.NewNopHost(),
+		extensions: map[component.ID]component.Component{usageID: &storeExtension{store: store}},
+	}
+	require.NoError(t, store.Start(context.Background(), host))
+	t.Cleanup(func() { require.NoError(t, store.Shutdown(context.Background())) })
+
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Usage = &usageID
+	require.NoError(t, cfg.Validate())
+
+	for range 2 {
+		sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, nil)
+		require.NoError(t, err)
+		require.NoError(t, sp.start(context.Background(), host))
+		md, err := sp.processMetrics(context.Background(), newBatch("a", "b"))
+		require.NoError(t, err)
+		require.Equal(t, 1, md.MetricCount())
+		require.NoError(t, sp.shutdown(context.Background()))
+	}
+	// the second processor reuses the decisions fetched for the first one
+	require.Equal(t, int64(2), f.calls.Load())
+
+	// the shared store outlives the processors
+	_, err := store.Lookup(context.Background(), usagestore.Key{Job: "myJob", Metric: "a"})
+	require.NoError(t, err)
+}
+
+func TestUsageExtensionErrors(t *testing.T) {
+	usageID := component.MustNewID("unusedmetricusage")
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Usage = &usageID
+	require.NoError(t, cfg.Validate())
+
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, nil)
+	require.NoError(t, err)
+	require.ErrorContains(t, sp.start(context.Background(), componenttest.NewNopHost()), `usage extension "unusedmetricusage" not found`)
+	require.NoError(t, sp.shutdown(context.Background()))
+
+	cfg.Server.Address = "http://localhost:0"
+	require.ErrorContains(t, cfg.Validate(), "server address must not be set")
+}
+
+// statusHost records the statuses reported by the components.
+type statusHost struct {
+	component.Host
+	mu       sync.Mutex
+	statuses []componentstatus.Status
+}
+
+func (h *statusHost) Report(event *componentstatus.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statuses = append(h.statuses, event.Status())
+}
+
+func (h *statusHost) reported() []componentstatus.Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]componentstatus.Status{}, h.statuses...)
+}
+
+// decider only answers lookups of single metrics.
+type decider map[string]bool
+
+func (d decider) GetMetricUsage(_ context.Context, _ string, name string) (usage.MetricUsage, error) {
+	return usage.MetricUsage{Name: name, Unused: d[name]}, nil
+}
+
+func TestNewFactoryWithDecider(t *testing.T) {
+	ctx := context.Background()
+	factory := NewFactoryWithDecider(decider{"unused_metric": true})
+	cfg := factory.CreateDefaultConfig().(*Config)
+	require.NoError(t, cfg.Validate())
+
+	next := &consumertest.MetricsSink{}
+	processor, err := factory.CreateMetrics(ctx, processortest.NewNopSettings(metadata.Type), cfg, next)
+	require.NoError(t, err)
+	host := &statusHost{Host: componenttest.NewNopHost()}
+	require.NoError(t, processor.Start(ctx, host))
+	defer func() { require.NoError(t, processor.Shutdown(ctx)) }()
+	// no server address is validated
+	require.Equal(t, []componentstatus.Status{componentstatus.StatusOK}, host.reported())
+
+	dir := filepath.Join("testdata", "drop_unused_metric_if_present")
+	md, err := golden.ReadMetrics(filepath.Join(dir, "input.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, processor.ConsumeMetrics(ctx, md))
+	expected, err := golden.ReadMetrics(filepath.Join(dir, "output.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, pmetrictest.CompareMetrics(expected, next.AllMetrics()[0]))
+
+	cfg.Server.Address = "http://localhost:0"
+	require.ErrorContains(t, cfg.Validate(), "server address must not be set when the processor uses a custom decider")
+	cfg.Server.Address = ""
+	usageID := component.MustNewID("unusedmetricusage")
+	cfg.Usage = &usageID
+	require.ErrorContains(t, cfg.Validate(), "a usage extension cannot be referenced")
+	cfg.Usage = nil
+
+	// features fetching decisions in bulk or streaming them need the server
+	for name, tt := range map[string]struct {
+		configure func(*Config)
+		err       string
+	}{
+		"snapshot": {
+			configure: func(cfg *Config) {
+				cfg.Lookup.Mode = lookupModeSnapshot
+				cfg.Snapshot.Jobs = []string{"myJob"}
+			},
+			err: "snapshot lookup mode requires a custom decider implementing usage.Client",
+		},
+		"warmup":    {configure: func(cfg *Config) { cfg.Warmup.Jobs = []string{"myJob"} }, err: "warmup jobs require a custom decider"},
+		"watch":     {configure: func(cfg *Config) { cfg.Watch.Enabled = true }, err: "watch requires a custom decider"},
+		"bootstrap": {configure: func(cfg *Config) { cfg.BootstrapFile = "bootstrap.json" }, err: "bootstrap_file requires a custom decider"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := factory.CreateDefaultConfig().(*Config)
+			tt.configure(cfg)
+			require.ErrorContains(t, cfg.Validate(), tt.err)
+
+			// a decider implementing the whole client supports them
+			cfg = NewFactoryWithDecider(clientDecider{}).CreateDefaultConfig().(*Config)
+			tt.configure(cfg)
+			require.NoError(t, cfg.Validate())
+		})
+	}
+}
+
+// clientDecider implements the whole client of the analytics server.
+type clientDecider struct {
+	usage.Client
+}
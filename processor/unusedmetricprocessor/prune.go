@@ -0,0 +1,84 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"context"
+	"slices"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+// prune removes the parts of a kept data point whose series are not queried,
+// according to the series usage returned by the server.
+func (sp *unusedMetricProcessor) prune(ctx context.Context, mc *metricContext, dp any, job string, series *usage.SeriesUsage) {
+	if series == nil || !sp.config.Pruning.prune(dp, series) {
+		return
+	}
+	sp.telemetry.OtelcolProcessorUnusedmetricPrunedDatapoints.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("job", job),
+		attribute.String("metric_type", metricdata.TypeName(mc.metric.Type())),
+	))
+}
+
+// prune reports whether the data point was pruned.
+func (c PruningConfig) prune(dp any, series *usage.SeriesUsage) bool {
+	switch dp := dp.(type) {
+	case pmetric.HistogramDataPoint:
+		if !c.Histograms || series.Buckets || (!series.Count && !series.Sum) || dp.BucketCounts().Len() == 0 {
+			return false
+		}
+		// the count and sum are kept, as the _count and _sum series
+		dp.BucketCounts().FromRaw(nil)
+		dp.ExplicitBounds().FromRaw(nil)
+		return true
+	case pmetric.SummaryDataPoint:
+		if !c.Summaries || series.Quantiles == nil {
+			return false
+		}
+		n := dp.QuantileValues().Len()
+		dp.QuantileValues().RemoveIf(func(q pmetric.SummaryDataPointValueAtQuantile) bool {
+			return !slices.Contains(series.Quantiles, q.Quantile())
+		})
+		return dp.QuantileValues().Len() < n
+	case pmetric.ExponentialHistogramDataPoint:
+		if !c.ExponentialHistograms || series.MaxScale == nil || dp.Scale() <= *series.MaxScale {
+			return false
+		}
+		downscale(dp, *series.MaxScale)
+		return true
+	}
+	return false
+}
+
+// downscale lowers the scale of the exponential histogram, merging every
+// 2^(scale difference) adjacent buckets into one.
+func downscale(dp pmetric.ExponentialHistogramDataPoint, scale int32) {
+	shift := dp.Scale() - scale
+	downscaleBuckets(dp.Positive(), shift)
+	downscaleBuckets(dp.Negative(), shift)
+	dp.SetScale(scale)
+}
+
+func downscaleBuckets(b pmetric.ExponentialHistogramDataPointBuckets, shift int32) {
+	counts := b.BucketCounts()
+	// the indexes are floored, as the arithmetic shift of negative indexes does
+	offset := b.Offset() >> shift
+	if counts.Len() == 0 {
+		b.SetOffset(offset)
+		return
+	}
+	last := (b.Offset() + int32(counts.Len()) - 1) >> shift
+	merged := make([]uint64, last-offset+1)
+	for i := 0; i < counts.Len(); i++ {
+		merged[(b.Offset()+int32(i))>>shift-offset] += counts.At(i)
+	}
+	b.SetOffset(offset)
+	counts.FromRaw(merged)
+}
@@ -0,0 +1,163 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadatatest"
+)
+
+// seriesClient returns the series usage of the metrics, every metric is used.
+type seriesClient struct {
+	fakeClient
+	series map[string]*usage.SeriesUsage
+}
+
+func (c *seriesClient) GetMetricUsage(_ context.Context, _ string, name string) (usage.MetricUsage, error) {
+	return usage.MetricUsage{Name: name, Series: c.series[name]}, nil
+}
+
+func newHistogramDatapoint() pmetric.HistogramDataPoint {
+	dp := pmetric.NewHistogramDataPoint()
+	dp.SetCount(3)
+	dp.SetSum(4.5)
+	dp.ExplicitBounds().FromRaw([]float64{1, 5})
+	dp.BucketCounts().FromRaw([]uint64{1, 2, 0})
+	return dp
+}
+
+func TestPruneHistogram(t *testing.T) {
+	all := PruningConfig{Histograms: true, Summaries: true, ExponentialHistograms: true}
+	for name, tc := range map[string]struct {
+		config PruningConfig
+		series usage.SeriesUsage
+		pruned bool
+	}{
+		"count queried":   {config: all, series: usage.SeriesUsage{Count: true}, pruned: true},
+		"sum queried":     {config: all, series: usage.SeriesUsage{Sum: true}, pruned: true},
+		"buckets queried": {config: all, series: usage.SeriesUsage{Buckets: true, Count: true}},
+		// the metric is used by name only, nothing is known about its series
+		"nothing queried": {config: all},
+		"disabled":        {config: PruningConfig{Summaries: true}, series: usage.SeriesUsage{Count: true}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dp := newHistogramDatapoint()
+			require.Equal(t, tc.pruned, tc.config.prune(dp, &tc.series))
+			require.Equal(t, uint64(3), dp.Count())
+			require.Equal(t, 4.5, dp.Sum())
+			if tc.pruned {
+				require.Zero(t, dp.BucketCounts().Len())
+				require.Zero(t, dp.ExplicitBounds().Len())
+			} else {
+				require.Equal(t, 3, dp.BucketCounts().Len())
+			}
+		})
+	}
+}
+
+func TestPruneSummary(t *testing.T) {
+	newDatapoint := func() pmetric.SummaryDataPoint {
+		dp := pmetric.NewSummaryDataPoint()
+		for _, q := range []float64{0.5, 0.9, 0.99} {
+			dp.QuantileValues().AppendEmpty().SetQuantile(q)
+		}
+		return dp
+	}
+	quantiles := func(dp pmetric.SummaryDataPoint) []float64 {
+		var qs []float64
+		for i := 0; i < dp.QuantileValues().Len(); i++ {
+			qs = append(qs, dp.QuantileValues().At(i).Quantile())
+		}
+		return qs
+	}
+	config := PruningConfig{Summaries: true}
+
+	dp := newDatapoint()
+	require.True(t, config.prune(dp, &usage.SeriesUsage{Quantiles: []float64{0.99, 0.5}}))
+	require.Equal(t, []float64{0.5, 0.99}, quantiles(dp))
+
+	dp = newDatapoint()
+	require.True(t, config.prune(dp, &usage.SeriesUsage{Quantiles: []float64{}}))
+	require.Empty(t, quantiles(dp))
+
+	// every quantile is queried when they are not listed
+	dp = newDatapoint()
+	require.False(t, config.prune(dp, &usage.SeriesUsage{Count: true}))
+	require.Len(t, quantiles(dp), 3)
+
+	dp = newDatapoint()
+	require.False(t, config.prune(dp, &usage.SeriesUsage{Quantiles: []float64{0.5, 0.9, 0.99}}))
+}
+
+func TestPruneExponentialHistogram(t *testing.T) {
+	dp := pmetric.NewExponentialHistogramDataPoint()
+	dp.SetScale(3)
+	dp.SetZeroCount(7)
+	dp.Positive().SetOffset(-3)
+	dp.Positive().BucketCounts().FromRaw([]uint64{1, 2, 3, 4, 5, 6})
+	dp.Negative().SetOffset(5)
+	config := PruningConfig{ExponentialHistograms: true}
+
+	scale := int32(1)
+	require.True(t, config.prune(dp, &usage.SeriesUsage{MaxScale: &scale}))
+	require.Equal(t, int32(1), dp.Scale())
+	require.Equal(t, uint64(7), dp.ZeroCount())
+	// indexes -3 to -1 become -1, and 0 to 2 become 0
+	require.Equal(t, int32(-1), dp.Positive().Offset())
+	require.Equal(t, []uint64{6, 15}, dp.Positive().BucketCounts().AsRaw())
+	require.Equal(t, int32(1), dp.Negative().Offset())
+	require.Zero(t, dp.Negative().BucketCounts().Len())
+
+	// already coarse enough
+	scale = 2
+	require.False(t, config.prune(dp, &usage.SeriesUsage{MaxScale: &scale}))
+	require.False(t, config.prune(dp, &usage.SeriesUsage{}))
+}
+
+func TestProcessorPrunesKeptDatapoints(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Pruning.Histograms = true
+	require.NoError(t, cfg.Validate())
+	client := &seriesClient{series: map[string]*usage.SeriesUsage{
+		"http_request_duration_seconds": {Count: true, Sum: true},
+	}}
+	sp, err := newProcessor(metadatatest.NewSettings(tel), cfg, client)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	metrics := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	for _, name := range []string{"http_request_duration_seconds", "rpc_duration_seconds"} {
+		m := metrics.AppendEmpty()
+		m.SetName(name)
+		dp := m.SetEmptyHistogram().DataPoints().AppendEmpty()
+		newHistogramDatapoint().CopyTo(dp)
+		dp.Attributes().PutStr("job", "myJob")
+	}
+
+	md, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, 2, md.MetricCount())
+	require.Zero(t, metrics.At(0).Histogram().DataPoints().At(0).BucketCounts().Len())
+	require.Equal(t, 3, metrics.At(1).Histogram().DataPoints().At(0).BucketCounts().Len())
+
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricPrunedDatapoints(t, tel, []metricdata.DataPoint[int64]{
+		{
+			Attributes: attribute.NewSet(attribute.String("job", "myJob"), attribute.String("metric_type", "histogram")),
+			Value:      1,
+		},
+	}, metricdatatest.IgnoreTimestamp(), metricdatatest.IgnoreExemplars())
+}
@@ -0,0 +1,182 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+const (
+	// resumeModeNone passes the first points of the resumed series unchanged
+	resumeModeNone = "none"
+	// resumeModeReset reports the first point of a resumed series as a reset
+	resumeModeReset = "reset"
+	// resumeModeSuppress drops the first point of a resumed series and
+	// reports the following ones as starting at its time
+	resumeModeSuppress = "suppress"
+)
+
+// resumes tracks the metrics dropped by the decision of the server, and the
+// series of those that are kept again, so that the cumulative series do not
+// resume with a start time from before they were dropped.
+type resumes struct {
+	mode   string
+	expiry time.Duration
+
+	mu sync.Mutex
+	// last time a data point of the metric was dropped
+	dropped map[usagestore.Key]time.Time
+	resumed map[usagestore.Key]*resumedMetric
+}
+
+type resumedMetric struct {
+	at     time.Time
+	series map[uint64]*resumedSeries
+}
+
+type resumedSeries struct {
+	// start time of the series when it resumed, and the start time reported
+	// instead of it, zero once the series was reset by its producer
+	original pcommon.Timestamp
+	start    pcommon.Timestamp
+	seen     time.Time
+}
+
+func newResumes(mode string, expiry time.Duration) *resumes {
+	return &resumes{
+		mode:    mode,
+		expiry:  expiry,
+		dropped: map[usagestore.Key]time.Time{},
+		resumed: map[usagestore.Key]*resumedMetric{},
+	}
+}
+
+// drop records that a data point of the metric was dropped.
+func (r *resumes) drop(key usagestore.Key, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropped[key] = now
+	delete(r.resumed, key)
+}
+
+// keep records that a data point of the metric was kept, which resumes the
+// metric if it was dropped.
+func (r *resumes) keep(key usagestore.Key, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.dropped[key]; !ok {
+		return
+	}
+	delete(r.dropped, key)
+	r.resumed[key] = &resumedMetric{at: now, series: map[uint64]*resumedSeries{}}
+}
+
+// apply adjusts a kept cumulative data point of a resumed metric, and
+// reports whether it is the first point of its series, and whether it has to
+// be removed.
+func (r *resumes) apply(key usagestore.Key, series uint64, dp cumulativeDatapoint, now time.Time) (first bool, remove bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.resumed[key]
+	if !ok {
+		return false, false
+	}
+	s, ok := m.series[series]
+	if !ok {
+		// series started after the metric resumed are not affected
+		if dp.StartTimestamp() >= pcommon.NewTimestampFromTime(m.at) {
+			return false, false
+		}
+		s = &resumedSeries{original: dp.StartTimestamp(), start: dp.Timestamp(), seen: now}
+		m.series[series] = s
+		if r.mode == resumeModeSuppress {
+			return true, true
+		}
+		dp.SetStartTimestamp(dp.Timestamp())
+		return true, false
+	}
+	s.seen = now
+	if s.original == 0 {
+		return false, false
+	}
+	if dp.StartTimestamp() != s.original {
+		// reset by its producer, its start time is reported from now on
+		s.original = 0
+		return false, false
+	}
+	dp.SetStartTimestamp(s.start)
+	return false, false
+}
+
+// expire forgets the metrics and series that were not received for the expiry.
+func (r *resumes) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, dropped := range r.dropped {
+		if now.Sub(dropped) >= r.expiry {
+			delete(r.dropped, key)
+		}
+	}
+	for key, m := range r.resumed {
+		for hash, s := range m.series {
+			if now.Sub(s.seen) >= r.expiry {
+				delete(m.series, hash)
+			}
+		}
+		if len(m.series) == 0 && now.Sub(m.at) >= r.expiry {
+			delete(r.resumed, key)
+		}
+	}
+}
+
+// cumulativeDatapoint is implemented by the data points with a start time.
+type cumulativeDatapoint interface {
+	StartTimestamp() pcommon.Timestamp
+	SetStartTimestamp(pcommon.Timestamp)
+	Timestamp() pcommon.Timestamp
+}
+
+// cumulative returns the data point if it is cumulative.
+func cumulative(m pmetric.Metric, dp any) (cumulativeDatapoint, bool) {
+	switch m.Type() {
+	case pmetric.MetricTypeSum:
+		return dp.(pmetric.NumberDataPoint), m.Sum().AggregationTemporality() == pmetric.AggregationTemporalityCumulative
+	case pmetric.MetricTypeHistogram:
+		return dp.(pmetric.HistogramDataPoint), m.Histogram().AggregationTemporality() == pmetric.AggregationTemporalityCumulative
+	case pmetric.MetricTypeExponentialHistogram:
+		return dp.(pmetric.ExponentialHistogramDataPoint), m.ExponentialHistogram().AggregationTemporality() == pmetric.AggregationTemporalityCumulative
+	case pmetric.MetricTypeSummary:
+		return dp.(pmetric.SummaryDataPoint), true
+	}
+	return nil, false
+}
+
+// applyResume adjusts the kept data point if its metric was resumed, and
+// reports whether it has to be removed.
+func (sp *unusedMetricProcessor) applyResume(ctx context.Context, mc *metricContext, dp any, attrs pcommon.Map, job string, metricName string) bool {
+	if sp.resumes == nil {
+		return false
+	}
+	cdp, ok := cumulative(mc.metric, dp)
+	if !ok {
+		return false
+	}
+	first, remove := sp.resumes.apply(usagestore.Key{Job: job, Metric: metricName}, seriesHash(mc, attrs), cdp, time.Now())
+	if first {
+		sp.telemetry.OtelcolProcessorUnusedmetricResumedSeries.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("job", job),
+			attribute.String("resume_mode", sp.config.Resume.Mode),
+		))
+	}
+	return remove
+}
@@ -0,0 +1,186 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadatatest"
+)
+
+// newCumulativeBatch returns a cumulative sum of the series, started at
+// start and reported at ts.
+func newCumulativeBatch(start pcommon.Timestamp, ts pcommon.Timestamp, series ...int64) pmetric.Metrics {
+	md := newSeriesBatch("requests", series...)
+	sum := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum()
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	for i := 0; i < sum.DataPoints().Len(); i++ {
+		sum.DataPoints().At(i).SetStartTimestamp(start)
+		sum.DataPoints().At(i).SetTimestamp(ts)
+	}
+	return md
+}
+
+func datapoints(md pmetric.Metrics) pmetric.NumberDataPointSlice {
+	if md.ResourceMetrics().Len() == 0 {
+		return pmetric.NewNumberDataPointSlice()
+	}
+	return md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints()
+}
+
+// newResumeProcessor returns a processor whose metric was kept, then dropped
+// and kept again.
+func newResumeProcessor(t *testing.T, mode string, tel *componenttest.Telemetry) *unusedMetricProcessor {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Resume.Mode = mode
+	require.NoError(t, cfg.Validate())
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"requests": false}}}
+	sp, err := newProcessor(metadatatest.NewSettings(tel), cfg, f)
+	require.NoError(t, err)
+
+	flip := func(unused bool) {
+		f.decisions["myJob"]["requests"] = unused
+		sp.store.Flush()
+	}
+	md, err := sp.processMetrics(context.Background(), newCumulativeBatch(10, 20, 1))
+	require.NoError(t, err)
+	require.Equal(t, pcommon.Timestamp(10), datapoints(md).At(0).StartTimestamp())
+	flip(true)
+	md, err = sp.processMetrics(context.Background(), newCumulativeBatch(10, 30, 1))
+	require.NoError(t, err)
+	require.Zero(t, md.DataPointCount())
+	flip(false)
+	return sp
+}
+
+func TestResumeReset(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	sp := newResumeProcessor(t, resumeModeReset, tel)
+
+	// the first point is reported as a reset
+	md, err := sp.processMetrics(context.Background(), newCumulativeBatch(10, 40, 1, 2))
+	require.NoError(t, err)
+	require.Equal(t, 2, md.DataPointCount())
+	require.Equal(t, pcommon.Timestamp(40), datapoints(md).At(0).StartTimestamp())
+	require.Equal(t, pcommon.Timestamp(40), datapoints(md).At(1).StartTimestamp())
+
+	// the next points start at the reset
+	md, err = sp.processMetrics(context.Background(), newCumulativeBatch(10, 50, 1))
+	require.NoError(t, err)
+	require.Equal(t, pcommon.Timestamp(40), datapoints(md).At(0).StartTimestamp())
+
+	// until the producer resets the series
+	md, err = sp.processMetrics(context.Background(), newCumulativeBatch(45, 60, 1))
+	require.NoError(t, err)
+	require.Equal(t, pcommon.Timestamp(45), datapoints(md).At(0).StartTimestamp())
+	md, err = sp.processMetrics(context.Background(), newCumulativeBatch(10, 70, 1))
+	require.NoError(t, err)
+	require.Equal(t, pcommon.Timestamp(10), datapoints(md).At(0).StartTimestamp())
+
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricResumedSeries(t, tel, []metricdata.DataPoint[int64]{
+		{
+			Attributes: attribute.NewSet(attribute.String("job", "myJob"), attribute.String("resume_mode", resumeModeReset)),
+			Value:      2,
+		},
+	}, metricdatatest.IgnoreTimestamp(), metricdatatest.IgnoreExemplars())
+}
+
+func TestResumeSuppress(t *testing.T) {
+	sp := newResumeProcessor(t, resumeModeSuppress, componenttest.NewTelemetry())
+
+	md, err := sp.processMetrics(context.Background(), newCumulativeBatch(10, 40, 1))
+	require.NoError(t, err)
+	require.Zero(t, md.DataPointCount())
+
+	// the following points start at the time of the suppressed one
+	md, err = sp.processMetrics(context.Background(), newCumulativeBatch(10, 50, 1))
+	require.NoError(t, err)
+	require.Equal(t, 1, md.DataPointCount())
+	require.Equal(t, pcommon.Timestamp(40), datapoints(md).At(0).StartTimestamp())
+
+	// until the producer resets the series
+	md, err = sp.processMetrics(context.Background(), newCumulativeBatch(55, 60, 1))
+	require.NoError(t, err)
+	require.Equal(t, pcommon.Timestamp(55), datapoints(md).At(0).StartTimestamp())
+}
+
+func TestResumeIgnoresNewSeries(t *testing.T) {
+	sp := newResumeProcessor(t, resumeModeSuppress, componenttest.NewTelemetry())
+
+	md, err := sp.processMetrics(context.Background(), newCumulativeBatch(10, 40, 1))
+	require.NoError(t, err)
+	require.Zero(t, md.DataPointCount())
+
+	// started after the metric resumed
+	start := pcommon.NewTimestampFromTime(time.Now())
+	md, err = sp.processMetrics(context.Background(), newCumulativeBatch(start, start+10, 3))
+	require.NoError(t, err)
+	require.Equal(t, 1, md.DataPointCount())
+
+	// delta sums have no start time to correct
+	md = newCumulativeBatch(10, 40, 2)
+	datapoints(md).At(0).SetStartTimestamp(30)
+	md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	md, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, 1, md.DataPointCount())
+}
+
+func TestResumeNone(t *testing.T) {
+	sp := newResumeProcessor(t, "", componenttest.NewTelemetry())
+	require.Nil(t, sp.resumes)
+
+	md, err := sp.processMetrics(context.Background(), newCumulativeBatch(10, 40, 1))
+	require.NoError(t, err)
+	require.Equal(t, pcommon.Timestamp(10), datapoints(md).At(0).StartTimestamp())
+}
+
+func TestResumesExpire(t *testing.T) {
+	r := newResumes(resumeModeReset, time.Minute)
+	key := usagestore.Key{Job: "myJob", Metric: "requests"}
+	now := time.Now()
+
+	// a metric no longer received while it was dropped is forgotten
+	r.drop(key, now)
+	r.expire(now.Add(time.Minute))
+	r.keep(key, now.Add(time.Minute))
+	require.Empty(t, r.resumed)
+
+	r.drop(key, now)
+	r.keep(key, now)
+	dp := pmetric.NewNumberDataPoint()
+	dp.SetStartTimestamp(10)
+	dp.SetTimestamp(20)
+	first, remove := r.apply(key, 1, dp, now)
+	require.True(t, first)
+	require.False(t, remove)
+	r.expire(now.Add(30 * time.Second))
+	require.Len(t, r.resumed[key].series, 1)
+	r.expire(now.Add(time.Minute))
+	require.Empty(t, r.resumed)
+}
+
+func TestResumeConfig(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, resumeModeNone, cfg.Resume.Mode)
+	require.Equal(t, defaultResumeExpiry, cfg.Resume.Expiry)
+
+	cfg.Resume.Mode = "interpolate"
+	require.ErrorContains(t, cfg.Validate(), "resume mode")
+}
@@ -0,0 +1,288 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/pdatautil"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+const (
+	overflowActionDrop      = "drop"
+	overflowActionAggregate = "aggregate"
+
+	// attribute of the series aggregating the data points over the limit, as
+	// set by the OpenTelemetry SDKs when they exceed their cardinality limit
+	attributeOverflow = "otel.metric.overflow"
+)
+
+// seriesHash identifies the series of a data point by the attributes of its
+// resource, the name of its scope and its attributes.
+func seriesHash(mc *metricContext, attrs pcommon.Map) uint64 {
+	return pdatautil.Hash64(
+		pdatautil.WithMap(mc.resourceMetrics.Resource().Attributes()),
+		pdatautil.WithString(mc.scopeMetrics.Scope().Name()),
+		pdatautil.WithMap(attrs),
+	)
+}
+
+// seriesLimiter tracks the active series of every (job, metric). A series is
+// active until it has not been seen for the expiry.
+type seriesLimiter struct {
+	expiry time.Duration
+
+	mu      sync.Mutex
+	metrics map[usagestore.Key]*activeSeries
+}
+
+type activeSeries struct {
+	// last time every series was seen
+	seen map[uint64]time.Time
+	// lower bound of the last time any series was seen, none of them expired
+	// before oldest plus the expiry
+	oldest time.Time
+}
+
+func newSeriesLimiter(expiry time.Duration) *seriesLimiter {
+	return &seriesLimiter{expiry: expiry, metrics: map[usagestore.Key]*activeSeries{}}
+}
+
+// admit reports whether the series of the metric is active or can become
+// active without exceeding limit.
+func (l *seriesLimiter) admit(key usagestore.Key, series uint64, limit int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	active, ok := l.metrics[key]
+	if !ok {
+		active = &activeSeries{seen: map[uint64]time.Time{}, oldest: now}
+		l.metrics[key] = active
+	}
+	if _, ok := active.seen[series]; ok {
+		active.seen[series] = now
+		return true
+	}
+	if len(active.seen) >= limit {
+		active.expire(now, l.expiry)
+		if len(active.seen) >= limit {
+			return false
+		}
+	}
+	active.seen[series] = now
+	return true
+}
+
+// expire removes the expired series, and the metrics left without any.
+func (l *seriesLimiter) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, active := range l.metrics {
+		active.expire(now, l.expiry)
+		if len(active.seen) == 0 {
+			delete(l.metrics, key)
+		}
+	}
+}
+
+func (a *activeSeries) expire(now time.Time, expiry time.Duration) {
+	if now.Sub(a.oldest) < expiry {
+		return
+	}
+	a.oldest = now
+	for series, seen := range a.seen {
+		if now.Sub(seen) >= expiry {
+			delete(a.seen, series)
+		} else if seen.Before(a.oldest) {
+			a.oldest = seen
+		}
+	}
+}
+
+// startExpiry calls expire every interval until the processor shuts down.
+func (sp *unusedMetricProcessor) startExpiry(interval time.Duration, expire func(now time.Time)) {
+	sp.wg.Add(1)
+	go func() {
+		defer sp.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sp.lifetime.Done():
+				return
+			case now := <-ticker.C:
+				expire(now)
+			}
+		}
+	}()
+}
+
+// overflowSeries aggregates the data points of a metric over the series
+// limit into a single data point, appended to the metric once its data
+// points are processed.
+type overflowSeries struct {
+	// holds the overflow data point, with the type of the metric, or empty
+	// if the data points of the metric cannot be merged
+	metric pmetric.Metric
+}
+
+// newOverflowSeries returns the overflow series of the metric. The overflow
+// series lives for a batch, so only the data points of gauges and delta sums
+// and histograms can be merged: the running totals of cumulative series
+// would add up to whichever series are in the batch, and backends would
+// read every decrease as a counter reset.
+func newOverflowSeries(m pmetric.Metric) *overflowSeries {
+	o := &overflowSeries{metric: pmetric.NewMetric()}
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		o.metric.SetEmptyGauge()
+	case pmetric.MetricTypeSum:
+		if m.Sum().AggregationTemporality() == pmetric.AggregationTemporalityDelta {
+			o.metric.SetEmptySum()
+		}
+	case pmetric.MetricTypeHistogram:
+		if m.Histogram().AggregationTemporality() == pmetric.AggregationTemporalityDelta {
+			o.metric.SetEmptyHistogram()
+		}
+	}
+	return o
+}
+
+// add merges the data point into the overflow series and reports whether it
+// could be merged. Gauges keep their latest value, delta sums and delta
+// histograms with the same bucket boundaries are added up, and the other
+// data points cannot be merged.
+func (o *overflowSeries) add(dp any, tierAttribute string, tier string) bool {
+	switch o.metric.Type() {
+	case pmetric.MetricTypeGauge, pmetric.MetricTypeSum:
+		var dps pmetric.NumberDataPointSlice
+		if o.metric.Type() == pmetric.MetricTypeGauge {
+			dps = o.metric.Gauge().DataPoints()
+		} else {
+			dps = o.metric.Sum().DataPoints()
+		}
+		dp := dp.(pmetric.NumberDataPoint)
+		if dps.Len() == 0 {
+			newOverflowDatapoint(dp, dps.AppendEmpty(), tierAttribute, tier)
+			return true
+		}
+		mergeNumber(dps.At(0), dp, o.metric.Type() == pmetric.MetricTypeGauge)
+		return true
+	case pmetric.MetricTypeHistogram:
+		dps := o.metric.Histogram().DataPoints()
+		dp := dp.(pmetric.HistogramDataPoint)
+		if dps.Len() == 0 {
+			newOverflowDatapoint(dp, dps.AppendEmpty(), tierAttribute, tier)
+			return true
+		}
+		return mergeHistogram(dps.At(0), dp)
+	}
+	return false
+}
+
+// moveTo appends the overflow series, if any, to the data points of the metric.
+func (o *overflowSeries) moveTo(m pmetric.Metric) {
+	if o == nil {
+		return
+	}
+	switch o.metric.Type() {
+	case pmetric.MetricTypeGauge:
+		o.metric.Gauge().DataPoints().MoveAndAppendTo(m.Gauge().DataPoints())
+	case pmetric.MetricTypeSum:
+		o.metric.Sum().DataPoints().MoveAndAppendTo(m.Sum().DataPoints())
+	case pmetric.MetricTypeHistogram:
+		o.metric.Histogram().DataPoints().MoveAndAppendTo(m.Histogram().DataPoints())
+	}
+}
+
+// newOverflowDatapoint copies the data point into the overflow series, with
+// the overflow and tier attributes only.
+func newOverflowDatapoint[T interface {
+	CopyTo(T)
+	Attributes() pcommon.Map
+}](dp T, overflow T, tierAttribute string, tier string) {
+	dp.CopyTo(overflow)
+	attrs := overflow.Attributes()
+	attrs.Clear()
+	attrs.PutBool(attributeOverflow, true)
+	attrs.PutStr(tierAttribute, tier)
+}
+
+func mergeNumber(into pmetric.NumberDataPoint, dp pmetric.NumberDataPoint, gauge bool) {
+	mergeTimestamps(into, dp)
+	if gauge {
+		if dp.Timestamp() >= into.Timestamp() {
+			setNumber(into, dp)
+		}
+		return
+	}
+	if into.ValueType() == pmetric.NumberDataPointValueTypeInt && dp.ValueType() == pmetric.NumberDataPointValueTypeInt {
+		into.SetIntValue(into.IntValue() + dp.IntValue())
+		return
+	}
+	into.SetDoubleValue(numberValue(into) + numberValue(dp))
+}
+
+func setNumber(into pmetric.NumberDataPoint, dp pmetric.NumberDataPoint) {
+	if dp.ValueType() == pmetric.NumberDataPointValueTypeInt {
+		into.SetIntValue(dp.IntValue())
+		return
+	}
+	into.SetDoubleValue(dp.DoubleValue())
+}
+
+func numberValue(dp pmetric.NumberDataPoint) float64 {
+	if dp.ValueType() == pmetric.NumberDataPointValueTypeInt {
+		return float64(dp.IntValue())
+	}
+	return dp.DoubleValue()
+}
+
+func mergeHistogram(into pmetric.HistogramDataPoint, dp pmetric.HistogramDataPoint) bool {
+	if !slices.Equal(into.ExplicitBounds().AsRaw(), dp.ExplicitBounds().AsRaw()) ||
+		into.BucketCounts().Len() != dp.BucketCounts().Len() {
+		return false
+	}
+	mergeTimestamps(into, dp)
+	into.SetCount(into.Count() + dp.Count())
+	if into.HasSum() && dp.HasSum() {
+		into.SetSum(into.Sum() + dp.Sum())
+	} else {
+		into.RemoveSum()
+	}
+	if into.HasMin() && dp.HasMin() {
+		into.SetMin(min(into.Min(), dp.Min()))
+	} else {
+		into.RemoveMin()
+	}
+	if into.HasMax() && dp.HasMax() {
+		into.SetMax(max(into.Max(), dp.Max()))
+	} else {
+		into.RemoveMax()
+	}
+	for i := 0; i < into.BucketCounts().Len(); i++ {
+		into.BucketCounts().SetAt(i, into.BucketCounts().At(i)+dp.BucketCounts().At(i))
+	}
+	return true
+}
+
+// mergeTimestamps keeps the earliest start time and the latest time.
+func mergeTimestamps[T interface {
+	StartTimestamp() pcommon.Timestamp
+	SetStartTimestamp(pcommon.Timestamp)
+	Timestamp() pcommon.Timestamp
+	SetTimestamp(pcommon.Timestamp)
+}](into T, dp T) {
+	if dp.StartTimestamp() < into.StartTimestamp() {
+		into.SetStartTimestamp(dp.StartTimestamp())
+	}
+	if dp.Timestamp() > into.Timestamp() {
+		into.SetTimestamp(dp.Timestamp())
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/processor/processortest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadatatest"
+)
+
+func TestSeriesLimiter(t *testing.T) {
+	l := newSeriesLimiter(time.Minute)
+	key := usagestore.Key{Job: "myJob", Metric: "m"}
+	now := time.Now()
+
+	require.True(t, l.admit(key, 1, 2, now))
+	require.True(t, l.admit(key, 2, 2, now))
+	require.False(t, l.admit(key, 3, 2, now))
+	// active series are still admitted over the limit
+	require.True(t, l.admit(key, 1, 2, now.Add(30*time.Second)))
+	// the limit is per job and metric
+	require.True(t, l.admit(usagestore.Key{Job: "otherJob", Metric: "m"}, 3, 2, now))
+
+	// series 2 expired, series 1 was seen since
+	require.True(t, l.admit(key, 3, 2, now.Add(time.Minute)))
+	require.False(t, l.admit(key, 4, 2, now.Add(time.Minute)))
+
+	l.expire(now.Add(time.Hour))
+	require.Empty(t, l.metrics)
+}
+
+// newSeriesBatch returns a sum of the job with a data point per series.
+func newSeriesBatch(name string, series ...int64) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName(name)
+	dps := m.SetEmptySum().DataPoints()
+	for _, s := range series {
+		dp := dps.AppendEmpty()
+		dp.Attributes().PutStr("job", "myJob")
+		dp.Attributes().PutStr("series", strconv.FormatInt(s, 10))
+		dp.SetIntValue(s)
+		dp.SetStartTimestamp(pcommon.Timestamp(100 - s))
+		dp.SetTimestamp(pcommon.Timestamp(200 + s))
+	}
+	return md
+}
+
+func newLimitedProcessor(t *testing.T, action string, tel *componenttest.Telemetry) *unusedMetricProcessor {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Tiers.Enabled = true
+	cfg.Tiers.Rules = []TierRule{
+		{Name: "critical", UsedBy: []string{usedByAlerts}},
+		{Name: "cold", UsedBy: []string{usedByQueries}, MaxSeries: 2, OverflowAction: action},
+	}
+	require.NoError(t, cfg.Validate())
+
+	client := &summaryClient{summaries: map[string]*usage.MetricUsageSummary{
+		"queried": {QueryCount: 1},
+		"alerted": {AlertCount: 1},
+	}}
+	sp, err := newProcessor(metadatatest.NewSettings(tel), cfg, client)
+	require.NoError(t, err)
+	return sp
+}
+
+func TestSeriesLimitAggregate(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	sp := newLimitedProcessor(t, overflowActionAggregate, tel)
+
+	md := newSeriesBatch("queried", 1, 2, 3, 4)
+	md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	md, err := sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	dps := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints()
+	require.Equal(t, 3, dps.Len())
+	require.Equal(t, "1", dps.At(0).Attributes().AsRaw()["series"])
+	require.Equal(t, "2", dps.At(1).Attributes().AsRaw()["series"])
+
+	overflow := dps.At(2)
+	require.Equal(t, map[string]any{attributeOverflow: true, defaultTierAttribute: "cold"}, overflow.Attributes().AsRaw())
+	require.Equal(t, int64(7), overflow.IntValue())
+	require.Equal(t, pcommon.Timestamp(96), overflow.StartTimestamp())
+	require.Equal(t, pcommon.Timestamp(204), overflow.Timestamp())
+
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricOverflowDatapoints(t, tel, []metricdata.DataPoint[int64]{
+		{
+			Attributes: attribute.NewSet(
+				attribute.String("job", "myJob"),
+				attribute.String("tier", "cold"),
+				attribute.String("overflow_action", overflowActionAggregate),
+			),
+			Value: 2,
+		},
+	}, metricdatatest.IgnoreTimestamp(), metricdatatest.IgnoreExemplars())
+}
+
+func TestSeriesLimitCumulativeNotAggregated(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	sp := newLimitedProcessor(t, overflowActionAggregate, tel)
+	cumulative := func(series ...int64) pmetric.Metrics {
+		md := newSeriesBatch("queried", series...)
+		md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+		return md
+	}
+
+	// the series over the limit change between the batches, an overflow
+	// series adding up their running totals would go down
+	for _, series := range [][]int64{{1, 2, 3, 4}, {1, 2, 5}} {
+		md, err := sp.processMetrics(context.Background(), cumulative(series...))
+		require.NoError(t, err)
+		dps := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints()
+		require.Equal(t, 2, dps.Len())
+		for i := 0; i < dps.Len(); i++ {
+			_, overflow := dps.At(i).Attributes().Get(attributeOverflow)
+			require.False(t, overflow)
+		}
+	}
+
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricOverflowDatapoints(t, tel, []metricdata.DataPoint[int64]{
+		{
+			Attributes: attribute.NewSet(
+				attribute.String("job", "myJob"),
+				attribute.String("tier", "cold"),
+				attribute.String("overflow_action", overflowActionDrop),
+			),
+			Value: 3,
+		},
+	}, metricdatatest.IgnoreTimestamp(), metricdatatest.IgnoreExemplars())
+}
+
+func TestSeriesLimitDrop(t *testing.T) {
+	sp := newLimitedProcessor(t, overflowActionDrop, componenttest.NewTelemetry())
+
+	md, err := sp.processMetrics(context.Background(), newSeriesBatch("queried", 1, 2, 3, 4))
+	require.NoError(t, err)
+	require.Equal(t, 2, md.DataPointCount())
+
+	// the tiers without a limit are not limited
+	md, err = sp.processMetrics(context.Background(), newSeriesBatch("alerted", 1, 2, 3, 4))
+	require.NoError(t, err)
+	require.Equal(t, 4, md.DataPointCount())
+}
+
+func TestOverflowHistogram(t *testing.T) {
+	m := pmetric.NewMetric()
+	m.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	dps := m.Histogram().DataPoints()
+	for i := range 3 {
+		dp := dps.AppendEmpty()
+		dp.SetCount(uint64(i + 1))
+		dp.SetSum(float64(i + 1))
+		dp.SetMin(float64(i))
+		dp.SetMax(float64(i + 10))
+		dp.ExplicitBounds().FromRaw([]float64{1, 5})
+		dp.BucketCounts().FromRaw([]uint64{uint64(i), 1, 0})
+	}
+	dps.At(2).ExplicitBounds().FromRaw([]float64{1, 10})
+
+	o := newOverflowSeries(m)
+	require.True(t, o.add(dps.At(0), defaultTierAttribute, "cold"))
+	require.True(t, o.add(dps.At(1), defaultTierAttribute, "cold"))
+	// different bucket boundaries cannot be merged
+	require.False(t, o.add(dps.At(2), defaultTierAttribute, "cold"))
+
+	merged := o.metric.Histogram().DataPoints().At(0)
+	require.Equal(t, uint64(3), merged.Count())
+	require.Equal(t, float64(3), merged.Sum())
+	require.Equal(t, float64(0), merged.Min())
+	require.Equal(t, float64(11), merged.Max())
+	require.Equal(t, []uint64{1, 2, 0}, merged.BucketCounts().AsRaw())
+
+	require.False(t, newOverflowSeries(pmetric.NewMetric()).add(pmetric.NewSummaryDataPoint(), defaultTierAttribute, "cold"))
+}
+
+func TestOverflowGaugeKeepsLatest(t *testing.T) {
+	m := pmetric.NewMetric()
+	dps := m.SetEmptyGauge().DataPoints()
+	latest := dps.AppendEmpty()
+	latest.SetTimestamp(20)
+	latest.SetDoubleValue(2)
+	earlier := dps.AppendEmpty()
+	earlier.SetTimestamp(10)
+	earlier.SetDoubleValue(1)
+
+	o := newOverflowSeries(m)
+	require.True(t, o.add(latest, defaultTierAttribute, "cold"))
+	require.True(t, o.add(earlier, defaultTierAttribute, "cold"))
+	o.moveTo(m)
+	require.Equal(t, 3, dps.Len())
+	require.Equal(t, float64(2), dps.At(2).DoubleValue())
+	require.Equal(t, pcommon.Timestamp(20), dps.At(2).Timestamp())
+}
+
+func TestSeriesLimitConfig(t *testing.T) {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Tiers.Enabled = true
+	cfg.Tiers.Rules = []TierRule{{Name: "cold", UsedBy: []string{usedByQueries}, MaxSeries: -1}}
+	require.ErrorContains(t, cfg.Validate(), "max_series")
+
+	cfg.Tiers.Rules = []TierRule{{Name: "cold", UsedBy: []string{usedByQueries}, OverflowAction: "sample"}}
+	require.ErrorContains(t, cfg.Validate(), "overflow_action")
+
+	cfg.Tiers.Rules = []TierRule{{Name: "cold", UsedBy: []string{usedByQueries}, MaxSeries: 10}}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, overflowActionAggregate, cfg.Tiers.Rules[0].OverflowAction)
+	require.Equal(t, defaultSeriesExpiry, cfg.Tiers.SeriesExpiry)
+
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, &fakeClient{})
+	require.NoError(t, err)
+	require.NotNil(t, sp.series)
+}
@@ -0,0 +1,313 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/pdatautil"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+)
+
+// keptSeries remembers the series of the kept metrics, so they can be marked
+// stale when their metric becomes unused. Only what a marker needs is kept:
+// the attributes of the resource, scope and data point of a series and its
+// start time, and the series of a resource share it. A series is forgotten
+// once it has not been kept for the expiry.
+type keptSeries struct {
+	expiry time.Duration
+
+	mu      sync.Mutex
+	metrics map[usagestore.Key]*keptMetric
+	// resources of the remembered series
+	resources map[resourceKey]*keptResource
+}
+
+// resourceKey identifies a resource by its schema URL and attributes.
+type resourceKey struct {
+	schemaURL  string
+	attributes [16]byte
+}
+
+// keptResource is a resource shared by the series remembered with it.
+type keptResource struct {
+	key        resourceKey
+	attributes pcommon.Map
+	// remembered series of the resource
+	refs int
+}
+
+// scopeKey identifies a scope by its schema URL, name, version and attributes.
+type scopeKey struct {
+	schemaURL  string
+	name       string
+	version    string
+	attributes [16]byte
+}
+
+// keptMetric describes a kept metric and its remembered series.
+type keptMetric struct {
+	description string
+	unit        string
+	metricType  pmetric.MetricType
+	temporality pmetric.AggregationTemporality
+	monotonic   bool
+	series      map[uint64]*keptSeriesEntry
+}
+
+type keptSeriesEntry struct {
+	resource *keptResource
+	scope    scopeKey
+	// attributes of the scope and of the data point
+	scopeAttributes pcommon.Map
+	attributes      pcommon.Map
+	start           pcommon.Timestamp
+	seen            time.Time
+}
+
+func newKeptSeries(expiry time.Duration) *keptSeries {
+	return &keptSeries{
+		expiry:    expiry,
+		metrics:   map[usagestore.Key]*keptMetric{},
+		resources: map[resourceKey]*keptResource{},
+	}
+}
+
+// record remembers the series of the kept data point. Its attributes are
+// only copied the first time its series is kept.
+func (k *keptSeries) record(key usagestore.Key, mc *metricContext, dp any, attrs pcommon.Map, now time.Time) {
+	hash := seriesHash(mc, attrs)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	m, ok := k.metrics[key]
+	if !ok {
+		m = newKeptMetric(mc.metric)
+		k.metrics[key] = m
+	}
+	if entry, ok := m.series[hash]; ok {
+		entry.seen = now
+		return
+	}
+
+	scope := mc.scopeMetrics.Scope()
+	entry := &keptSeriesEntry{
+		resource: k.acquireResource(mc.resourceMetrics),
+		scope: scopeKey{
+			schemaURL:  mc.scopeMetrics.SchemaUrl(),
+			name:       scope.Name(),
+			version:    scope.Version(),
+			attributes: pdatautil.MapHash(scope.Attributes()),
+		},
+		scopeAttributes: pcommon.NewMap(),
+		attributes:      pcommon.NewMap(),
+		seen:            now,
+	}
+	scope.Attributes().CopyTo(entry.scopeAttributes)
+	attrs.CopyTo(entry.attributes)
+	if dp, ok := dp.(cumulativeDatapoint); ok {
+		entry.start = dp.StartTimestamp()
+	}
+	m.series[hash] = entry
+}
+
+func newKeptMetric(m pmetric.Metric) *keptMetric {
+	km := &keptMetric{
+		description: m.Description(),
+		unit:        m.Unit(),
+		metricType:  m.Type(),
+		series:      map[uint64]*keptSeriesEntry{},
+	}
+	switch m.Type() {
+	case pmetric.MetricTypeSum:
+		km.temporality = m.Sum().AggregationTemporality()
+		km.monotonic = m.Sum().IsMonotonic()
+	case pmetric.MetricTypeHistogram:
+		km.temporality = m.Histogram().AggregationTemporality()
+	case pmetric.MetricTypeExponentialHistogram:
+		km.temporality = m.ExponentialHistogram().AggregationTemporality()
+	}
+	return km
+}
+
+// acquireResource returns the remembered resource of a new series.
+func (k *keptSeries) acquireResource(rm pmetric.ResourceMetrics) *keptResource {
+	key := resourceKey{schemaURL: rm.SchemaUrl(), attributes: pdatautil.MapHash(rm.Resource().Attributes())}
+	r, ok := k.resources[key]
+	if !ok {
+		r = &keptResource{key: key, attributes: pcommon.NewMap()}
+		rm.Resource().Attributes().CopyTo(r.attributes)
+		k.resources[key] = r
+	}
+	r.refs++
+	return r
+}
+
+// releaseResource forgets the resource once none of its series is remembered.
+func (k *keptSeries) releaseResource(r *keptResource) {
+	r.refs--
+	if r.refs == 0 {
+		delete(k.resources, r.key)
+	}
+}
+
+// markStale adds a staleness marker for every remembered series of the
+// metric to markers, forgets them, and returns how many were added.
+func (k *keptSeries) markStale(key usagestore.Key, markers *staleMarkers, now time.Time) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	m, ok := k.metrics[key]
+	if !ok {
+		return 0
+	}
+	delete(k.metrics, key)
+
+	timestamp := pcommon.NewTimestampFromTime(now)
+	// the metric of the markers in each resource and scope
+	metrics := map[*pmetric.ScopeMetrics]pmetric.Metric{}
+	for _, entry := range m.series {
+		sm := markers.scope(entry)
+		metric, ok := metrics[sm]
+		if !ok {
+			metric = m.appendTo(sm.Metrics(), key.Metric)
+			metrics[sm] = metric
+		}
+		appendMarker(metric, entry, timestamp)
+		k.releaseResource(entry.resource)
+	}
+	return len(m.series)
+}
+
+// expire forgets the series that were not kept for the expiry.
+func (k *keptSeries) expire(now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key, m := range k.metrics {
+		for hash, entry := range m.series {
+			if now.Sub(entry.seen) >= k.expiry {
+				delete(m.series, hash)
+				k.releaseResource(entry.resource)
+			}
+		}
+		if len(m.series) == 0 {
+			delete(k.metrics, key)
+		}
+	}
+}
+
+// appendTo appends an empty metric of the shape of the kept metric.
+func (km *keptMetric) appendTo(metrics pmetric.MetricSlice, name string) pmetric.Metric {
+	m := metrics.AppendEmpty()
+	m.SetName(name)
+	m.SetDescription(km.description)
+	m.SetUnit(km.unit)
+	switch km.metricType {
+	case pmetric.MetricTypeGauge:
+		m.SetEmptyGauge()
+	case pmetric.MetricTypeSum:
+		sum := m.SetEmptySum()
+		sum.SetAggregationTemporality(km.temporality)
+		sum.SetIsMonotonic(km.monotonic)
+	case pmetric.MetricTypeHistogram:
+		m.SetEmptyHistogram().SetAggregationTemporality(km.temporality)
+	case pmetric.MetricTypeExponentialHistogram:
+		m.SetEmptyExponentialHistogram().SetAggregationTemporality(km.temporality)
+	case pmetric.MetricTypeSummary:
+		m.SetEmptySummary()
+	}
+	return m
+}
+
+// markerDatapoint is implemented by the data points of every metric type.
+type markerDatapoint interface {
+	Attributes() pcommon.Map
+	SetStartTimestamp(pcommon.Timestamp)
+	SetTimestamp(pcommon.Timestamp)
+	SetFlags(pmetric.DataPointFlags)
+}
+
+// appendMarker appends a data point of the series flagged as having no
+// recorded value, which Prometheus-compatible backends store as a staleness
+// marker.
+func appendMarker(m pmetric.Metric, entry *keptSeriesEntry, now pcommon.Timestamp) {
+	var dp markerDatapoint
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		dp = m.Gauge().DataPoints().AppendEmpty()
+	case pmetric.MetricTypeSum:
+		dp = m.Sum().DataPoints().AppendEmpty()
+	case pmetric.MetricTypeHistogram:
+		dp = m.Histogram().DataPoints().AppendEmpty()
+	case pmetric.MetricTypeExponentialHistogram:
+		dp = m.ExponentialHistogram().DataPoints().AppendEmpty()
+	case pmetric.MetricTypeSummary:
+		dp = m.Summary().DataPoints().AppendEmpty()
+	default:
+		return
+	}
+	entry.attributes.CopyTo(dp.Attributes())
+	dp.SetStartTimestamp(entry.start)
+	dp.SetTimestamp(now)
+	dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+}
+
+// staleMarkers are the staleness markers added to a batch, grouped by
+// resource and scope.
+type staleMarkers struct {
+	md        pmetric.Metrics
+	resources map[resourceKey]*markerResource
+}
+
+type markerResource struct {
+	rm     pmetric.ResourceMetrics
+	scopes map[scopeKey]*pmetric.ScopeMetrics
+}
+
+func newStaleMarkers() *staleMarkers {
+	return &staleMarkers{md: pmetric.NewMetrics(), resources: map[resourceKey]*markerResource{}}
+}
+
+// scope returns the scope of the markers of the series, adding it and its
+// resource to the markers if they are not there yet.
+func (s *staleMarkers) scope(entry *keptSeriesEntry) *pmetric.ScopeMetrics {
+	r, ok := s.resources[entry.resource.key]
+	if !ok {
+		rm := s.md.ResourceMetrics().AppendEmpty()
+		rm.SetSchemaUrl(entry.resource.key.schemaURL)
+		entry.resource.attributes.CopyTo(rm.Resource().Attributes())
+		r = &markerResource{rm: rm, scopes: map[scopeKey]*pmetric.ScopeMetrics{}}
+		s.resources[entry.resource.key] = r
+	}
+	sm, ok := r.scopes[entry.scope]
+	if !ok {
+		scopeMetrics := r.rm.ScopeMetrics().AppendEmpty()
+		scopeMetrics.SetSchemaUrl(entry.scope.schemaURL)
+		scopeMetrics.Scope().SetName(entry.scope.name)
+		scopeMetrics.Scope().SetVersion(entry.scope.version)
+		entry.scopeAttributes.CopyTo(scopeMetrics.Scope().Attributes())
+		sm = &scopeMetrics
+		r.scopes[entry.scope] = sm
+	}
+	return sm
+}
+
+// markUnused adds staleness markers for the series of the metric kept by
+// the previous batches to the batch, when it is dropped because it became
+// unused.
+func (sp *unusedMetricProcessor) markUnused(ctx context.Context, mc *metricContext, job string, metricName string) {
+	if sp.kept == nil {
+		return
+	}
+	n := sp.kept.markStale(usagestore.Key{Job: job, Metric: metricName}, mc.markers, time.Now())
+	if n == 0 {
+		return
+	}
+	sp.telemetry.OtelcolProcessorUnusedmetricStalenessMarkers.Add(ctx, int64(n), metric.WithAttributes(attribute.String("job", job)))
+}
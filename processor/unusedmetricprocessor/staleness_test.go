@@ -0,0 +1,129 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/processor/processortest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadatatest"
+)
+
+func TestStalenessMarkers(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.StalenessMarkers.Enabled = true
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, defaultStalenessExpiry, cfg.StalenessMarkers.Expiry)
+
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"queried": false}}}
+	sp, err := newProcessor(metadatatest.NewSettings(tel), cfg, f)
+	require.NoError(t, err)
+
+	newResourceBatch := func(series ...int64) pmetric.Metrics {
+		md := newSeriesBatch("queried", series...)
+		rm := md.ResourceMetrics().At(0)
+		rm.Resource().Attributes().PutStr("service.name", "checkout")
+		rm.ScopeMetrics().At(0).Metrics().At(0).Sum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+		return md
+	}
+	md, err := sp.processMetrics(context.Background(), newResourceBatch(1, 2))
+	require.NoError(t, err)
+	require.Equal(t, 2, md.DataPointCount())
+
+	// the metric becomes unused
+	f.decisions["myJob"]["queried"] = true
+	sp.store.Flush()
+	start := time.Now()
+	md, err = sp.processMetrics(context.Background(), newResourceBatch(1))
+	require.NoError(t, err)
+
+	// the markers of a resource are grouped
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	rm := md.ResourceMetrics().At(0)
+	require.Equal(t, map[string]any{"service.name": "checkout"}, rm.Resource().Attributes().AsRaw())
+	require.Equal(t, 1, rm.ScopeMetrics().Len())
+	require.Equal(t, 1, rm.ScopeMetrics().At(0).Metrics().Len())
+	m := rm.ScopeMetrics().At(0).Metrics().At(0)
+	require.Equal(t, "queried", m.Name())
+	require.Equal(t, pmetric.AggregationTemporalityCumulative, m.Sum().AggregationTemporality())
+	require.Equal(t, 2, m.Sum().DataPoints().Len())
+	series := map[string]bool{}
+	for i := 0; i < m.Sum().DataPoints().Len(); i++ {
+		dp := m.Sum().DataPoints().At(i)
+		require.True(t, dp.Flags().NoRecordedValue())
+		require.GreaterOrEqual(t, dp.Timestamp(), pcommon.NewTimestampFromTime(start))
+		series[dp.Attributes().AsRaw()["series"].(string)] = true
+	}
+	require.Equal(t, map[string]bool{"1": true, "2": true}, series)
+	require.Empty(t, sp.kept.resources)
+
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricStalenessMarkers(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attribute.String("job", "myJob")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp(), metricdatatest.IgnoreExemplars())
+
+	// the series are marked stale once
+	md, err = sp.processMetrics(context.Background(), newResourceBatch(1, 2))
+	require.NoError(t, err)
+	require.Zero(t, md.DataPointCount())
+}
+
+func TestStalenessMarkersDisabled(t *testing.T) {
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"queried": false}}}
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, f)
+	require.NoError(t, err)
+	require.Nil(t, sp.kept)
+
+	_, err = sp.processMetrics(context.Background(), newSeriesBatch("queried", 1))
+	require.NoError(t, err)
+	f.decisions["myJob"]["queried"] = true
+	sp.store.Flush()
+	md, err := sp.processMetrics(context.Background(), newSeriesBatch("queried", 1))
+	require.NoError(t, err)
+	require.Zero(t, md.DataPointCount())
+}
+
+func TestKeptSeriesExpire(t *testing.T) {
+	k := newKeptSeries(time.Minute)
+	md := newSeriesBatch("queried", 1, 2)
+	rm := md.ResourceMetrics().At(0)
+	m := rm.ScopeMetrics().At(0).Metrics().At(0)
+	mc := &metricContext{resourceMetrics: rm, scopeMetrics: rm.ScopeMetrics().At(0), metric: m}
+	key := usagestore.Key{Job: "myJob", Metric: "queried"}
+	now := time.Now()
+	for i := 0; i < m.Sum().DataPoints().Len(); i++ {
+		dp := m.Sum().DataPoints().At(i)
+		k.record(key, mc, dp, dp.Attributes(), now)
+	}
+	// series 1 is kept again, series 2 is no longer received
+	dp := m.Sum().DataPoints().At(0)
+	k.record(key, mc, dp, dp.Attributes(), now.Add(30*time.Second))
+
+	k.expire(now.Add(time.Minute))
+	markers := newStaleMarkers()
+	require.Equal(t, 1, k.markStale(key, markers, now))
+	require.Equal(t, "1", markers.md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints().At(0).Attributes().AsRaw()["series"])
+
+	k.record(key, mc, dp, dp.Attributes(), now)
+	k.expire(now.Add(time.Hour))
+	require.Empty(t, k.metrics)
+	require.Empty(t, k.resources)
+}
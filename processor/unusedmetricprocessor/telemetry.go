@@ -0,0 +1,103 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"context"
+	"time"
+
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/pdatautil"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/usagestore"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+)
+
+// Span attributes of the processor, in addition to those of the store.
+const (
+	attributeLookupMode         = "unusedmetric.lookup_mode"
+	attributeDroppedMetricCount = "unusedmetric.dropped_metric_count"
+	attributeCacheHits          = "unusedmetric.cache_hits"
+	attributeCacheMisses        = "unusedmetric.cache_misses"
+	attributeUnresolved         = "unusedmetric.unresolved"
+)
+
+// storeTelemetry records the measurements of the store owned by the processor.
+type storeTelemetry struct {
+	telemetry *metadata.TelemetryBuilder
+}
+
+func (t storeTelemetry) RecordBackendCall(ctx context.Context, operation string, duration time.Duration, err error) {
+	attrs := []attribute.KeyValue{attribute.String("operation", operation)}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error_class", usagestore.ErrorClass(err)))
+	}
+	t.telemetry.OtelcolProcessorUnusedmetricBackendDuration.Record(ctx, usagestore.Milliseconds(duration), metric.WithAttributes(attrs...))
+}
+
+func (t storeTelemetry) RecordIndexSize(ctx context.Context, size int64) {
+	t.telemetry.OtelcolProcessorUnusedmetricIndexSize.Record(ctx, size)
+}
+
+// metricTally counts the data points of a metric dropped and kept, by job.
+type metricTally map[string]*jobTally
+
+type jobTally struct {
+	dropped int64
+	kept    int64
+	// estimated size of the dropped data points
+	bytes int64
+	// distinct attribute sets of the dropped data points
+	series map[[16]byte]struct{}
+}
+
+func (t metricTally) job(job string) *jobTally {
+	jt, ok := t[job]
+	if !ok {
+		jt = &jobTally{}
+		t[job] = jt
+	}
+	return jt
+}
+
+func (t metricTally) drop(job string, dp any, attrs pcommon.Map) {
+	jt := t.job(job)
+	jt.dropped++
+	jt.bytes += metricdata.EstimatedSize(dp, attrs)
+	if jt.series == nil {
+		jt.series = map[[16]byte]struct{}{}
+	}
+	jt.series[pdatautil.MapHash(attrs)] = struct{}{}
+}
+
+func (t metricTally) keep(job string) {
+	t.job(job).kept++
+}
+
+// recordMetric records the data points of a metric dropped and kept. The
+// metric counts as dropped for a job when every data point of the job was
+// dropped, and as kept otherwise.
+func (sp *unusedMetricProcessor) recordMetric(ctx context.Context, metricType pmetric.MetricType, tally metricTally) {
+	for job, jt := range tally {
+		attrs := metric.WithAttributes(
+			attribute.String("job", job),
+			attribute.String("metric_type", metricdata.TypeName(metricType)),
+		)
+		if jt.kept == 0 {
+			sp.telemetry.OtelcolProcessorUnusedmetricDropped.Add(ctx, 1, attrs)
+		} else {
+			sp.telemetry.OtelcolProcessorUnusedmetricKept.Add(ctx, 1, attrs)
+			sp.telemetry.OtelcolProcessorUnusedmetricKeptDatapoints.Add(ctx, jt.kept, attrs)
+		}
+		if jt.dropped > 0 {
+			sp.telemetry.OtelcolProcessorUnusedmetricDroppedDatapoints.Add(ctx, jt.dropped, attrs)
+			sp.telemetry.OtelcolProcessorUnusedmetricBatchDroppedSeries.Add(ctx, int64(len(jt.series)), attrs)
+			sp.telemetry.OtelcolProcessorUnusedmetricBytesSaved.Add(ctx, jt.bytes, attrs)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package unusedmetricprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadatatest"
+)
+
+func TestProcessorTelemetry(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+	f := &fakeClient{
+		decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}},
+		errFor:    map[string]map[string]error{"myJob": {"broken_metric": &usage.StatusError{StatusCode: 500}}},
+	}
+	sp, err := newProcessor(metadatatest.NewSettings(tel), cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, sp.start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, sp.shutdown(context.Background())) })
+
+	md := newBatch("used_metric", "broken_metric")
+	// three data points of two series
+	m := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().AppendEmpty()
+	m.SetName("unused_metric")
+	dps := m.SetEmptyGauge().DataPoints()
+	for _, replica := range []string{"0", "0", "1"} {
+		attrs := dps.AppendEmpty().Attributes()
+		attrs.PutStr("job", "myJob")
+		attrs.PutStr("replica", replica)
+	}
+	_, err = sp.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+
+	gauge := attribute.NewSet(attribute.String("job", "myJob"), attribute.String("metric_type", "gauge"))
+	opts := []metricdatatest.Option{metricdatatest.IgnoreTimestamp(), metricdatatest.IgnoreExemplars()}
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricDropped(t, tel,
+		[]metricdata.DataPoint[int64]{{Attributes: gauge, Value: 1}}, opts...)
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricKept(t, tel,
+		[]metricdata.DataPoint[int64]{{Attributes: gauge, Value: 2}}, opts...)
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricDroppedDatapoints(t, tel,
+		[]metricdata.DataPoint[int64]{{Attributes: gauge, Value: 3}}, opts...)
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricKeptDatapoints(t, tel,
+		[]metricdata.DataPoint[int64]{{Attributes: gauge, Value: 2}}, opts...)
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricBatchDroppedSeries(t, tel,
+		[]metricdata.DataPoint[int64]{{Attributes: gauge, Value: 2}}, opts...)
+	// timestamps, "job" "myJob", "replica" and its value, and the value
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricBytesSaved(t, tel,
+		[]metricdata.DataPoint[int64]{{Attributes: gauge, Value: 3 * (16 + 8 + 8 + 8)}}, opts...)
+	metadatatest.AssertEqualOtelcolProcessorUnusedmetricError(t, tel,
+		[]metricdata.DataPoint[int64]{{
+			Attributes: attribute.NewSet(attribute.String("job", "myJob"), attribute.String("error_class", "status_code")),
+			Value:      1,
+		}}, opts...)
+
+	duration, err := tel.GetMetric("otelcol_otelcol_processor_unusedmetric_backend_duration")
+	require.NoError(t, err)
+	histogram := duration.Data.(metricdata.Histogram[float64])
+	require.Len(t, histogram.DataPoints, 2)
+	for _, dp := range histogram.DataPoints {
+		operation, _ := dp.Attributes.Value("operation")
+		require.Equal(t, "lookup", operation.AsString())
+	}
+	_, err = tel.GetMetric("otelcol_otelcol_processor_unusedmetric_batch_lookup_duration")
+	require.NoError(t, err)
+}
+
+func TestProcessorTracing(t *testing.T) {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	require.NoError(t, cfg.Validate())
+	f := &fakeClient{decisions: map[string]map[string]bool{"myJob": {"unused_metric": true}}}
+	sp, err := newProcessor(metadatatest.NewSettings(tel), cfg, f)
+	require.NoError(t, err)
+	require.NoError(t, sp.start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { require.NoError(t, sp.shutdown(context.Background())) })
+
+	_, err = sp.processMetrics(context.Background(), newBatch("unused_metric", "used_metric"))
+	require.NoError(t, err)
+	_, err = sp.processMetrics(context.Background(), newBatch("unused_metric"))
+	require.NoError(t, err)
+
+	spans := tel.SpanRecorder.Ended()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	// the second batch is answered from the cache
+	require.ElementsMatch(t, []string{
+		"usage/lookup", "usage/lookup", "unusedmetric/resolve_batch", "unusedmetric/process",
+		"unusedmetric/process",
+	}, names)
+
+	first, second := spans[3], spans[4]
+	require.Equal(t, first.SpanContext().SpanID(), spans[2].Parent().SpanID())
+	require.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Contains(t, first.Attributes(), attribute.Int(attributeCacheMisses, 2))
+	require.Contains(t, first.Attributes(), attribute.Int(attributeDroppedMetricCount, 1))
+	require.Contains(t, second.Attributes(), attribute.Int(attributeCacheHits, 1))
+}
@@ -0,0 +1,186 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor // import "github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor"
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/internal/metricdata"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+)
+
+const (
+	tierLocationDatapoint = "datapoint"
+	tierLocationResource  = "resource"
+
+	usedByAlerts         = "alerts"
+	usedByRecordingRules = "recording_rules"
+	usedByDashboards     = "dashboards"
+	usedByQueries        = "queries"
+)
+
+var (
+	defaultTierAttribute = "usage.tier"
+	defaultTierRules     = []TierRule{
+		{Name: "critical", UsedBy: []string{usedByAlerts}},
+		{Name: "standard", UsedBy: []string{usedByDashboards, usedByRecordingRules}},
+		{Name: "cold", UsedBy: []string{usedByQueries}},
+	}
+	defaultUnusedTier = "unused"
+
+	errTiersSnapshotMode = errors.New("tiers require the usage summaries of the server and cannot be used in snapshot lookup mode")
+)
+
+// tiers classifies the metrics by how they are used, from the usage summary
+// returned by the server.
+type tiers struct {
+	rules  []TierRule
+	unused string
+	// rules of the tiers with a series limit
+	limited map[string]TierRule
+}
+
+// newTiers returns the tiers of the configuration, nil if they are disabled.
+func newTiers(cfg TiersConfig) (*tiers, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	names := map[string]struct{}{cfg.UnusedTier: {}}
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("tiers::rules[%d]: name is required", i)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("tiers::rules[%d]: tier %q is already defined", i, rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		if rule.MaxSeries < 0 {
+			return nil, fmt.Errorf("tiers::rules[%d]: max_series must not be negative", i)
+		}
+		if len(rule.UsedBy) == 0 {
+			return nil, fmt.Errorf("tiers::rules[%d]: used_by is required", i)
+		}
+		for _, u := range rule.UsedBy {
+			if !slices.Contains([]string{usedByAlerts, usedByRecordingRules, usedByDashboards, usedByQueries}, u) {
+				return nil, fmt.Errorf("tiers::rules[%d]: used_by must be %q, %q, %q or %q, got %q",
+					i, usedByAlerts, usedByRecordingRules, usedByDashboards, usedByQueries, u)
+			}
+		}
+	}
+	t := &tiers{rules: cfg.Rules, unused: cfg.UnusedTier, limited: map[string]TierRule{}}
+	for _, rule := range cfg.Rules {
+		if rule.MaxSeries > 0 {
+			t.limited[rule.Name] = rule
+		}
+	}
+	return t, nil
+}
+
+// limit returns the rule of the tier if its series are limited.
+func (t *tiers) limit(tier string) (TierRule, bool) {
+	rule, ok := t.limited[tier]
+	return rule, ok
+}
+
+// classify returns the first tier whose usage the metric has. Unused
+// metrics belong to the unused tier, and the used metrics matching no tier,
+// such as those returned without a summary, to the first one so they are
+// never retained less than they should.
+func (t *tiers) classify(u usage.MetricUsage) string {
+	if u.Unused {
+		return t.unused
+	}
+	if u.Summary != nil {
+		for _, rule := range t.rules {
+			if rule.matches(u.Summary) {
+				return rule.Name
+			}
+		}
+	}
+	if len(t.rules) == 0 {
+		return t.unused
+	}
+	return t.rules[0].Name
+}
+
+func (r TierRule) matches(s *usage.MetricUsageSummary) bool {
+	for _, u := range r.UsedBy {
+		switch {
+		case u == usedByAlerts && s.AlertCount > 0,
+			u == usedByRecordingRules && s.RecordCount > 0,
+			u == usedByDashboards && s.DashboardCount > 0,
+			u == usedByQueries && s.QueryCount > 0:
+			return true
+		}
+	}
+	return false
+}
+
+// moveToResource moves the tier attribute of the data points to their
+// resource. Resources whose data points belong to several tiers are split
+// into one resource per tier, and data points without a tier end up in a
+// resource without the attribute.
+func moveToResource(md pmetric.Metrics, attribute string) {
+	var split []pmetric.ResourceMetrics
+	md.ResourceMetrics().RemoveIf(func(rm pmetric.ResourceMetrics) bool {
+		var tiers []string
+		forEachResourceDatapoint(rm, func(attrs pcommon.Map) {
+			if tier := tierOf(attrs, attribute); !slices.Contains(tiers, tier) {
+				tiers = append(tiers, tier)
+			}
+		})
+		if len(tiers) <= 1 {
+			setResourceTier(rm, attribute, tiers)
+			return false
+		}
+		for _, tier := range tiers {
+			tmp := pmetric.NewMetrics()
+			trm := tmp.ResourceMetrics().AppendEmpty()
+			rm.CopyTo(trm)
+			metricdata.RemoveDatapointsIf(tmp, func(_ pmetric.Metric, attrs pcommon.Map) bool {
+				return tierOf(attrs, attribute) != tier
+			})
+			setResourceTier(trm, attribute, []string{tier})
+			split = append(split, trm)
+		}
+		return true
+	})
+	for _, rm := range split {
+		rm.MoveTo(md.ResourceMetrics().AppendEmpty())
+	}
+}
+
+// setResourceTier removes the tier attribute from the data points of the
+// resource and sets the tier, if any, on the resource.
+func setResourceTier(rm pmetric.ResourceMetrics, attribute string, tiers []string) {
+	forEachResourceDatapoint(rm, func(attrs pcommon.Map) {
+		attrs.Remove(attribute)
+	})
+	if len(tiers) == 1 && tiers[0] != "" {
+		rm.Resource().Attributes().PutStr(attribute, tiers[0])
+	}
+}
+
+func forEachResourceDatapoint(rm pmetric.ResourceMetrics, fn func(attrs pcommon.Map)) {
+	for i := 0; i < rm.ScopeMetrics().Len(); i++ {
+		ms := rm.ScopeMetrics().At(i).Metrics()
+		for j := 0; j < ms.Len(); j++ {
+			metricdata.ForEachDatapoint(ms.At(j), func(_ any, attrs pcommon.Map) {
+				fn(attrs)
+			})
+		}
+	}
+}
+
+func tierOf(attrs pcommon.Map, attribute string) string {
+	if v, ok := attrs.Get(attribute); ok {
+		return v.Str()
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+// Copyright The OpenTelemetry Authors
+// SPDX-License-Identifier: Apache-2.0
+
+package unusedmetricprocessor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/pkg/usage"
+	"github.com/nicolastakashi/ntakashi-opentelemetry-collector/processor/unusedmetricprocessor/internal/metadata"
+)
+
+// summaryClient returns the usage summaries of the metrics, the metrics
+// without a summary are unused.
+type summaryClient struct {
+	fakeClient
+	summaries map[string]*usage.MetricUsageSummary
+}
+
+func (c *summaryClient) GetMetricUsage(_ context.Context, _ string, name string) (usage.MetricUsage, error) {
+	summary, ok := c.summaries[name]
+	return usage.MetricUsage{Name: name, Unused: !ok, Summary: summary}, nil
+}
+
+func newTierProcessor(t *testing.T, location string) *unusedMetricProcessor {
+	cfg := NewFactory().CreateDefaultConfig().(*Config)
+	cfg.Server.Address = "http://localhost:0"
+	cfg.Tiers.Enabled = true
+	cfg.Tiers.Location = location
+	require.NoError(t, cfg.Validate())
+
+	client := &summaryClient{summaries: map[string]*usage.MetricUsageSummary{
+		"alerted":   {AlertCount: 1, DashboardCount: 2},
+		"dashboard": {DashboardCount: 1},
+		"recorded":  {RecordCount: 1, QueryCount: 3},
+		"queried":   {QueryCount: 1},
+		"unknown":   nil,
+	}}
+	sp, err := newProcessor(processortest.NewNopSettings(metadata.Type), cfg, client)
+	require.NoError(t, err)
+	return sp
+}
+
+func TestTiersClassify(t *testing.T) {
+	sp := newTierProcessor(t, tierLocationDatapoint)
+	md, err := sp.processMetrics(context.Background(), newBatch("alerted", "dashboard", "recorded", "queried", "unknown", "unused"))
+	require.NoError(t, err)
+
+	got := map[string]string{}
+	metrics := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	for i := 0; i < metrics.Len(); i++ {
+		tier, ok := metrics.At(i).Gauge().DataPoints().At(0).Attributes().Get(defaultTierAttribute)
+		require.True(t, ok, metrics.At(i).Name())
+		got[metrics.At(i).Name()] = tier.Str()
+	}
+	require.Equal(t, map[string]string{
+		"alerted":   "critical",
+		"dashboard": "standard",
+		"recorded":  "standard",
+		"queried":   "cold",
+		// used without a summary, kept in the first tier
+		"unknown": "critical",
+		"unused":  "unused",
+	}, got)
+}
+
+func TestTiersExplained(t *testing.T) {
+	sp := newTierProcessor(t, tierLocationDatapoint)
+	_, err := sp.processMetrics(context.Background(), newBatch("queried", "unused"))
+	require.NoError(t, err)
+	handler := newAdminServer(sp).handler()
+
+	// the tiers keep every metric
+	for metric, tier := range map[string]string{"queried": "cold", "unused": "unused"} {
+		var exp explanation
+		doAdminRequest(t, handler, http.MethodGet, "/decisions/explain?job=myJob&metric="+metric, "", &exp)
+		require.Equal(t, actionKeep, exp.Action)
+		require.Equal(t, tier, exp.Tier)
+	}
+}
+
+func TestTiersOnResource(t *testing.T) {
+	sp := newTierProcessor(t, tierLocationResource)
+	md, err := sp.processMetrics(context.Background(), newBatch("alerted", "queried", "unused", "queried"))
+	require.NoError(t, err)
+	require.Equal(t, 4, md.MetricCount())
+
+	got := map[string][]string{}
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		rm := md.ResourceMetrics().At(i)
+		tier, ok := rm.Resource().Attributes().Get(defaultTierAttribute)
+		require.True(t, ok)
+		metrics := rm.ScopeMetrics().At(0).Metrics()
+		for j := 0; j < metrics.Len(); j++ {
+			_, ok := metrics.At(j).Gauge().DataPoints().At(0).Attributes().Get(defaultTierAttribute)
+			require.False(t, ok)
+			got[tier.Str()] = append(got[tier.Str()], metrics.At(j).Name())
+		}
+	}
+	require.Equal(t, map[string][]string{
+		"critical": {"alerted"},
+		"cold":     {"queried", "queried"},
+		"unused":   {"unused"},
+	}, got)
+}
+
+func TestTiersOnResourceSingleTier(t *testing.T) {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "shop")
+	dps := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty().SetEmptySum().DataPoints()
+	dps.AppendEmpty().Attributes().PutStr(defaultTierAttribute, "cold")
+	dps.AppendEmpty().Attributes().PutStr(defaultTierAttribute, "cold")
+
+	moveToResource(md, defaultTierAttribute)
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	require.Equal(t, map[string]any{"service.name": "shop", defaultTierAttribute: "cold"}, md.ResourceMetrics().At(0).Resource().Attributes().AsRaw())
+	require.Zero(t, dps.At(0).Attributes().Len())
+	require.Zero(t, dps.At(1).Attributes().Len())
+}
+
+func TestTiersConfig(t *testing.T) {
+	newConfig := func() *Config {
+		cfg := NewFactory().CreateDefaultConfig().(*Config)
+		cfg.Server.Address = "http://localhost:0"
+		cfg.Tiers.Enabled = true
+		return cfg
+	}
+
+	cfg := newConfig()
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, defaultTierAttribute, cfg.Tiers.Attribute)
+	require.Equal(t, tierLocationDatapoint, cfg.Tiers.Location)
+	require.Len(t, cfg.Tiers.Rules, len(defaultTierRules))
+	for i, rule := range defaultTierRules {
+		require.Equal(t, rule.Name, cfg.Tiers.Rules[i].Name)
+		require.Equal(t, rule.UsedBy, cfg.Tiers.Rules[i].UsedBy)
+	}
+	// defaulting the rules does not modify the default rules
+	require.Empty(t, defaultTierRules[0].OverflowAction)
+	require.Equal(t, defaultUnusedTier, cfg.Tiers.UnusedTier)
+
+	for name, tc := range map[string]struct {
+		modify func(cfg *Config)
+		err    string
+	}{
+		"snapshot mode": {
+			modify: func(cfg *Config) {
+				cfg.Lookup.Mode = lookupModeSnapshot
+				cfg.Snapshot.Jobs = []string{"myJob"}
+			},
+			err: "snapshot lookup mode",
+		},
+		"location": {
+			modify: func(cfg *Config) { cfg.Tiers.Location = "scope" },
+			err:    "tiers location",
+		},
+		"missing name": {
+			modify: func(cfg *Config) { cfg.Tiers.Rules = []TierRule{{UsedBy: []string{usedByAlerts}}} },
+			err:    "name is required",
+		},
+		"duplicate tier": {
+			modify: func(cfg *Config) {
+				cfg.Tiers.Rules = []TierRule{{Name: "unused", UsedBy: []string{usedByAlerts}}}
+			},
+			err: "already defined",
+		},
+		"missing used_by": {
+			modify: func(cfg *Config) { cfg.Tiers.Rules = []TierRule{{Name: "hot"}} },
+			err:    "used_by is required",
+		},
+		"invalid used_by": {
+			modify: func(cfg *Config) { cfg.Tiers.Rules = []TierRule{{Name: "hot", UsedBy: []string{"logs"}}} },
+			err:    "used_by must be",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := newConfig()
+			tc.modify(cfg)
+			require.ErrorContains(t, cfg.Validate(), tc.err)
+		})
+	}
+}